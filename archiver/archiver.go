@@ -0,0 +1,123 @@
+package archiver
+
+import (
+	sha256 "crypto/sha256"
+	binary "encoding/binary"
+	hex "encoding/hex"
+	log "log"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// SubscriptionRetryInterval : Delay between two subscription attempts while the broker is unreachable
+	SubscriptionRetryInterval = 30 * time.Second
+
+	// RedeliveryWindow : Period during which a redelivered message is looked up among archived messages before being archived again
+	RedeliveryWindow = 24 * time.Hour
+)
+
+var (
+	// ConversationTopicFilters : Shared subscription filters covering all private & group conversation topics,
+	// so that each message is archived by a single replica
+	ConversationTopicFilters = []string{
+		models.SharedTopicFilter(models.ArchiverShareName, models.PrivateConversationTopicPath+"#"),
+		models.SharedTopicFilter(models.ArchiverShareName, models.GroupConversationTopicPath+"#"),
+	}
+)
+
+// Start : Subscribe the service MQTT client to all conversation topics so that their messages are archived, retrying until subscribed.
+// Does nothing unless archiving is enabled by mqtt config.
+func Start(env *models.Env) {
+
+	config := env.Config().MQTT
+
+	if !config.Archive || config.BrokerURL == "" {
+		return
+	}
+
+	for {
+
+		err := Subscribe(env)
+
+		if err == nil {
+			log.Printf("Archiving messages of %v", ConversationTopicFilters)
+			return
+		}
+
+		log.Printf("Archiver subscription failed, retrying in %v : %v", SubscriptionRetryInterval, err)
+
+		time.Sleep(SubscriptionRetryInterval)
+	}
+}
+
+// Subscribe : Subscribe the service MQTT client to all conversation topics, archiving each received message.
+// Subscriptions are renewed by the client on reconnection.
+func Subscribe(env *models.Env) error {
+
+	for _, topicFilter := range ConversationTopicFilters {
+
+		err := env.MQTT.Subscribe(topicFilter, func(message *models.MQTTMessage) {
+
+			err := ArchiveMessage(env, message)
+
+			if err != nil {
+				log.Printf("Message on %s not archived : %v", message.Topic, err)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ArchiveMessage : Store message received on a conversation topic along with its sender, recipient or group & reception date.
+// Messages redelivered by the broker (QoS 1) are skipped if their earlier delivery was archived within RedeliveryWindow.
+func ArchiveMessage(env *models.Env, mqttMessage *models.MQTTMessage) error {
+
+	now := time.Now()
+
+	message, err := models.NewConversationMessage(mqttMessage.Topic, mqttMessage.Payload, now.UnixNano()/int64(time.Millisecond))
+
+	if err != nil {
+		return err
+	}
+
+	message.DeliveryKey = DeliveryKey(mqttMessage)
+
+	if mqttMessage.Duplicate {
+
+		since := now.Add(-RedeliveryWindow).UnixNano() / int64(time.Millisecond)
+
+		isArchived, err := env.MongoDB.HasArchivedConversationMessage(message.GroupConversationID != "", message.DeliveryKey, since)
+
+		if err != nil {
+			return err
+		}
+
+		if isArchived {
+			return nil
+		}
+	}
+
+	return env.MongoDB.ArchiveConversationMessage(message)
+}
+
+// DeliveryKey : Return digest of the topic, packet identifier & payload of a delivery, shared by its redeliveries.
+// Packet identifiers are reused by the broker once acknowledged, so the key only identifies a delivery within a short period.
+func DeliveryKey(mqttMessage *models.MQTTMessage) string {
+
+	packetID := make([]byte, 2)
+	binary.BigEndian.PutUint16(packetID, mqttMessage.PacketID)
+
+	hash := sha256.New()
+	hash.Write([]byte(mqttMessage.Topic))
+	hash.Write([]byte{0})
+	hash.Write(packetID)
+	hash.Write(mqttMessage.Payload)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
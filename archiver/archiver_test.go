@@ -0,0 +1,200 @@
+package archiver
+
+import (
+	strings "strings"
+	sync "sync"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+// fakeMQTTClient : MQTT client keeping subscriptions in memory, messages are delivered to them with Deliver
+type fakeMQTTClient struct {
+	subscriptions map[string]models.MQTTMessageHandler
+	lock          sync.Mutex
+}
+
+func newFakeMQTTClient() *fakeMQTTClient {
+	return &fakeMQTTClient{subscriptions: map[string]models.MQTTMessageHandler{}}
+}
+
+func (mqttClient *fakeMQTTClient) Publish(topic string, payload []byte) error {
+	return nil
+}
+
+func (mqttClient *fakeMQTTClient) Subscribe(topicFilter string, handler models.MQTTMessageHandler) error {
+
+	mqttClient.lock.Lock()
+	defer mqttClient.lock.Unlock()
+
+	mqttClient.subscriptions[topicFilter] = handler
+
+	return nil
+}
+
+func (mqttClient *fakeMQTTClient) Disconnect() {}
+
+// Deliver : Call handlers of the subscriptions matching the topic of message, as the broker would
+func (mqttClient *fakeMQTTClient) Deliver(message *models.MQTTMessage) {
+
+	mqttClient.lock.Lock()
+	handlers := []models.MQTTMessageHandler{}
+
+	for topicFilter, handler := range mqttClient.subscriptions {
+
+		// Shared subscriptions receive messages on the topics of their filter
+		if strings.HasPrefix(topicFilter, "$share/") {
+			topicFilter = strings.SplitN(topicFilter, "/", 3)[2]
+		}
+
+		if models.MatchTopic(topicFilter, message.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	mqttClient.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(message)
+	}
+}
+
+// messagesMongoDB : Archived messages store
+type messagesMongoDB struct {
+	models.MongoDBInterface
+
+	lock     sync.Mutex
+	messages []*models.ConversationMessage
+}
+
+func (mongoDB *messagesMongoDB) ArchiveConversationMessage(message *models.ConversationMessage) error {
+
+	mongoDB.lock.Lock()
+	defer mongoDB.lock.Unlock()
+
+	mongoDB.messages = append(mongoDB.messages, message)
+
+	return nil
+}
+
+func (mongoDB *messagesMongoDB) HasArchivedConversationMessage(group bool, deliveryKey string, sinceTimestamp int64) (bool, error) {
+
+	mongoDB.lock.Lock()
+	defer mongoDB.lock.Unlock()
+
+	for _, message := range mongoDB.messages {
+		if (message.GroupConversationID != "") == group && message.DeliveryKey == deliveryKey && message.Timestamp >= sinceTimestamp {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// newTestArchiverEnv : Return environment whose MQTT client is subscribed by the archiver
+func newTestArchiverEnv(t *testing.T) (*models.Env, *fakeMQTTClient, *messagesMongoDB) {
+
+	mqttClient := newFakeMQTTClient()
+	mongoDB := &messagesMongoDB{}
+
+	env := &models.Env{MQTT: mqttClient, MongoDB: mongoDB}
+
+	err := Subscribe(env)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return env, mqttClient, mongoDB
+}
+
+func TestArchiveMessages(t *testing.T) {
+
+	_, mqttClient, mongoDB := newTestArchiverEnv(t)
+
+	for i, topic := range []string{
+		"conversations/private/user1/user2",
+		"conversations/private/user2/user1",
+		"conversations/group/group1/user1",
+		"conversations/group/group1/system",
+		"conversations/private/user1",
+		"conversations/group/group1/user1/extra",
+		"conversations/private//user2",
+	} {
+		mqttClient.Deliver(&models.MQTTMessage{Topic: topic, Payload: []byte(topic), PacketID: uint16(i + 1)})
+	}
+
+	// Messages on malformed conversation topics are skipped
+	if len(mongoDB.messages) != 4 {
+		t.Fatalf("expected 4 archived messages, got %d", len(mongoDB.messages))
+	}
+
+	for i, expected := range []models.ConversationMessage{
+		{ConversationID: "user1:user2", Sender: "user1", Recipient: "user2"},
+		{ConversationID: "user1:user2", Sender: "user2", Recipient: "user1"},
+		{ConversationID: "group1", Sender: "user1", GroupConversationID: "group1"},
+		{ConversationID: "group1", Sender: models.GroupSystemTopicLevel, GroupConversationID: "group1"},
+	} {
+
+		message := mongoDB.messages[i]
+
+		if message.ConversationID != expected.ConversationID || message.Sender != expected.Sender ||
+			message.Recipient != expected.Recipient || message.GroupConversationID != expected.GroupConversationID {
+			t.Errorf("unexpected archived message %+v on %s", message, message.Topic)
+		}
+
+		if string(message.Payload) != message.Topic || message.Timestamp == 0 || message.MessageID == "" || message.DeliveryKey == "" {
+			t.Errorf("incomplete archived message %+v", message)
+		}
+	}
+}
+
+func TestArchiveRedeliveredMessages(t *testing.T) {
+
+	_, mqttClient, mongoDB := newTestArchiverEnv(t)
+
+	delivery := &models.MQTTMessage{Topic: "conversations/group/group1/user1", Payload: []byte("hello"), PacketID: 1}
+	redelivery := &models.MQTTMessage{Topic: delivery.Topic, Payload: delivery.Payload, PacketID: delivery.PacketID, Duplicate: true}
+
+	mqttClient.Deliver(delivery)
+	mqttClient.Deliver(redelivery)
+	mqttClient.Deliver(redelivery)
+
+	if len(mongoDB.messages) != 1 {
+		t.Fatalf("redelivered message archived %d times", len(mongoDB.messages))
+	}
+
+	// Same payload published again by the user is a new message
+	mqttClient.Deliver(&models.MQTTMessage{Topic: delivery.Topic, Payload: delivery.Payload, PacketID: 2})
+
+	// Redelivery of a message whose first delivery was lost is archived
+	mqttClient.Deliver(&models.MQTTMessage{Topic: delivery.Topic, Payload: []byte("lost"), PacketID: 3, Duplicate: true})
+
+	if len(mongoDB.messages) != 3 {
+		t.Fatalf("expected 3 archived messages, got %d", len(mongoDB.messages))
+	}
+
+	// Redeliveries outside the window are archived again
+	mongoDB.messages[0].Timestamp -= int64((RedeliveryWindow + time.Second) / time.Millisecond)
+
+	mqttClient.Deliver(redelivery)
+
+	if len(mongoDB.messages) != 4 {
+		t.Fatalf("expected redelivery outside the window to be archived")
+	}
+}
+
+func TestStartDisabled(t *testing.T) {
+
+	mqttClient := newFakeMQTTClient()
+
+	env := &models.Env{MQTT: mqttClient}
+	env.SetConfig(&models.Config{MQTT: models.MQTTConfig{BrokerURL: "tcp://localhost:1883"}})
+
+	// Returns without subscribing when archiving is disabled
+	Start(env)
+
+	if len(mqttClient.subscriptions) != 0 {
+		t.Fatalf("subscribed to %d topic filters with archiving disabled", len(mqttClient.subscriptions))
+	}
+}
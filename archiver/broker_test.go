@@ -0,0 +1,404 @@
+package archiver
+
+import (
+	bufio "bufio"
+	binary "encoding/binary"
+	io "io"
+	net "net"
+	sort "sort"
+	strings "strings"
+	sync "sync"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect    = 1
+	packetPublish    = 3
+	packetPuback     = 4
+	packetSubscribe  = 8
+	packetPingreq    = 12
+	packetDisconnect = 14
+)
+
+// testBroker : Embedded MQTT 3.1.1 broker, only implementing what the service MQTT client uses :
+// connection, QoS 1 subscriptions & publications, keep alive. Sessions are never persisted.
+type testBroker struct {
+	listener    net.Listener
+	lock        sync.Mutex
+	connections map[*testBrokerConnection]bool
+	clientIDs   []string
+	acked       map[uint16]int
+}
+
+// testBrokerConnection : Connected client along with its subscriptions
+type testBrokerConnection struct {
+	conn          net.Conn
+	writeLock     sync.Mutex
+	subscriptions []string
+}
+
+// newTestBroker : Start a broker listening on a random local port
+func newTestBroker(t *testing.T) *testBroker {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := &testBroker{listener: listener, connections: map[*testBrokerConnection]bool{}, acked: map[uint16]int{}}
+
+	go func() {
+
+		for {
+
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			connection := &testBrokerConnection{conn: conn}
+
+			broker.lock.Lock()
+			broker.connections[connection] = true
+			broker.lock.Unlock()
+
+			go broker.serve(connection)
+		}
+	}()
+
+	return broker
+}
+
+// URL : Return broker URL
+func (broker *testBroker) URL() string {
+	return "tcp://" + broker.listener.Addr().String()
+}
+
+// Close : Stop listening and drop all connections
+func (broker *testBroker) Close() {
+	broker.listener.Close()
+	broker.DropConnections()
+}
+
+// DropConnections : Close connections of all clients, as a restarting broker would
+func (broker *testBroker) DropConnections() {
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	for connection := range broker.connections {
+		connection.conn.Close()
+	}
+
+	broker.connections = map[*testBrokerConnection]bool{}
+}
+
+// Subscriptions : Return topic filters subscribed by connected clients
+func (broker *testBroker) Subscriptions() []string {
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	subscriptions := []string{}
+
+	for connection := range broker.connections {
+		subscriptions = append(subscriptions, connection.subscriptions...)
+	}
+
+	return subscriptions
+}
+
+// Acked : Return number of PUBACK received for a packet identifier
+func (broker *testBroker) Acked(packetID uint16) int {
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	return broker.acked[packetID]
+}
+
+// Deliver : Send message with QoS 1 to clients subscribed to its topic, flagged DUP if it is a redelivery
+func (broker *testBroker) Deliver(topic string, payload []byte, packetID uint16, duplicate bool) {
+
+	header := byte(packetPublish<<4 | 1<<1)
+
+	if duplicate {
+		header |= 1 << 3
+	}
+
+	body := append(encodeString(topic), byte(packetID>>8), byte(packetID))
+	body = append(body, payload...)
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	for connection := range broker.connections {
+
+		for _, topicFilter := range connection.subscriptions {
+
+			// Shared subscriptions receive messages on the topics of their filter
+			if strings.HasPrefix(topicFilter, "$share/") {
+				topicFilter = strings.SplitN(topicFilter, "/", 3)[2]
+			}
+
+			if models.MatchTopic(topicFilter, topic) {
+				connection.write(header, body)
+				break
+			}
+		}
+	}
+}
+
+// serve : Answer packets of a client until it disconnects
+func (broker *testBroker) serve(connection *testBrokerConnection) {
+
+	defer connection.conn.Close()
+
+	reader := bufio.NewReader(connection.conn)
+
+	for {
+
+		header, body, err := readPacket(reader)
+
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+
+		case packetConnect:
+
+			// Protocol name, level, flags & keep alive precede client ID
+			_, rest := decodeString(body)
+			clientID, _ := decodeString(rest[4:])
+
+			broker.lock.Lock()
+			broker.clientIDs = append(broker.clientIDs, clientID)
+			broker.lock.Unlock()
+
+			connection.write(0x20, []byte{0, 0})
+
+		case packetSubscribe:
+
+			packetID, rest := body[:2], body[2:]
+			grantedQoS := []byte{}
+
+			for len(rest) > 0 {
+
+				var topicFilter string
+				topicFilter, rest = decodeString(rest)
+				rest = rest[1:]
+
+				// Subscription to an already subscribed filter replaces it
+				broker.lock.Lock()
+
+				isSubscribed := false
+
+				for _, subscription := range connection.subscriptions {
+					isSubscribed = isSubscribed || subscription == topicFilter
+				}
+
+				if !isSubscribed {
+					connection.subscriptions = append(connection.subscriptions, topicFilter)
+				}
+
+				broker.lock.Unlock()
+
+				grantedQoS = append(grantedQoS, 1)
+			}
+
+			connection.write(0x90, append(append([]byte{}, packetID...), grantedQoS...))
+
+		case packetPublish:
+
+			topic, rest := decodeString(body)
+
+			if (header>>1)&3 > 0 {
+				connection.write(packetPuback<<4, rest[:2])
+				rest = rest[2:]
+			}
+
+			go broker.Deliver(topic, rest, 0xFFFF, false)
+
+		case packetPuback:
+
+			broker.lock.Lock()
+			broker.acked[binary.BigEndian.Uint16(body)]++
+			broker.lock.Unlock()
+
+		case packetPingreq:
+			connection.write(0xD0, nil)
+
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+// write : Send a packet to the client
+func (connection *testBrokerConnection) write(header byte, body []byte) {
+
+	connection.writeLock.Lock()
+	defer connection.writeLock.Unlock()
+
+	packet := []byte{header}
+	length := len(body)
+
+	for {
+
+		digit := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			digit |= 128
+		}
+
+		packet = append(packet, digit)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	connection.conn.Write(append(packet, body...))
+}
+
+// readPacket : Read fixed header & body of a packet
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+
+	header, err := reader.ReadByte()
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+
+	for multiplier := 1; ; multiplier *= 128 {
+
+		digit, err := reader.ReadByte()
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(digit&127) * multiplier
+
+		if digit&128 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+
+	return header, body, err
+}
+
+// encodeString : Return length-prefixed UTF-8 string
+func encodeString(value string) []byte {
+	return append([]byte{byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+// decodeString : Return length-prefixed UTF-8 string at the start of data, and the remaining data
+func decodeString(data []byte) (string, []byte) {
+
+	length := int(binary.BigEndian.Uint16(data))
+
+	return string(data[2 : 2+length]), data[2+length:]
+}
+
+// waitFor : Wait until condition is met, fail after 5 seconds
+func waitFor(t *testing.T, description string, condition func() bool) {
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+
+	t.Fatalf("timeout waiting for %s", description)
+}
+
+// archivedCount : Return number of archived messages
+func (mongoDB *messagesMongoDB) archivedCount() int {
+
+	mongoDB.lock.Lock()
+	defer mongoDB.lock.Unlock()
+
+	return len(mongoDB.messages)
+}
+
+func TestArchiverWithBroker(t *testing.T) {
+
+	broker := newTestBroker(t)
+	defer broker.Close()
+
+	mongoDB := &messagesMongoDB{}
+
+	env := &models.Env{MongoDB: mongoDB}
+	env.SetConfig(&models.Config{MQTT: models.MQTTConfig{BrokerURL: broker.URL(), Password: "password", ReplicaID: "replica1", Archive: true}})
+
+	mqttClient := models.NewMQTTClient(env)
+	env.MQTT = mqttClient
+	defer mqttClient.Disconnect()
+
+	// Returns once subscribed
+	Start(env)
+
+	subscriptions := broker.Subscriptions()
+	sort.Strings(subscriptions)
+
+	if len(subscriptions) != 2 || subscriptions[0] != ConversationTopicFilters[1] || subscriptions[1] != ConversationTopicFilters[0] {
+		t.Fatalf("unexpected subscriptions %v", subscriptions)
+	}
+
+	broker.lock.Lock()
+	clientIDs := broker.clientIDs
+	broker.lock.Unlock()
+
+	if len(clientIDs) != 1 || clientIDs[0] != "wave-management-service-replica1" {
+		t.Fatalf("unexpected client IDs %v", clientIDs)
+	}
+
+	// Messages are acknowledged and archived with the packet identifier of their delivery
+	broker.Deliver("conversations/private/user1/user2", []byte("hello"), 1, false)
+
+	waitFor(t, "message to be archived", func() bool { return mongoDB.archivedCount() == 1 && broker.Acked(1) == 1 })
+
+	expectedDeliveryKey := DeliveryKey(&models.MQTTMessage{Topic: "conversations/private/user1/user2", Payload: []byte("hello"), PacketID: 1})
+
+	if mongoDB.messages[0].DeliveryKey != expectedDeliveryKey || mongoDB.messages[0].Sender != "user1" {
+		t.Fatalf("unexpected archived message %+v", mongoDB.messages[0])
+	}
+
+	// Redelivery is acknowledged but not archived again, a new message reusing the packet identifier is
+	broker.Deliver("conversations/private/user1/user2", []byte("hello"), 1, true)
+	broker.Deliver("conversations/private/user1/user2", []byte("hello"), 1, false)
+
+	waitFor(t, "messages to be acknowledged", func() bool { return broker.Acked(1) == 3 })
+	waitFor(t, "message to be archived", func() bool { return mongoDB.archivedCount() == 2 })
+
+	// Subscriptions are renewed once reconnected to a broker which lost them
+	broker.DropConnections()
+
+	waitFor(t, "subscriptions to be renewed", func() bool { return len(broker.Subscriptions()) == 2 })
+
+	broker.Deliver("conversations/group/group1/user1", []byte("hello group"), 2, false)
+
+	waitFor(t, "message to be archived", func() bool { return mongoDB.archivedCount() == 3 })
+
+	// System messages published by the service are archived too
+	err := mqttClient.Publish("conversations/group/group1/system", []byte("system message"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "system message to be archived", func() bool { return mongoDB.archivedCount() == 4 })
+}
@@ -0,0 +1,129 @@
+package archiver
+
+import (
+	fmt "fmt"
+	log "log"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// DefaultPurgeInterval : Seconds between two purges of expired messages when none is configured
+	DefaultPurgeInterval = 3600
+
+	// millisecondsPerDay : Number of milliseconds in a retention day
+	millisecondsPerDay = int64(24 * time.Hour / time.Millisecond)
+)
+
+// PurgeReport : Number of archived messages deleted by a purge, or which would have been deleted in dry run
+type PurgeReport struct {
+	PrivateMessages int64
+	GroupMessages   int64
+	DryRun          bool
+}
+
+// PurgeExpiredMessages : Delete archived messages older than the global retention, or than the retention of their group if it overrides it.
+// In dry run, messages are only counted.
+func PurgeExpiredMessages(env *models.Env, dryRun bool) (*PurgeReport, error) {
+
+	report := &PurgeReport{DryRun: dryRun}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	globalRetentionDays := env.Config().MessageRetention.Days
+
+	groupConversations, err := env.MongoDB.GetGroupConversationsWithRetention()
+
+	if err != nil {
+		return report, err
+	}
+
+	if globalRetentionDays > 0 {
+
+		cutoff := now - int64(globalRetentionDays)*millisecondsPerDay
+
+		purged, err := env.MongoDB.PurgeConversationMessages(&models.ConversationMessagesPurge{BeforeTimestamp: cutoff, DryRun: dryRun})
+
+		if err != nil {
+			return report, err
+		}
+
+		report.PrivateMessages += purged
+
+		// Groups overriding the global retention are purged on their own so that no message is counted twice
+		overridingGroupConversationIDs := []string{}
+
+		for _, groupConversation := range groupConversations {
+			overridingGroupConversationIDs = append(overridingGroupConversationIDs, groupConversation.GroupConversationID)
+		}
+
+		purged, err = env.MongoDB.PurgeConversationMessages(&models.ConversationMessagesPurge{
+			Group:                   true,
+			ExcludedConversationIDs: overridingGroupConversationIDs,
+			BeforeTimestamp:         cutoff,
+			DryRun:                  dryRun,
+		})
+
+		if err != nil {
+			return report, err
+		}
+
+		report.GroupMessages += purged
+	}
+
+	for _, groupConversation := range groupConversations {
+
+		cutoff := now - int64(groupConversation.EffectiveRetentionDays(globalRetentionDays))*millisecondsPerDay
+
+		purged, err := env.MongoDB.PurgeConversationMessages(&models.ConversationMessagesPurge{
+			Group:           true,
+			ConversationID:  groupConversation.GroupConversationID,
+			BeforeTimestamp: cutoff,
+			DryRun:          dryRun,
+		})
+
+		if err != nil {
+			return report, err
+		}
+
+		report.GroupMessages += purged
+	}
+
+	return report, nil
+}
+
+// StartPurge : Periodically purge expired messages, as configured by messageRetention
+func StartPurge(env *models.Env) {
+
+	for {
+
+		config := env.Config().MessageRetention
+		interval := config.PurgeInterval
+
+		if interval <= 0 {
+			interval = DefaultPurgeInterval
+		}
+
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		report, err := PurgeExpiredMessages(env, config.DryRun)
+
+		if err != nil {
+			log.Println(err)
+		}
+
+		// Messages purged before an error are reported too
+		if report.PrivateMessages > 0 || report.GroupMessages > 0 {
+			log.Println(report)
+		}
+	}
+}
+
+// String : Return number of deleted messages as logged
+func (report *PurgeReport) String() string {
+
+	if report.DryRun {
+		return fmt.Sprintf("Purge dry run : %d private and %d group message(s) would be deleted", report.PrivateMessages, report.GroupMessages)
+	}
+
+	return fmt.Sprintf("Purged %d private and %d group message(s)", report.PrivateMessages, report.GroupMessages)
+}
@@ -0,0 +1,209 @@
+package archiver
+
+import (
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+// retentionMongoDB : Archived messages & group conversations store, recording purges
+type retentionMongoDB struct {
+	models.MongoDBInterface
+
+	messages           []*models.ConversationMessage
+	groupConversations []*models.GroupConversation
+	purges             []models.ConversationMessagesPurge
+}
+
+func (mongoDB *retentionMongoDB) GetGroupConversationsWithRetention() ([]*models.GroupConversation, error) {
+
+	groupConversations := []*models.GroupConversation{}
+
+	for _, groupConversation := range mongoDB.groupConversations {
+		if groupConversation.RetentionDays > 0 {
+			groupConversations = append(groupConversations, groupConversation)
+		}
+	}
+
+	return groupConversations, nil
+}
+
+func (mongoDB *retentionMongoDB) PurgeConversationMessages(purge *models.ConversationMessagesPurge) (int64, error) {
+
+	mongoDB.purges = append(mongoDB.purges, *purge)
+
+	remainingMessages := []*models.ConversationMessage{}
+	purged := int64(0)
+
+	for _, message := range mongoDB.messages {
+
+		isPurged := (message.GroupConversationID != "") == purge.Group && message.Timestamp < purge.BeforeTimestamp
+
+		if purge.ConversationID != "" {
+			isPurged = isPurged && message.ConversationID == purge.ConversationID
+		}
+
+		for _, excludedConversationID := range purge.ExcludedConversationIDs {
+			isPurged = isPurged && message.ConversationID != excludedConversationID
+		}
+
+		if isPurged {
+			purged++
+		}
+
+		if !isPurged || purge.DryRun {
+			remainingMessages = append(remainingMessages, message)
+		}
+	}
+
+	mongoDB.messages = remainingMessages
+
+	return purged, nil
+}
+
+// newTestRetentionMongoDB : Return store holding messages of a private conversation & of groups with a 1 day, a 100 days & no retention,
+// received 12 hours, 2 days & 20 days ago
+func newTestRetentionMongoDB(t *testing.T) *retentionMongoDB {
+
+	mongoDB := &retentionMongoDB{
+		groupConversations: []*models.GroupConversation{
+			{GroupConversationID: "short", RetentionDays: 1},
+			{GroupConversationID: "long", RetentionDays: 100},
+			{GroupConversationID: "default"},
+		},
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for _, age := range []time.Duration{12 * time.Hour, 2 * 24 * time.Hour, 20 * 24 * time.Hour} {
+
+		for _, topic := range []string{
+			"conversations/private/user1/user2",
+			"conversations/group/short/user1",
+			"conversations/group/long/user1",
+			"conversations/group/default/user1",
+		} {
+
+			message, err := models.NewConversationMessage(topic, nil, now-int64(age/time.Millisecond))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mongoDB.messages = append(mongoDB.messages, message)
+		}
+	}
+
+	return mongoDB
+}
+
+// remainingMessages : Return number of remaining messages by conversation ID
+func (mongoDB *retentionMongoDB) remainingMessages() map[string]int {
+
+	remainingMessages := map[string]int{}
+
+	for _, message := range mongoDB.messages {
+		remainingMessages[message.ConversationID]++
+	}
+
+	return remainingMessages
+}
+
+func TestPurgeExpiredMessages(t *testing.T) {
+
+	for _, test := range []struct {
+		globalRetentionDays int
+		privateMessages     int64
+		groupMessages       int64
+		remainingMessages   map[string]int
+	}{
+		// Only groups with their own retention are purged without global retention
+		{0, 0, 2, map[string]int{"user1:user2": 3, "short": 1, "long": 3, "default": 3}},
+		// Shortest of the global & group retentions applies
+		{10, 1, 4, map[string]int{"user1:user2": 2, "short": 1, "long": 2, "default": 2}},
+		{1, 2, 6, map[string]int{"user1:user2": 1, "short": 1, "long": 1, "default": 1}},
+	} {
+
+		mongoDB := newTestRetentionMongoDB(t)
+
+		env := &models.Env{MongoDB: mongoDB}
+		env.SetConfig(&models.Config{MessageRetention: models.MessageRetentionConfig{Days: test.globalRetentionDays}})
+
+		// Dry run counts messages without deleting them
+		report, err := PurgeExpiredMessages(env, true)
+
+		if err != nil || !report.DryRun || report.PrivateMessages != test.privateMessages || report.GroupMessages != test.groupMessages {
+			t.Fatalf("global retention %d : unexpected dry run report %+v : %v", test.globalRetentionDays, report, err)
+		}
+
+		if len(mongoDB.messages) != 12 {
+			t.Fatalf("global retention %d : dry run deleted %d messages", test.globalRetentionDays, 12-len(mongoDB.messages))
+		}
+
+		report, err = PurgeExpiredMessages(env, false)
+
+		if err != nil || report.DryRun || report.PrivateMessages != test.privateMessages || report.GroupMessages != test.groupMessages {
+			t.Fatalf("global retention %d : unexpected report %+v : %v", test.globalRetentionDays, report, err)
+		}
+
+		remainingMessages := mongoDB.remainingMessages()
+
+		for conversationID, expected := range test.remainingMessages {
+			if remainingMessages[conversationID] != expected {
+				t.Errorf("global retention %d : %d messages left in %s, expected %d", test.globalRetentionDays, remainingMessages[conversationID], conversationID, expected)
+			}
+		}
+
+		// Nothing is left to purge
+		report, err = PurgeExpiredMessages(env, false)
+
+		if err != nil || report.PrivateMessages != 0 || report.GroupMessages != 0 {
+			t.Fatalf("global retention %d : unexpected second report %+v : %v", test.globalRetentionDays, report, err)
+		}
+	}
+}
+
+// Groups overriding the global retention are purged on their own, never by the global pass
+func TestPurgeExpiredMessagesExcludesOverridingGroups(t *testing.T) {
+
+	mongoDB := newTestRetentionMongoDB(t)
+
+	env := &models.Env{MongoDB: mongoDB}
+	env.SetConfig(&models.Config{MessageRetention: models.MessageRetentionConfig{Days: 10}})
+
+	_, err := PurgeExpiredMessages(env, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globalGroupPurges := 0
+	groupPurges := map[string]int{}
+
+	for _, purge := range mongoDB.purges {
+
+		switch {
+
+		case purge.Group && purge.ConversationID == "":
+
+			globalGroupPurges++
+
+			excluded := map[string]bool{}
+
+			for _, excludedConversationID := range purge.ExcludedConversationIDs {
+				excluded[excludedConversationID] = true
+			}
+
+			if len(excluded) != 2 || !excluded["short"] || !excluded["long"] {
+				t.Errorf("global pass excludes %v, expected short & long", purge.ExcludedConversationIDs)
+			}
+
+		case purge.Group:
+			groupPurges[purge.ConversationID]++
+		}
+	}
+
+	if globalGroupPurges != 1 || len(groupPurges) != 2 || groupPurges["short"] != 1 || groupPurges["long"] != 1 {
+		t.Fatalf("unexpected group purges %v", mongoDB.purges)
+	}
+}
@@ -1,28 +1,35 @@
 package auth
 
 import (
-	json "encoding/json"
 	errors "errors"
 	fmt "fmt"
-	http "net/http"
+	log "log"
+	strings "strings"
+	time "time"
 	models "wave-messaging-management-service/models"
-	utils "wave-messaging-management-service/utils"
 
 	uuid "github.com/satori/go.uuid"
 	logruswrapper "github.com/terryvogelsang/logruswrapper"
-	bcrypt "golang.org/x/crypto/bcrypt"
+	singleflight "golang.org/x/sync/singleflight"
 )
 
-// CheckAuthentication : Return MQTT Auth Infos if provided auth token is valid,
-// an error if present, a boolean flag indicating if user was already cached and a boolean flag indicating wether token was updated in Redis
-func CheckAuthentication(env *models.Env, token string) (*models.MQTTAuthInfos, bool, bool, error) {
+var (
+	// verificationGroup : Deduplicates in-flight verifications by device & token
+	verificationGroup singleflight.Group
+)
+
+// CheckAuthentication : Return MQTT Auth Infos of the device session if provided auth token is valid,
+// an error if present, a boolean flag indicating if session was already cached and a boolean flag indicating wether token was updated in Redis.
+// Device ID is only used when token is not cached yet, cached sessions keep the device they were opened with.
+func CheckAuthentication(env *models.Env, token string, deviceID string) (*models.MQTTAuthInfos, bool, bool, error) {
 
 	// If no token, return an error
 	if token == "" {
 		return nil, false, false, errors.New("No Token Provided")
 	}
 
-	hashedToken, err := HashPassword(token)
+	deviceID, err := NormalizeDeviceID(deviceID)
+
 	if err != nil {
 		return nil, false, false, err
 	}
@@ -32,39 +39,71 @@ func CheckAuthentication(env *models.Env, token string) (*models.MQTTAuthInfos,
 
 	if cachedInternalUserID != "" {
 
+		cachedDeviceSession, err := TouchDeviceSession(env, cachedInternalUserID, DigestToken(env, token))
+
+		if err != nil {
+			log.Println(err)
+		}
+
+		// Apply sliding expiration & periodic re-validation
+		err = RefreshSession(env, token, cachedDeviceSession.ExpiresAt)
+
+		if err != nil {
+			return nil, false, false, err
+		}
+
 		// If yes : Return the cached infos
-		return models.NewMQTTAuthInfos(cachedInternalUserID, hashedToken), true, false, nil
+		// Passhash is already stored in profile, no need to compute it again
+		return models.NewMQTTAuthInfos(cachedInternalUserID, cachedDeviceSession.DeviceID, ""), true, false, nil
 
 	} else {
 
 		// If no : Verify with external endpoint
-		err = env.RefreshConfig()
+		err := env.RefreshConfig()
 
 		if err != nil {
 			return nil, false, false, err
 		}
 
-		MQTTAuthInfos, wasCached, wasTokenUpdated, err := VerifyTokenWithExternalEndpoint(env, token, hashedToken)
+		// Concurrent verifications of the same token from the same device are collapsed into a single one.
+		// Device IDs can't contain ':', so that keys of distinct devices never collide.
+		type verificationResult struct {
+			MQTTAuthInfos   *models.MQTTAuthInfos
+			WasCached       bool
+			WasTokenUpdated bool
+		}
+
+		result, err, _ := verificationGroup.Do(deviceID+":"+token, func() (interface{}, error) {
+
+			MQTTAuthInfos, wasCached, wasTokenUpdated, err := VerifyTokenWithExternalEndpoint(env, token, deviceID)
+
+			return &verificationResult{MQTTAuthInfos, wasCached, wasTokenUpdated}, err
+		})
 
 		if err != nil {
 			return nil, false, false, err
 		}
 
-		return MQTTAuthInfos, wasCached, wasTokenUpdated, nil
+		verification := result.(*verificationResult)
+
+		// Callers that waited for the verification get its outcome as is : a session opened by it was not cached before any of them called,
+		// so that they may all try to store the device profile (See router AddVerneMQACL). Each of them gets its own copy of the auth infos.
+		MQTTAuthInfos := *verification.MQTTAuthInfos
+
+		return &MQTTAuthInfos, verification.WasCached, verification.WasTokenUpdated, nil
 	}
 }
 
-// HashPassword : Hash password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// CheckIfTokenIsCached : Check if token is cached in Redis (under its digest)
+func CheckIfTokenIsCached(env *models.Env, token string) (string, error) {
+	return CheckIfTokenDigestIsCached(env, DigestToken(env, token))
 }
 
-// CheckIfTokenIsCached : Check if token is cached in Redis
-func CheckIfTokenIsCached(env *models.Env, token string) (string, error) {
+// CheckIfTokenDigestIsCached : Check if token digest is cached in Redis
+func CheckIfTokenDigestIsCached(env *models.Env, tokenDigest string) (string, error) {
 
 	// Check if token is cached in redis
-	cachedInternalUserID, err := env.Redis.Get(fmt.Sprintf("session:%s", token))
+	cachedInternalUserID, err := env.Redis.Get(fmt.Sprintf("session:%s", tokenDigest))
 
 	if err != nil {
 		return "", err
@@ -77,114 +116,260 @@ func CheckIfTokenIsCached(env *models.Env, token string) (string, error) {
 	return "", nil
 }
 
-// VerifyTokenWithExternalEndpoint : Verify token with provided external auth endpoint
-func VerifyTokenWithExternalEndpoint(env *models.Env, token string, hashedToken string) (*models.MQTTAuthInfos, bool, bool, error) {
+// VerifyTokenWithExternalEndpoint : Verify token with configured token verifier and create or update the mapping & device session it belongs to
+func VerifyTokenWithExternalEndpoint(env *models.Env, token string, deviceID string) (*models.MQTTAuthInfos, bool, bool, error) {
+
+	verifier, err := GetTokenVerifier(env)
 
-	// Create HTTP Client
-	client := &http.Client{}
+	if err != nil {
+		return nil, false, false, err
+	}
 
-	// Init request
-	req, err := http.NewRequest("GET", env.Config.AuthenticationCheckEndpoint, nil)
+	identity, err := verifier.Verify(env, token)
 
 	if err != nil {
 		return nil, false, false, err
 	}
 
-	// Add token header
-	req.Header.Add("token", token)
+	originalUserID := identity.OriginalUserID
+
+	// Serialize mapping updates of a user across replicas
+	release, err := AcquireLock(env, fmt.Sprintf("lock:mapping:%s", originalUserID))
 
-	// Execute request
-	res, err := client.Do(req)
 	if err != nil {
 		return nil, false, false, err
 	}
 
-	//=============================================================================
-	// Authentication endpoint should return the following if token is invalid :
-	//
-	// HTTP Status Code : 400 (Bad Request)
-	// Empty Body
-	//=============================================================================
-	// Authentication endpoint should return the following if token is valid :
-	//
-	// HTTP Status Code : 200 (OK)
-	// Header(s) : content-type:application/json
-	// {"UserID": "userID"}
-	//=============================================================================
-	if res.StatusCode == 200 {
+	defer release()
 
-		// Parse response to get Original User ID
-		authCheckerBody := utils.AuthCheckerBody{}
-		json.NewDecoder(res.Body).Decode(&authCheckerBody)
+	// Keep optional profile infos provided by verifier along with mapping
+	if identity.DisplayName != "" || len(identity.Roles) > 0 {
 
-		// Check if user already has a cached token
-		cachedInternalWaveUserID, cachedOldToken, _ := CheckIfUserAlreadyHasToken(env, authCheckerBody.OriginalUserID)
+		err = env.Redis.HSet(fmt.Sprintf("mapping:%s", originalUserID), "displayName", []byte(identity.DisplayName), "roles", []byte(strings.Join(identity.Roles, ",")))
 
-		if cachedOldToken != "" {
+		if err != nil {
+			return nil, false, false, err
+		}
+	}
 
-			// If yes : Update Redis with new token and revoke the older token
-			UpdateRedisAndMongoDBWithNewToken(env, authCheckerBody.OriginalUserID, cachedInternalWaveUserID, cachedOldToken, token, hashedToken)
+	tokenDigest := DigestToken(env, token)
 
-			// Return MQTTAuthInfos
-			return models.NewMQTTAuthInfos(cachedInternalWaveUserID, hashedToken), true, true, nil
+	// Token may have been cached by another replica while waiting for lock
+	cachedInternalUserID, _ := CheckIfTokenDigestIsCached(env, tokenDigest)
 
+	if cachedInternalUserID != "" {
+
+		cachedDeviceSession, _ := TouchDeviceSession(env, cachedInternalUserID, tokenDigest)
+
+		return models.NewMQTTAuthInfos(cachedInternalUserID, cachedDeviceSession.DeviceID, ""), true, false, nil
+	}
+
+	// Check if user already has a cached token
+	cachedInternalWaveUserID, cachedOldTokenDigest, _ := CheckIfUserAlreadyHasToken(env, originalUserID)
+
+	// Mapping may have lost its internal Wave user ID in a partial rotation
+	if cachedOldTokenDigest != "" && cachedInternalWaveUserID == "" {
+		cachedInternalWaveUserID, _ = RepairMapping(env, originalUserID)
+	}
+
+	// Token is either new or rotated : profile passhash has to be written with configured algorithm
+	hasher, err := GetPasswordHasher(env)
+
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	hashedToken, err := hasher.Hash(token)
+
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	if cachedInternalWaveUserID == "" {
+
+		// If no : Create new user mapping
+		mappingKey := fmt.Sprintf("mapping:%s", originalUserID)
+		newInternalWaveUserID := uuid.NewV4().String()
+
+		// Internal Wave user ID is only set if absent, so that a user can never be given two of them
+		wasCreated, err := env.Redis.HSetNX(mappingKey, "internalWaveUserID", []byte(newInternalWaveUserID))
+
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		if wasCreated {
+			cachedInternalWaveUserID = newInternalWaveUserID
 		} else {
 
-			// If no : Create new token store + mapping in Redis
-			newInternalWaveUserID := uuid.NewV4().String()
+			existingInternalWaveUserID, err := env.Redis.HGet(mappingKey, "internalWaveUserID")
 
-			// Store token in Redis
-			env.Redis.Set(fmt.Sprintf("session:%s", token), []byte(newInternalWaveUserID))
+			if err != nil {
+				return nil, false, false, err
+			}
+
+			cachedInternalWaveUserID = string(existingInternalWaveUserID)
+		}
+	}
 
-			// Store mapping in Redis
-			env.Redis.HSet(fmt.Sprintf("mapping:%s", authCheckerBody.OriginalUserID), "token", []byte(token), "internalWaveUserID", []byte(newInternalWaveUserID))
+	deviceSessions, err := GetDeviceSessions(env, cachedInternalWaveUserID)
 
-			// Return MQTTAuthInfos
-			return models.NewMQTTAuthInfos(newInternalWaveUserID, hashedToken), false, false, nil
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	deviceSession, hasDeviceSession := deviceSessions[deviceID]
+
+	if hasDeviceSession {
+
+		// If device already has a session : Update Redis with new token and revoke the older token of this device only
+		err = UpdateRedisAndMongoDBWithNewToken(env, originalUserID, cachedInternalWaveUserID, deviceSession, tokenDigest, hashedToken, identity.ExpiresAt)
+
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		// Return MQTTAuthInfos
+		return models.NewMQTTAuthInfos(cachedInternalWaveUserID, deviceID, hashedToken), true, true, nil
+	}
+
+	// If no : Open a new device session next to the other devices ones
+	err = StoreDeviceSession(env, originalUserID, cachedInternalWaveUserID, deviceID, tokenDigest, identity.ExpiresAt)
+
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	// Make room for the new device if user reached configured device limit
+	err = EvictOldestDeviceSessions(env, cachedInternalWaveUserID, deviceID)
+
+	if err != nil {
+		log.Println(err)
+	}
+
+	// Return MQTTAuthInfos, device profile has to be created
+	return models.NewMQTTAuthInfos(cachedInternalWaveUserID, deviceID, hashedToken), false, false, nil
+}
+
+// RefreshSession : Renew session TTL on access if sliding expiration is enabled, never past the token expiration date expiresAt if set, and
+// re-validate token with configured token verifier once per configured revalidation interval.
+// Returns an error if the token was revoked upstream, in which case the session is removed.
+func RefreshSession(env *models.Env, token string, expiresAt int64) error {
+
+	config := env.Config()
+
+	tokenDigest := DigestToken(env, token)
+	sessionKey := fmt.Sprintf("session:%s", tokenDigest)
+
+	if config.SessionRevalidationInterval > 0 {
+
+		revalidationKey := fmt.Sprintf("revalidation:%s", tokenDigest)
+
+		wasRecentlyValidated, err := env.Redis.Exists(revalidationKey)
+
+		if err != nil {
+			return err
+		}
+
+		if !wasRecentlyValidated {
+
+			verifier, err := GetTokenVerifier(env)
+
+			if err != nil {
+				return err
+			}
+
+			_, err = verifier.Verify(env, token)
+
+			if err != nil && err.Error() == logruswrapper.CodeInvalidToken {
+
+				// Token was revoked upstream : end session
+				env.Redis.Delete(sessionKey)
+				env.Redis.Delete(revalidationKey)
+
+				return err
+			}
+
+			// If the endpoint can't be reached, keep session and retry on next access
+			if err != nil {
+				log.Println(err)
+			} else {
+				env.Redis.SetEx(revalidationKey, []byte("1"), config.SessionRevalidationInterval)
+			}
 		}
 	}
 
-	return nil, false, false, errors.New(logruswrapper.CodeInvalidToken)
+	if config.SessionTTL > 0 && config.SessionSlidingTTL {
+		return env.Redis.Expire(sessionKey, sessionTTL(env, expiresAt))
+	}
+
+	return nil
 }
 
-// CheckIfUserAlreadyHasToken : Check if originalUserID is already matched with one token in redis
+// CheckIfUserAlreadyHasToken : Check if originalUserID is already matched with one token in redis,
+// return its internal Wave user ID and the digest of its latest token
 func CheckIfUserAlreadyHasToken(env *models.Env, originalUserID string) (string, string, error) {
 
-	cachedOldToken, err := env.Redis.HGet(fmt.Sprintf("mapping:%s", originalUserID), "token")
+	cachedOldTokenDigest, err := env.Redis.HGet(fmt.Sprintf("mapping:%s", originalUserID), "tokenDigest")
 	cachedInternalUserID, err := env.Redis.HGet(fmt.Sprintf("mapping:%s", originalUserID), "internalWaveUserID")
 
 	if err != nil {
 		return "", "", err
 	}
 
-	return string(cachedInternalUserID), string(cachedOldToken), nil
+	return string(cachedInternalUserID), string(cachedOldTokenDigest), nil
 }
 
-// UpdateRedisAndMongoDBWithNewToken : Update old token store and mapping with new token
-func UpdateRedisAndMongoDBWithNewToken(env *models.Env, originalUserID string, internalWaveUserID string, oldToken string, newToken string, newHashedToken string) error {
+// UpdateRedisAndMongoDBWithNewToken : Update session of a device and mapping with new token.
+// Redis keys are updated in a single transaction which also records the new passhash of the device profile in the passhash outbox,
+// the MongoDB profile is then updated from the outbox so that a failure is repaired by ReconcilePendingPasshashes.
+// New session expires with the new token if expiresAt is set.
+func UpdateRedisAndMongoDBWithNewToken(env *models.Env, originalUserID string, internalWaveUserID string, deviceSession *models.DeviceSession, newTokenDigest string, newHashedToken string, expiresAt int64) error {
 
-	// Update MongoDB Profile
-	err := env.MongoDB.UpdatePassHash(internalWaveUserID, newHashedToken)
+	clientID := models.DeviceClientID(internalWaveUserID, deviceSession.DeviceID)
 
-	if err != nil {
-		return err
+	// Update Redis Token Store Key :
+	// session:{oldTokenDigest} -> session:{newTokenDigest}
+	// Old session may already have expired, so new session is stored with a fresh TTL instead of being renamed
+	commands := []models.RedisCommand{sessionCommand(env, newTokenDigest, internalWaveUserID, expiresAt)}
+
+	if deviceSession.TokenDigest != "" && deviceSession.TokenDigest != newTokenDigest {
+		commands = append(commands, models.NewRedisCommand("DEL", fmt.Sprintf("session:%s", deviceSession.TokenDigest)))
 	}
 
-	// Update Redis Token Store Key :
-	// session:{oldToken} -> session:{newToken}
-	err = env.Redis.Rename(fmt.Sprintf("session:%s", oldToken), fmt.Sprintf("session:%s", newToken))
+	// Update Redis Device Session :
+	// sessions:{internalWaveUserID} {deviceID} {oldTokenDigest ...} --> sessions:{internalWaveUserID} {deviceID} {newTokenDigest ...}
+	rotatedDeviceSession := models.NewDeviceSession(deviceSession.DeviceID, newTokenDigest, deviceSession.CreatedAt, time.Now().Unix())
+	rotatedDeviceSession.ExpiresAt = expiresAt
+
+	deviceCommand, err := deviceSessionCommand(internalWaveUserID, rotatedDeviceSession)
 
 	if err != nil {
 		return err
 	}
 
 	// Update Redis Mapping Values :
-	// mapping:{originalUserID} token {oldToken} ... --> mapping:{originalUserID} token {newToken} ...
-	err = env.Redis.HSet(fmt.Sprintf("mapping:%s", originalUserID), "token", []byte(newToken), "internalWaveUserID", nil)
+	// mapping:{originalUserID} tokenDigest {oldTokenDigest} ... --> mapping:{originalUserID} tokenDigest {newTokenDigest} ...
+	commands = append(commands,
+		deviceCommand,
+		models.NewRedisCommand("HSET", fmt.Sprintf("mapping:%s", originalUserID), "tokenDigest", newTokenDigest),
+		reverseMappingCommand(internalWaveUserID, originalUserID),
+		models.NewRedisCommand("HSET", PasshashOutboxKey, clientID, newHashedToken),
+	)
+
+	err = env.Redis.Transaction(commands...)
 
 	if err != nil {
 		return err
 	}
 
+	// Update MongoDB Profile
+	// On failure, pending outbox entry is kept and applied later by reconciliation
+	err = ApplyPendingPasshash(env, clientID, newHashedToken)
+
+	if err != nil {
+		log.Printf("Passhash update of %s postponed to reconciliation : %v", clientID, err)
+	}
+
 	return nil
 }
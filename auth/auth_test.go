@@ -0,0 +1,528 @@
+package auth
+
+import (
+	base64 "encoding/base64"
+	fmt "fmt"
+	ioutil "io/ioutil"
+	http "net/http"
+	httptest "net/http/httptest"
+	os "os"
+	strings "strings"
+	sync "sync"
+	atomic "sync/atomic"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+
+	redisgo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	// testRedisDatabase : Redis database flushed by auth tests, other packages use their own
+	testRedisDatabase = 1
+
+	// testPoolMaxActive : Connections of the test Redis pool, far less than concurrent callers so that they wait for each other
+	testPoolMaxActive = 4
+)
+
+// newTestEnv : Return environment backed by the Redis instance at WAVE_TEST_REDIS_ADDRESS through the connection pool of models.Redis,
+// with config file pointing to authEndpoint. Test is skipped if no Redis instance is provided.
+func newTestEnv(t testing.TB, authEndpoint string) (*models.Env, func()) {
+	return newTestEnvWithConfig(t, fmt.Sprintf(`{"authenticationCheckEndpoint": %q, "bcryptCost": 4}`, authEndpoint))
+}
+
+// newTestEnvWithConfig : Return environment backed by the test Redis instance, with config file holding config
+func newTestEnvWithConfig(t testing.TB, config string) (*models.Env, func()) {
+
+	redisAddress := os.Getenv("WAVE_TEST_REDIS_ADDRESS")
+
+	if redisAddress == "" {
+		t.Skip("WAVE_TEST_REDIS_ADDRESS is not set")
+	}
+
+	redis := models.NewRedis(fmt.Sprintf("redis://%s/%d", redisAddress, testRedisDatabase), "", models.RedisPoolConfig{
+		MaxIdle:     testPoolMaxActive,
+		MaxActive:   testPoolMaxActive,
+		IdleTimeout: 60,
+	})
+
+	conn := redis.Pool.Get()
+	_, err := conn.Do("FLUSHDB")
+	conn.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile, err := ioutil.TempFile("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = configFile.WriteString(config)
+	configFile.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("WAVE_CONFIG_FILE_PATH", configFile.Name())
+
+	env := &models.Env{Redis: redis}
+
+	err = env.RefreshConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return env, func() {
+		redis.CloseConnection()
+		os.Remove(configFile.Name())
+	}
+}
+
+// newTestAuthEndpoint : Return external authentication endpoint accepting tokens of the form {originalUserID}.{anything},
+// counting verifications
+func newTestAuthEndpoint(verifications *int32) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(verifications, 1)
+
+		parts := strings.SplitN(r.Header.Get("token"), ".", 2)
+
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(w, `{"userID": %q}`, parts[0])
+	}))
+}
+
+// Run with -race : many callers share a handful of pooled Redis connections
+func TestCheckAuthenticationConcurrent(t *testing.T) {
+
+	var verifications int32
+
+	authEndpoint := newTestAuthEndpoint(&verifications)
+	defer authEndpoint.Close()
+
+	env, closeEnv := newTestEnv(t, authEndpoint.URL)
+	defer closeEnv()
+
+	const users = 10
+	const devicesPerUser = 3
+	const callsPerDevice = 10
+
+	type call struct {
+		originalUserID string
+		deviceID       string
+		token          string
+	}
+
+	calls := []call{}
+
+	for u := 0; u < users; u++ {
+		for d := 0; d < devicesPerUser; d++ {
+			for c := 0; c < callsPerDevice; c++ {
+				calls = append(calls, call{
+					originalUserID: fmt.Sprintf("user%d", u),
+					deviceID:       fmt.Sprintf("device%d", d),
+					token:          fmt.Sprintf("user%d.device%d", u, d),
+				})
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	internalWaveUserIDs := map[string]map[string]bool{}
+	clientIDs := map[string]map[string]bool{}
+
+	firstRoundVerifications := int32(0)
+
+	// Every call is made twice : first ones race for the verification of a token, second ones hit the cache
+	for round := 0; round < 2; round++ {
+
+		for _, c := range calls {
+
+			wg.Add(1)
+
+			go func(c call) {
+				defer wg.Done()
+
+				MQTTAuthInfos, _, _, err := CheckAuthentication(env, c.token, c.deviceID)
+
+				if err != nil {
+					t.Errorf("%s : %v", c.token, err)
+					return
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if internalWaveUserIDs[c.originalUserID] == nil {
+					internalWaveUserIDs[c.originalUserID] = map[string]bool{}
+				}
+
+				if clientIDs[c.token] == nil {
+					clientIDs[c.token] = map[string]bool{}
+				}
+
+				internalWaveUserIDs[c.originalUserID][MQTTAuthInfos.Username] = true
+				clientIDs[c.token][MQTTAuthInfos.ClientID] = true
+			}(c)
+		}
+
+		wg.Wait()
+
+		if round == 0 {
+			firstRoundVerifications = atomic.LoadInt32(&verifications)
+		}
+	}
+
+	// Concurrent callers of a token share verifications, cached sessions are not verified again
+	if firstRoundVerifications < users*devicesPerUser || firstRoundVerifications >= int32(len(calls)) {
+		t.Errorf("unexpected %d verifications of %d tokens", firstRoundVerifications, users*devicesPerUser)
+	}
+
+	if verifications != firstRoundVerifications {
+		t.Errorf("cached sessions verified again : %d verifications", verifications-firstRoundVerifications)
+	}
+
+	// All devices of a user share a single internal Wave user ID, each device keeps its own client ID
+	for originalUserID, ids := range internalWaveUserIDs {
+
+		if len(ids) != 1 {
+			t.Errorf("%s mapped to %d internal Wave user IDs", originalUserID, len(ids))
+		}
+	}
+
+	for token, ids := range clientIDs {
+
+		if len(ids) != 1 {
+			t.Errorf("%s authenticated as %d client IDs", token, len(ids))
+		}
+	}
+
+	for u := 0; u < users; u++ {
+
+		originalUserID := fmt.Sprintf("user%d", u)
+
+		for internalWaveUserID := range internalWaveUserIDs[originalUserID] {
+
+			deviceSessions, err := GetDeviceSessions(env, internalWaveUserID)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(deviceSessions) != devicesPerUser {
+				t.Errorf("%s has %d device sessions", originalUserID, len(deviceSessions))
+			}
+
+			mappedOriginalUserID, err := GetOriginalUserID(env, internalWaveUserID)
+
+			if err != nil || mappedOriginalUserID != originalUserID {
+				t.Errorf("%s reverse mapped to %s : %v", internalWaveUserID, mappedOriginalUserID, err)
+			}
+		}
+	}
+}
+
+// Concurrent verifications are only shared by callers of the same device
+func TestCheckAuthenticationConcurrentDevices(t *testing.T) {
+
+	var verifications int32
+
+	// Verifications are held until one of each device is in flight (or a second elapsed)
+	authEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&verifications, 1)
+
+		for i := 0; i < 100 && atomic.LoadInt32(&verifications) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		fmt.Fprint(w, `{"userID": "user1"}`)
+	}))
+
+	defer authEndpoint.Close()
+
+	env, closeEnv := newTestEnv(t, authEndpoint.URL)
+	defer closeEnv()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	internalWaveUserIDs := map[string]bool{}
+
+	for i := 0; i < 10; i++ {
+		for _, deviceID := range []string{"device1", "device2"} {
+
+			wg.Add(1)
+
+			go func(deviceID string) {
+				defer wg.Done()
+
+				MQTTAuthInfos, _, _, err := CheckAuthentication(env, "user1.shared", deviceID)
+
+				if err != nil {
+					t.Errorf("%s : %v", deviceID, err)
+					return
+				}
+
+				mutex.Lock()
+				internalWaveUserIDs[MQTTAuthInfos.Username] = true
+				mutex.Unlock()
+			}(deviceID)
+		}
+	}
+
+	wg.Wait()
+
+	if verifications < 2 || verifications >= 20 {
+		t.Errorf("expected a verification per device, got %d", verifications)
+	}
+
+	if len(internalWaveUserIDs) != 1 {
+		t.Errorf("user1 mapped to %d internal Wave user IDs", len(internalWaveUserIDs))
+	}
+}
+
+// Callers sharing the verification that opens a session are all told it was not cached, with the passhash of its profile
+func TestCheckAuthenticationSharedVerification(t *testing.T) {
+
+	var verifications int32
+
+	release := make(chan struct{})
+
+	authEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&verifications, 1)
+		<-release
+
+		fmt.Fprint(w, `{"userID": "user1"}`)
+	}))
+
+	defer authEndpoint.Close()
+
+	env, closeEnv := newTestEnv(t, authEndpoint.URL)
+	defer closeEnv()
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+
+	MQTTAuthInfos := make([]*models.MQTTAuthInfos, callers)
+	wasCached := make([]bool, callers)
+
+	for i := 0; i < callers; i++ {
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+
+			MQTTAuthInfos[i], wasCached[i], _, err = CheckAuthentication(env, "user1.device1", "device1")
+
+			if err != nil {
+				t.Errorf("caller %d : %v", i, err)
+			}
+		}(i)
+	}
+
+	// Verification is held until other callers waited for it
+	for i := 0; i < 100 && atomic.LoadInt32(&verifications) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+
+	if t.Failed() {
+		return
+	}
+
+	if verifications != 1 {
+		t.Fatalf("expected a single verification, got %d", verifications)
+	}
+
+	for i := 0; i < callers; i++ {
+		if wasCached[i] || MQTTAuthInfos[i].Password == "" || MQTTAuthInfos[i].Password != MQTTAuthInfos[0].Password {
+			t.Errorf("caller %d : expected uncached session with its passhash, got cached %v, %+v", i, wasCached[i], MQTTAuthInfos[i])
+		}
+	}
+
+	// Callers get their own copy of the auth infos
+	if MQTTAuthInfos[0] == MQTTAuthInfos[1] {
+		t.Errorf("auth infos shared by callers")
+	}
+}
+
+// Profile infos provided by the verifier are written along with the mapping, under its lock
+func TestVerifyTokenProfileInfos(t *testing.T) {
+
+	authEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"userID": %q, "name": "User", "roles": ["admin", "member"]}`, strings.SplitN(r.Header.Get("token"), ".", 2)[0])
+	}))
+
+	defer authEndpoint.Close()
+
+	env, closeEnv := newTestEnvWithConfig(t, fmt.Sprintf(`{"authenticationCheckEndpoint": %q, "bcryptCost": 4, "externalAuthentication": {"displayNamePath": "name", "rolesPath": "roles"}}`, authEndpoint.URL))
+	defer closeEnv()
+
+	release, err := AcquireLock(env, "lock:mapping:user1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+
+	go func() {
+		_, _, _, err := VerifyTokenWithExternalEndpoint(env, "user1.device1", "device1")
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if displayName, _ := env.Redis.HGet("mapping:user1", "displayName"); len(displayName) != 0 {
+		t.Errorf("profile infos written while mapping is locked")
+	}
+
+	release()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := env.Redis.HGetMulti([]string{"mapping:user1"}, "displayName")
+
+	if err == nil && string(values[0]) == "User" {
+		values, err = env.Redis.HGetMulti([]string{"mapping:user1"}, "roles")
+	}
+
+	if err != nil || string(values[0]) != "admin,member" {
+		t.Errorf("expected profile infos to be stored, got %q, %v", values, err)
+	}
+
+	// Profile infos that can't be written fail the verification, even if another replica cached the session meanwhile
+	err = env.Redis.Set("mapping:user2", []byte("not a hash"))
+
+	if err == nil {
+		err = env.Redis.Set("session:"+DigestToken(env, "user2.device1"), []byte("internal-user2"))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := VerifyTokenWithExternalEndpoint(env, "user2.device1", "device1"); err == nil {
+		t.Errorf("expected profile infos write error")
+	}
+}
+
+// Cached path : session lookup, sliding expiration & device session update
+func BenchmarkCheckAuthentication(b *testing.B) {
+
+	var verifications int32
+
+	authEndpoint := newTestAuthEndpoint(&verifications)
+	defer authEndpoint.Close()
+
+	env, closeEnv := newTestEnv(b, authEndpoint.URL)
+	defer closeEnv()
+
+	_, _, _, err := CheckAuthentication(env, "user1.device1", "device1")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+
+			_, wasCached, _, err := CheckAuthentication(env, "user1.device1", "device1")
+
+			if err != nil || !wasCached {
+				b.Errorf("expected cached session : %v", err)
+				return
+			}
+		}
+	})
+
+	b.StopTimer()
+
+	if verifications != 1 {
+		b.Fatalf("cached session verified %d times", verifications)
+	}
+}
+
+func TestSessionTTLCappedAtTokenExpiration(t *testing.T) {
+
+	secret := []byte("secret")
+
+	// JWTs are longer than the 72 bytes bcrypt hashes
+	env, closeEnv := newTestEnvWithConfig(t, fmt.Sprintf(`{
+		"authenticationMode": "jwt",
+		"passwordHashAlgorithm": "sha256",
+		"sessionTTL": 3600,
+		"sessionSlidingTTL": true,
+		"jwt": {"algorithms": ["HS256"], "jwks": {"keys": [{"kty": "oct", "kid": "key1", "k": %q}]}}
+	}`, base64.RawURLEncoding.EncodeToString(secret)))
+
+	defer closeEnv()
+
+	sessionTTL := func(token string) int {
+
+		conn := env.Redis.(*models.Redis).Pool.Get()
+		defer conn.Close()
+
+		ttl, err := redisgo.Int(conn.Do("TTL", fmt.Sprintf("session:%s", DigestToken(env, token))))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ttl
+	}
+
+	for _, test := range []struct {
+		expiresIn int64
+		maxTTL    int
+		minTTL    int
+	}{
+		// Session expires with its token
+		{60, 60, 55},
+		// Configured session TTL applies to tokens outliving it
+		{7200, 3600, 3595},
+	} {
+
+		token := signTestJWT(t, "HS256", "key1", secret, testClaims(fmt.Sprintf("user%d", test.expiresIn), test.expiresIn))
+
+		// Opening the session, then sliding its expiration
+		for i := 0; i < 2; i++ {
+
+			_, _, _, err := CheckAuthentication(env, token, "")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ttl := sessionTTL(token); ttl > test.maxTTL || ttl < test.minTTL {
+				t.Fatalf("token expiring in %d seconds : unexpected session TTL %d", test.expiresIn, ttl)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	expvar "expvar"
+	sync "sync"
+	time "time"
+)
+
+const (
+	// CircuitClosed : Calls go through
+	CircuitClosed = "closed"
+
+	// CircuitOpen : Calls fail fast until open duration elapsed
+	CircuitOpen = "open"
+
+	// CircuitHalfOpen : A single trial call goes through to decide whether circuit closes again
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker : Consecutive failures circuit breaker, exposing its state & counters as expvar metrics
+type CircuitBreaker struct {
+	lock                sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	isTrialInFlight     bool
+	failureThreshold    int
+	openDuration        time.Duration
+	metrics             *expvar.Map
+}
+
+// NewCircuitBreaker : Return a new closed circuit breaker publishing its metrics under name
+func NewCircuitBreaker(name string) *CircuitBreaker {
+
+	circuitBreaker := &CircuitBreaker{
+		state:   CircuitClosed,
+		metrics: expvar.NewMap(name),
+	}
+
+	circuitBreaker.metrics.Set("state", expvar.Func(func() interface{} {
+		return circuitBreaker.State()
+	}))
+
+	return circuitBreaker
+}
+
+// Configure : Set number of consecutive failures opening the circuit and duration it stays open
+func (circuitBreaker *CircuitBreaker) Configure(failureThreshold int, openDuration time.Duration) {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	circuitBreaker.failureThreshold = failureThreshold
+	circuitBreaker.openDuration = openDuration
+}
+
+// State : Return current circuit state
+func (circuitBreaker *CircuitBreaker) State() string {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	return circuitBreaker.state
+}
+
+// Allow : Check if a call may go through, switching an open circuit to half-open once open duration elapsed
+func (circuitBreaker *CircuitBreaker) Allow() bool {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	switch circuitBreaker.state {
+
+	case CircuitOpen:
+
+		if time.Since(circuitBreaker.openedAt) < circuitBreaker.openDuration {
+			circuitBreaker.metrics.Add("rejected", 1)
+			return false
+		}
+
+		circuitBreaker.state = CircuitHalfOpen
+		circuitBreaker.isTrialInFlight = true
+
+		return true
+
+	case CircuitHalfOpen:
+
+		// Only one trial call at a time
+		if circuitBreaker.isTrialInFlight {
+			circuitBreaker.metrics.Add("rejected", 1)
+			return false
+		}
+
+		circuitBreaker.isTrialInFlight = true
+
+		return true
+	}
+
+	return true
+}
+
+// Success : Record a successful call, closing the circuit
+func (circuitBreaker *CircuitBreaker) Success() {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	circuitBreaker.metrics.Add("successes", 1)
+
+	circuitBreaker.state = CircuitClosed
+	circuitBreaker.consecutiveFailures = 0
+	circuitBreaker.isTrialInFlight = false
+}
+
+// Release : Give up a call allowed by Allow without recording its outcome, letting another trial call through if circuit is half-open
+func (circuitBreaker *CircuitBreaker) Release() {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	circuitBreaker.isTrialInFlight = false
+}
+
+// Failure : Record a failed call, opening the circuit if failure threshold is reached or trial call failed
+func (circuitBreaker *CircuitBreaker) Failure() {
+
+	circuitBreaker.lock.Lock()
+	defer circuitBreaker.lock.Unlock()
+
+	circuitBreaker.metrics.Add("failures", 1)
+
+	circuitBreaker.consecutiveFailures++
+	circuitBreaker.isTrialInFlight = false
+
+	if circuitBreaker.state == CircuitHalfOpen || circuitBreaker.consecutiveFailures >= circuitBreaker.failureThreshold {
+
+		if circuitBreaker.state != CircuitOpen {
+			circuitBreaker.metrics.Add("opened", 1)
+		}
+
+		circuitBreaker.state = CircuitOpen
+		circuitBreaker.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	json "encoding/json"
+	errors "errors"
+	fmt "fmt"
+	log "log"
+	regexp "regexp"
+	sort "sort"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// DeviceLastSeenResolution : Seconds during which the last-seen date of a device session is not updated again
+	DeviceLastSeenResolution = 60
+)
+
+var (
+	// deviceIDRegex : Accepted device IDs (device IDs are part of MQTT client IDs & Redis keys)
+	deviceIDRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+	// ErrInvalidDeviceID : Device ID does not match deviceIDRegex
+	ErrInvalidDeviceID = errors.New("Invalid Device ID")
+)
+
+// NormalizeDeviceID : Return device ID to use for a request device header, the default device if none is provided
+func NormalizeDeviceID(deviceID string) (string, error) {
+
+	if deviceID == "" {
+		return models.DefaultDeviceID, nil
+	}
+
+	if !deviceIDRegex.MatchString(deviceID) {
+		return "", ErrInvalidDeviceID
+	}
+
+	return deviceID, nil
+}
+
+// deviceSessionsKey : Return key of the session set of a user
+func deviceSessionsKey(internalWaveUserID string) string {
+	return fmt.Sprintf("sessions:%s", internalWaveUserID)
+}
+
+// GetDeviceSessions : Return device sessions of a user, by device ID
+func GetDeviceSessions(env *models.Env, internalWaveUserID string) (map[string]*models.DeviceSession, error) {
+
+	values, err := env.Redis.HGetAll(deviceSessionsKey(internalWaveUserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	deviceSessions := map[string]*models.DeviceSession{}
+
+	for deviceID, value := range values {
+
+		deviceSession := models.DeviceSession{}
+		err = json.Unmarshal(value, &deviceSession)
+
+		if err != nil {
+			log.Printf("Skipping malformed session of device %s of %s : %v", deviceID, internalWaveUserID, err)
+			continue
+		}
+
+		deviceSessions[deviceID] = &deviceSession
+	}
+
+	return deviceSessions, nil
+}
+
+// sessionTTL : Return seconds after which a session expires, 0 if it never does.
+// Configured session TTL is capped at the remaining lifetime of the token if its expiration date expiresAt is known.
+func sessionTTL(env *models.Env, expiresAt int64) int {
+
+	sessionTTL := env.Config().SessionTTL
+
+	if expiresAt > 0 {
+
+		// Token may only be valid for a leeway past its expiration date
+		remaining := int(expiresAt - time.Now().Unix())
+
+		if remaining < 1 {
+			remaining = 1
+		}
+
+		if sessionTTL <= 0 || remaining < sessionTTL {
+			sessionTTL = remaining
+		}
+	}
+
+	return sessionTTL
+}
+
+// sessionCommand : Return command storing session:{tokenDigest} key, expiring after session TTL if any
+func sessionCommand(env *models.Env, tokenDigest string, internalWaveUserID string, expiresAt int64) models.RedisCommand {
+
+	sessionKey := fmt.Sprintf("session:%s", tokenDigest)
+	sessionTTL := sessionTTL(env, expiresAt)
+
+	if sessionTTL > 0 {
+		return models.NewRedisCommand("SETEX", sessionKey, sessionTTL, internalWaveUserID)
+	}
+
+	return models.NewRedisCommand("SET", sessionKey, internalWaveUserID)
+}
+
+// deviceSessionCommand : Return command storing a device session in the session set of its user
+func deviceSessionCommand(internalWaveUserID string, deviceSession *models.DeviceSession) (models.RedisCommand, error) {
+
+	value, err := json.Marshal(deviceSession)
+
+	if err != nil {
+		return models.RedisCommand{}, err
+	}
+
+	return models.NewRedisCommand("HSET", deviceSessionsKey(internalWaveUserID), deviceSession.DeviceID, value), nil
+}
+
+// StoreDeviceSession : Store session of a device logging in for the first time,
+// its entry in the session set of its user and the user mapping in a single transaction.
+// Session expires with its token if expiresAt is set.
+func StoreDeviceSession(env *models.Env, originalUserID string, internalWaveUserID string, deviceID string, tokenDigest string, expiresAt int64) error {
+
+	now := time.Now().Unix()
+
+	deviceSession := models.NewDeviceSession(deviceID, tokenDigest, now, now)
+	deviceSession.ExpiresAt = expiresAt
+
+	command, err := deviceSessionCommand(internalWaveUserID, deviceSession)
+
+	if err != nil {
+		return err
+	}
+
+	return env.Redis.Transaction(
+		sessionCommand(env, tokenDigest, internalWaveUserID, expiresAt),
+		command,
+		models.NewRedisCommand("HSET", fmt.Sprintf("mapping:%s", originalUserID), "tokenDigest", tokenDigest, "internalWaveUserID", internalWaveUserID),
+		reverseMappingCommand(internalWaveUserID, originalUserID),
+	)
+}
+
+// TouchDeviceSession : Return device session holding token digest and update its last-seen date.
+// Sessions opened before multi-device support have no entry in the session set and belong to the default device.
+func TouchDeviceSession(env *models.Env, internalWaveUserID string, tokenDigest string) (*models.DeviceSession, error) {
+
+	sessionsKey := deviceSessionsKey(internalWaveUserID)
+	defaultDeviceSession := models.NewDeviceSession(models.DefaultDeviceID, tokenDigest, 0, 0)
+
+	values, err := env.Redis.HGetAll(sessionsKey)
+
+	if err != nil {
+		return defaultDeviceSession, err
+	}
+
+	now := time.Now().Unix()
+
+	for deviceID, value := range values {
+
+		deviceSession := models.DeviceSession{}
+
+		if json.Unmarshal(value, &deviceSession) != nil || deviceSession.TokenDigest != tokenDigest {
+			continue
+		}
+
+		if now-deviceSession.LastSeenAt >= DeviceLastSeenResolution {
+
+			deviceSession.LastSeenAt = now
+
+			touchedValue, err := json.Marshal(deviceSession)
+
+			if err != nil {
+				return &deviceSession, err
+			}
+
+			// Entry is left untouched if the device was rotated or revoked in the meantime
+			_, err = env.Redis.HSetIfEquals(sessionsKey, deviceID, value, touchedValue)
+
+			if err != nil {
+				return &deviceSession, err
+			}
+		}
+
+		return &deviceSession, nil
+	}
+
+	return defaultDeviceSession, nil
+}
+
+// EvictOldestDeviceSessions : Revoke least recently seen device sessions of a user exceeding configured device limit.
+// Device keptDeviceID (the one logging in) is never evicted.
+func EvictOldestDeviceSessions(env *models.Env, internalWaveUserID string, keptDeviceID string) error {
+
+	maxDevices := env.Config().MaxDevicesPerUser
+
+	if maxDevices <= 0 {
+		return nil
+	}
+
+	deviceSessions, err := GetDeviceSessions(env, internalWaveUserID)
+
+	if err != nil {
+		return err
+	}
+
+	if len(deviceSessions) <= maxDevices {
+		return nil
+	}
+
+	candidates := []*models.DeviceSession{}
+
+	for deviceID, deviceSession := range deviceSessions {
+		if deviceID != keptDeviceID {
+			candidates = append(candidates, deviceSession)
+		}
+	}
+
+	sort.Slice(candidates, func(i int, j int) bool {
+		return candidates[i].LastSeenAt < candidates[j].LastSeenAt
+	})
+
+	evictedCount := len(deviceSessions) - maxDevices
+
+	if evictedCount > len(candidates) {
+		evictedCount = len(candidates)
+	}
+
+	for _, deviceSession := range candidates[:evictedCount] {
+
+		err = RevokeDeviceSession(env, internalWaveUserID, deviceSession)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Device %s of %s evicted (device limit reached)", deviceSession.DeviceID, internalWaveUserID)
+	}
+
+	return nil
+}
+
+// RevokeDeviceSession : End session of a device and invalidate its VerneMQ credentials.
+// An empty passhash is written through the passhash outbox so that a failed profile update is repaired by reconciliation.
+func RevokeDeviceSession(env *models.Env, internalWaveUserID string, deviceSession *models.DeviceSession) error {
+
+	clientID := models.DeviceClientID(internalWaveUserID, deviceSession.DeviceID)
+
+	err := env.Redis.Transaction(
+		models.NewRedisCommand("DEL", fmt.Sprintf("session:%s", deviceSession.TokenDigest)),
+		models.NewRedisCommand("DEL", fmt.Sprintf("revalidation:%s", deviceSession.TokenDigest)),
+		models.NewRedisCommand("HDEL", deviceSessionsKey(internalWaveUserID), deviceSession.DeviceID),
+		models.NewRedisCommand("HSET", PasshashOutboxKey, clientID, ""),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = ApplyPendingPasshash(env, clientID, "")
+
+	if err != nil {
+		log.Printf("Credentials invalidation of %s postponed to reconciliation : %v", clientID, err)
+	}
+
+	return nil
+}
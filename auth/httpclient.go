@@ -0,0 +1,149 @@
+package auth
+
+import (
+	errors "errors"
+	fmt "fmt"
+	io "io"
+	ioutil "io/ioutil"
+	rand "math/rand"
+	net "net"
+	http "net/http"
+	sync "sync"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// CodeAuthenticationUnavailable : Error code returned when authentication backend calls are short-circuited
+	CodeAuthenticationUnavailable = "AuthenticationUnavailable"
+)
+
+var (
+	// ErrAuthenticationUnavailable : Returned without calling authentication backend while circuit breaker is open
+	ErrAuthenticationUnavailable = errors.New(CodeAuthenticationUnavailable)
+
+	// sharedAuthHTTPClient : HTTP client shared by all authentication backends, rebuilt when its config changes
+	sharedAuthHTTPClient *AuthHTTPClient
+
+	// sharedAuthHTTPClientLock : Protects sharedAuthHTTPClient
+	sharedAuthHTTPClientLock sync.Mutex
+
+	// authCircuitBreaker : Circuit breaker shared by all authentication backends, kept across client rebuilds
+	authCircuitBreaker = NewCircuitBreaker("authCircuitBreaker")
+)
+
+// AuthHTTPClient : HTTP client with timeouts, bounded retries and circuit breaker used to call authentication backends
+type AuthHTTPClient struct {
+	Config         models.AuthHTTPClientConfig
+	Client         *http.Client
+	CircuitBreaker *CircuitBreaker
+}
+
+// GetAuthHTTPClient : Return shared authentication HTTP client matching config
+func GetAuthHTTPClient(env *models.Env) *AuthHTTPClient {
+
+	config := env.Config().AuthHTTPClient.WithDefaults()
+
+	sharedAuthHTTPClientLock.Lock()
+	defer sharedAuthHTTPClientLock.Unlock()
+
+	if sharedAuthHTTPClient == nil || sharedAuthHTTPClient.Config != config {
+		sharedAuthHTTPClient = NewAuthHTTPClient(config, authCircuitBreaker)
+	}
+
+	authCircuitBreaker.Configure(config.BreakerFailureThreshold, time.Duration(config.BreakerOpenDuration)*time.Second)
+
+	return sharedAuthHTTPClient
+}
+
+// NewAuthHTTPClient : Return a new authentication HTTP client
+func NewAuthHTTPClient(config models.AuthHTTPClientConfig, circuitBreaker *CircuitBreaker) *AuthHTTPClient {
+
+	connectTimeout := time.Duration(config.ConnectTimeout) * time.Millisecond
+	readTimeout := time.Duration(config.ReadTimeout) * time.Millisecond
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: readTimeout,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &AuthHTTPClient{
+		Config: config,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   connectTimeout + readTimeout,
+		},
+		CircuitBreaker: circuitBreaker,
+	}
+}
+
+// Do : Execute request built by newRequest, retrying with jittered exponential backoff on network errors and 5xx responses.
+// Fails fast with ErrAuthenticationUnavailable while circuit breaker is open.
+// Caller is responsible for closing the returned response body.
+func (client *AuthHTTPClient) Do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+
+	if !client.CircuitBreaker.Allow() {
+		return nil, ErrAuthenticationUnavailable
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= client.Config.MaxRetries; attempt++ {
+
+		if attempt > 0 {
+			client.CircuitBreaker.metrics.Add("retries", 1)
+			time.Sleep(retryDelay(client.Config.RetryBackoff, attempt))
+		}
+
+		// Request is built again on each attempt so that its body can be re-read
+		req, err := newRequest()
+
+		// Request could not even be built : authentication backend is not at fault
+		if err != nil {
+			client.CircuitBreaker.Release()
+			return nil, err
+		}
+
+		res, err := client.Client.Do(req)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if res.StatusCode >= 500 {
+			lastErr = fmt.Errorf("authentication backend %s responded with status %d", req.URL.Host, res.StatusCode)
+			drainAndClose(res.Body)
+			continue
+		}
+
+		client.CircuitBreaker.Success()
+
+		return res, nil
+	}
+
+	client.CircuitBreaker.Failure()
+
+	return nil, lastErr
+}
+
+// retryDelay : Exponential backoff delay for attempt, with up to 50% random jitter
+func retryDelay(backoff int, attempt int) time.Duration {
+
+	delay := time.Duration(backoff) * time.Millisecond * time.Duration(1<<uint(attempt-1))
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// drainAndClose : Read body to end and close it so that the underlying connection can be reused
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	errors "errors"
+	http "net/http"
+	httptest "net/http/httptest"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+func TestAuthHTTPClientRequestBuildError(t *testing.T) {
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	defer backend.Close()
+
+	circuitBreaker := NewCircuitBreaker("testRequestBuildErrorCircuitBreaker")
+	circuitBreaker.Configure(1, time.Minute)
+
+	client := NewAuthHTTPClient(models.AuthHTTPClientConfig{}.WithDefaults(), circuitBreaker)
+
+	buildError := errors.New("invalid request")
+
+	failingRequest := func() (*http.Request, error) {
+		return nil, buildError
+	}
+
+	validRequest := func() (*http.Request, error) {
+		return http.NewRequest("GET", backend.URL, nil)
+	}
+
+	// Request build errors are returned as is, without opening the circuit
+	for i := 0; i < 3; i++ {
+
+		_, err := client.Do(failingRequest)
+
+		if err != buildError {
+			t.Fatalf("expected request build error, got %v", err)
+		}
+	}
+
+	if circuitBreaker.State() != CircuitClosed {
+		t.Fatalf("request build errors opened the circuit")
+	}
+
+	// Request build errors don't hold the trial call slot of a half-open circuit
+	circuitBreaker.Failure()
+	circuitBreaker.openedAt = time.Now().Add(-2 * time.Minute)
+
+	_, err := client.Do(failingRequest)
+
+	if err != buildError || circuitBreaker.State() != CircuitHalfOpen {
+		t.Fatalf("expected request build error with half-open circuit, got %v with %s circuit", err, circuitBreaker.State())
+	}
+
+	res, err := client.Do(validRequest)
+
+	if err != nil {
+		t.Fatalf("expected trial call to go through, got %v", err)
+	}
+
+	res.Body.Close()
+
+	if circuitBreaker.State() != CircuitClosed {
+		t.Fatalf("successful trial call left circuit %s", circuitBreaker.State())
+	}
+}
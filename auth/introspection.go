@@ -0,0 +1,101 @@
+package auth
+
+import (
+	json "encoding/json"
+	errors "errors"
+	fmt "fmt"
+	http "net/http"
+	url "net/url"
+	strings "strings"
+	time "time"
+	models "wave-messaging-management-service/models"
+	utils "wave-messaging-management-service/utils"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+// IntrospectionVerifier : Verify tokens with an OAuth2 token introspection endpoint (RFC 7662)
+type IntrospectionVerifier struct{}
+
+// Verify : Introspect token and return its subject if it is active, not expired and granted required scopes
+func (verifier *IntrospectionVerifier) Verify(env *models.Env, token string) (*Identity, error) {
+
+	config := env.Config().Introspection
+
+	form := url.Values{}
+	form.Set("token", token)
+
+	if config.TokenTypeHint != "" {
+		form.Set("token_type_hint", config.TokenTypeHint)
+	}
+
+	// Execute request through shared authentication HTTP client
+	res, err := GetAuthHTTPClient(env).Do(func() (*http.Request, error) {
+
+		req, err := http.NewRequest("POST", config.Endpoint, strings.NewReader(form.Encode()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+
+		// Authenticate as a protected resource
+		if config.ClientID != "" {
+			req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+		}
+
+		return req, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	// Introspection endpoint answers 200 for any well-formed request, whether the token is active or not
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error introspecting token : status %d", res.StatusCode)
+	}
+
+	introspectionBody := utils.IntrospectionResponseBody{}
+	err = json.NewDecoder(res.Body).Decode(&introspectionBody)
+
+	if err != nil {
+		return nil, fmt.Errorf("error decoding introspection response : %v", err)
+	}
+
+	if !introspectionBody.Active || introspectionBody.Subject == "" {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	if introspectionBody.ExpiresAt != 0 && time.Now().Unix() > introspectionBody.ExpiresAt {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	if !hasScopes(introspectionBody.Scope, config.RequiredScopes) {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	return &Identity{OriginalUserID: introspectionBody.Subject, DisplayName: introspectionBody.Username, ExpiresAt: introspectionBody.ExpiresAt}, nil
+}
+
+// hasScopes : Check if space-separated granted scopes contain all required scopes
+func hasScopes(grantedScopes string, requiredScopes []string) bool {
+
+	granted := map[string]bool{}
+
+	for _, scope := range strings.Fields(grantedScopes) {
+		granted[scope] = true
+	}
+
+	for _, scope := range requiredScopes {
+		if !granted[scope] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	fmt "fmt"
+	http "net/http"
+	httptest "net/http/httptest"
+	url "net/url"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	testIntrospectionClientID     = "wave management"
+	testIntrospectionClientSecret = "s3cr3t:&="
+)
+
+// newTestIntrospectionEndpoint : Return introspection endpoint answering with the response mapped to the introspected token.
+// Requests that are not authenticated with the test client credentials are answered with 401.
+func newTestIntrospectionEndpoint(t *testing.T, responses map[string]string) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Errorf("unexpected %s request with content type %s", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		// Client credentials are form-urlencoded before being used as basic auth credentials (RFC 6749 section 2.3.1)
+		clientID, clientSecret, ok := r.BasicAuth()
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+
+		if !ok || clientID != testIntrospectionClientID || clientSecret != testIntrospectionClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.PostFormValue("token_type_hint") != "access_token" {
+			t.Errorf("unexpected token type hint %s", r.PostFormValue("token_type_hint"))
+		}
+
+		response, isSet := responses[r.PostFormValue("token")]
+
+		if !isSet {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+}
+
+func TestIntrospectionVerify(t *testing.T) {
+
+	now := time.Now().Unix()
+
+	endpoint := newTestIntrospectionEndpoint(t, map[string]string{
+		"active":           fmt.Sprintf(`{"active": true, "sub": "user1", "username": "User 1", "scope": "chat profile", "exp": %d}`, now+60),
+		"without expiry":   `{"active": true, "sub": "user1", "scope": "chat"}`,
+		"inactive":         `{"active": false}`,
+		"expired":          fmt.Sprintf(`{"active": true, "sub": "user1", "scope": "chat", "exp": %d}`, now-60),
+		"missing scope":    `{"active": true, "sub": "user1", "scope": "profile"}`,
+		"without subject":  `{"active": true, "scope": "chat"}`,
+		"malformed":        `{"active": true, "sub": `,
+		"unexpected types": `{"active": "true", "sub": "user1"}`,
+	})
+
+	defer endpoint.Close()
+
+	config := &models.Config{
+		AuthenticationMode: "introspection",
+		Introspection: models.IntrospectionConfig{
+			Endpoint:       endpoint.URL,
+			ClientID:       testIntrospectionClientID,
+			ClientSecret:   testIntrospectionClientSecret,
+			TokenTypeHint:  "access_token",
+			RequiredScopes: []string{"chat"},
+		},
+	}
+
+	env := &models.Env{}
+	env.SetConfig(config)
+
+	verifier := &IntrospectionVerifier{}
+
+	identity, err := verifier.Verify(env, "active")
+
+	if err != nil || identity.OriginalUserID != "user1" || identity.DisplayName != "User 1" {
+		t.Fatalf("expected active token of user1, got %+v : %v", identity, err)
+	}
+
+	identity, err = verifier.Verify(env, "without expiry")
+
+	if err != nil || identity.OriginalUserID != "user1" {
+		t.Fatalf("expected active token of user1, got %+v : %v", identity, err)
+	}
+
+	// Inactive tokens are invalid tokens
+	for _, token := range []string{"inactive", "expired", "missing scope", "without subject"} {
+
+		_, err := verifier.Verify(env, token)
+
+		if err == nil || err.Error() != logruswrapper.CodeInvalidToken {
+			t.Errorf("%s : expected invalid token, got %v", token, err)
+		}
+	}
+
+	// Non-200 & malformed responses are introspection errors, not invalid tokens
+	for _, token := range []string{"unknown", "malformed", "unexpected types"} {
+
+		_, err := verifier.Verify(env, token)
+
+		if err == nil || err.Error() == logruswrapper.CodeInvalidToken {
+			t.Errorf("%s : expected introspection error, got %v", token, err)
+		}
+	}
+
+	wrongCredentialsConfig := *config
+	wrongCredentialsConfig.Introspection.ClientSecret = "wrong"
+	env.SetConfig(&wrongCredentialsConfig)
+
+	_, err = verifier.Verify(env, "active")
+
+	if err == nil || err.Error() == logruswrapper.CodeInvalidToken {
+		t.Errorf("expected introspection error with wrong client credentials, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	rand "crypto/rand"
+	base64 "encoding/base64"
+	json "encoding/json"
+	fmt "fmt"
+	log "log"
+	regexp "regexp"
+	sort "sort"
+	strconv "strconv"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// groupInviteCodeBytes : Number of random bytes of an invite code (16 URL-safe characters once encoded)
+	groupInviteCodeBytes = 12
+)
+
+var (
+	// groupInviteCodeRegex : Accepted invite codes (invite codes are part of Redis keys)
+	groupInviteCodeRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{16}$`)
+)
+
+// groupInviteKey : Return key of an invite code
+func groupInviteKey(code string) string {
+	return fmt.Sprintf("invite:%s", code)
+}
+
+// groupInviteUsesKey : Return key of the usage counter of an invite code
+func groupInviteUsesKey(code string) string {
+	return fmt.Sprintf("invite:%s:uses", code)
+}
+
+// groupInvitesKey : Return key of the invite set of a group conversation
+func groupInvitesKey(groupConversationID string) string {
+	return fmt.Sprintf("invites:%s", groupConversationID)
+}
+
+// newGroupInviteCode : Return a new random invite code
+func newGroupInviteCode() (string, error) {
+
+	codeBytes := make([]byte, groupInviteCodeBytes)
+
+	_, err := rand.Read(codeBytes)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(codeBytes), nil
+}
+
+// CreateGroupInvite : Store a new invite code of a group conversation expiring after ttl seconds,
+// its usage counter and its entry in the invite set of the group in a single transaction
+func CreateGroupInvite(env *models.Env, groupConversationID string, createdBy string, ttl int, maxUses int) (*models.GroupInvite, error) {
+
+	code, err := newGroupInviteCode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	groupInvite := models.NewGroupInvite(code, groupConversationID, createdBy, now, now+int64(ttl), maxUses)
+
+	value, err := json.Marshal(groupInvite)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.Redis.Transaction(
+		models.NewRedisCommand("SETEX", groupInviteKey(code), ttl, value),
+		models.NewRedisCommand("SETEX", groupInviteUsesKey(code), ttl, 0),
+		models.NewRedisCommand("HSET", groupInvitesKey(groupConversationID), code, groupInvite.ExpiresAt),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return groupInvite, nil
+}
+
+// GetGroupInvite : Return invite code along with its number of uses, ErrGroupInviteNotFound if it does not exist
+func GetGroupInvite(env *models.Env, code string) (*models.GroupInvite, error) {
+
+	if !groupInviteCodeRegex.MatchString(code) {
+		return nil, models.ErrGroupInviteNotFound
+	}
+
+	values, err := env.Redis.MGet([]string{groupInviteKey(code), groupInviteUsesKey(code)})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeGroupInvite(values[0], values[1])
+}
+
+// GetGroupInvites : Return active invite codes of a group conversation by creation date.
+// Entries of expired invite codes are removed from the invite set of the group.
+func GetGroupInvites(env *models.Env, groupConversationID string) ([]*models.GroupInvite, error) {
+
+	invitesKey := groupInvitesKey(groupConversationID)
+
+	entries, err := env.Redis.HGetAll(invitesKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	keys := []string{}
+
+	for code := range entries {
+		codes = append(codes, code)
+		keys = append(keys, groupInviteKey(code), groupInviteUsesKey(code))
+	}
+
+	values, err := env.Redis.MGet(keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	groupInvites := []*models.GroupInvite{}
+
+	for i, code := range codes {
+
+		groupInvite, err := decodeGroupInvite(values[2*i], values[2*i+1])
+
+		if err == models.ErrGroupInviteNotFound {
+
+			// Entry is left untouched if the same code was stored again in the meantime
+			_, err = env.Redis.HDeleteIfEquals(invitesKey, code, entries[code])
+
+			if err != nil {
+				log.Println(err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Skipping malformed invite %s of group conversation %s : %v", code, groupConversationID, err)
+			continue
+		}
+
+		groupInvites = append(groupInvites, groupInvite)
+	}
+
+	sort.Slice(groupInvites, func(i int, j int) bool {
+		return groupInvites[i].CreatedAt < groupInvites[j].CreatedAt
+	})
+
+	return groupInvites, nil
+}
+
+// UseGroupInvite : Reserve a use of an invite code then run join, ErrGroupInviteExhausted if it already reached its maximum number of uses.
+// The use is given back if join fails, so that only successful joins are counted. Invite codes are revoked on their last use.
+func UseGroupInvite(env *models.Env, groupInvite *models.GroupInvite, join func() error) error {
+
+	remainingTTL := groupInvite.ExpiresAt - time.Now().Unix()
+
+	if remainingTTL <= 0 {
+		return models.ErrGroupInviteNotFound
+	}
+
+	usesKey := groupInviteUsesKey(groupInvite.Code)
+
+	uses, err := env.Redis.Incr(usesKey)
+
+	if err != nil {
+		return err
+	}
+
+	// Counter recreated by INCR if the invite code expired or was revoked in the meantime must expire too
+	err = env.Redis.Expire(usesKey, int(remainingTTL))
+
+	if err != nil {
+		return err
+	}
+
+	exists, err := env.Redis.Exists(groupInviteKey(groupInvite.Code))
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrGroupInviteNotFound
+	}
+
+	// Uses reserved by joins that may still fail are given back, so that the counter never stays above the maximum
+	if groupInvite.MaxUses > 0 && uses > groupInvite.MaxUses {
+		releaseGroupInviteUse(env, groupInvite, remainingTTL)
+		return models.ErrGroupInviteExhausted
+	}
+
+	err = join()
+
+	if err != nil {
+		releaseGroupInviteUse(env, groupInvite, remainingTTL)
+		return err
+	}
+
+	groupInvite.Uses = uses
+
+	if groupInvite.IsExhausted() {
+
+		err = RevokeGroupInvite(env, groupInvite)
+
+		if err != nil {
+			log.Printf("Revocation of exhausted invite %s failed : %v", groupInvite.Code, err)
+		}
+	}
+
+	return nil
+}
+
+// releaseGroupInviteUse : Give back a use reserved by UseGroupInvite.
+// Failures are only logged : the use stays counted until the invite code expires.
+func releaseGroupInviteUse(env *models.Env, groupInvite *models.GroupInvite, remainingTTL int64) {
+
+	usesKey := groupInviteUsesKey(groupInvite.Code)
+
+	err := env.Redis.Transaction(
+		models.NewRedisCommand("DECR", usesKey),
+		models.NewRedisCommand("EXPIRE", usesKey, remainingTTL),
+	)
+
+	if err != nil {
+		log.Printf("Reserved use of invite %s not given back : %v", groupInvite.Code, err)
+	}
+}
+
+// RevokeGroupInvite : Delete invite code, its usage counter and its entry in the invite set of its group
+func RevokeGroupInvite(env *models.Env, groupInvite *models.GroupInvite) error {
+
+	return env.Redis.Transaction(
+		models.NewRedisCommand("DEL", groupInviteKey(groupInvite.Code)),
+		models.NewRedisCommand("DEL", groupInviteUsesKey(groupInvite.Code)),
+		models.NewRedisCommand("HDEL", groupInvitesKey(groupInvite.GroupConversationID), groupInvite.Code),
+	)
+}
+
+// RevokeGroupInvites : Delete all invite codes of a group conversation along with its invite set
+func RevokeGroupInvites(env *models.Env, groupConversationID string) error {
+
+	invitesKey := groupInvitesKey(groupConversationID)
+
+	entries, err := env.Redis.HGetAll(invitesKey)
+
+	if err != nil {
+		return err
+	}
+
+	commands := []models.RedisCommand{}
+
+	for code := range entries {
+		commands = append(commands,
+			models.NewRedisCommand("DEL", groupInviteKey(code)),
+			models.NewRedisCommand("DEL", groupInviteUsesKey(code)),
+		)
+	}
+
+	commands = append(commands, models.NewRedisCommand("DEL", invitesKey))
+
+	return env.Redis.Transaction(commands...)
+}
+
+// decodeGroupInvite : Decode invite code value and usage counter value, ErrGroupInviteNotFound if the invite code value is missing
+func decodeGroupInvite(value []byte, usesValue []byte) (*models.GroupInvite, error) {
+
+	if value == nil {
+		return nil, models.ErrGroupInviteNotFound
+	}
+
+	groupInvite := models.GroupInvite{}
+
+	err := json.Unmarshal(value, &groupInvite)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing counter (invite code about to expire) counts as no use
+	groupInvite.Uses, _ = strconv.Atoi(string(usesValue))
+
+	return &groupInvite, nil
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	crypto "crypto"
+	ecdsa "crypto/ecdsa"
+	elliptic "crypto/elliptic"
+	rsa "crypto/rsa"
+	base64 "encoding/base64"
+	json "encoding/json"
+	errors "errors"
+	fmt "fmt"
+	log "log"
+	big "math/big"
+	http "net/http"
+	sync "sync"
+	time "time"
+	models "wave-messaging-management-service/models"
+
+	singleflight "golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultJWKSRefreshInterval : Seconds after which a fetched JWKS document is refreshed when none is configured
+	DefaultJWKSRefreshInterval = 3600
+
+	// MinJWKSRefreshInterval : Minimum seconds between two JWKS fetches triggered by an unknown key ID
+	MinJWKSRefreshInterval = 60
+)
+
+var (
+	// jwksCache : JWKS documents fetched so far, by URL
+	jwksCache = map[string]*cachedJWKS{}
+
+	// jwksCacheLock : Protects jwksCache
+	jwksCacheLock sync.Mutex
+
+	// jwksFetchGroup : Deduplicates in-flight fetches by URL
+	jwksFetchGroup singleflight.Group
+)
+
+// cachedJWKS : Parsed JWKS document, its fetch date and the outcome of the last fetch attempt.
+// Keys are nil and FetchedAt is zero until a fetch succeeds.
+type cachedJWKS struct {
+	Keys        []*verificationKey
+	FetchedAt   time.Time
+	AttemptedAt time.Time
+	Err         error
+}
+
+// verificationKey : Parsed JSON Web Key usable to verify signatures
+type verificationKey struct {
+	KeyID     string
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// getVerificationKeys : Return keys from static key set and JWKS document provided by config.
+// If forceRefresh is set, JWKS document is fetched again unless it was fetched less than MinJWKSRefreshInterval ago.
+func getVerificationKeys(env *models.Env, forceRefresh bool) ([]*verificationKey, error) {
+
+	config := env.Config().JWT
+
+	keys, err := parseJSONWebKeySet(config.JWKS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.JWKSURL == "" {
+		return keys, nil
+	}
+
+	remoteKeys, err := getJWKS(GetAuthHTTPClient(env), config.JWKSURL, config.JWKSRefreshInterval, forceRefresh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(keys, remoteKeys...), nil
+}
+
+// getJWKS : Return cached JWKS document keys, fetching it if missing or outdated.
+// Fetches happen outside of jwksCacheLock, a single one at a time per URL, and failed fetches are not retried sooner than MinJWKSRefreshInterval.
+func getJWKS(client *AuthHTTPClient, url string, refreshInterval int, forceRefresh bool) ([]*verificationKey, error) {
+
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+
+	cached, isCached := getCachedJWKS(url)
+
+	if isCached {
+
+		age := time.Since(cached.FetchedAt)
+		isFresh := !cached.FetchedAt.IsZero() && age < time.Duration(refreshInterval)*time.Second && (!forceRefresh || age < MinJWKSRefreshInterval*time.Second)
+		isBackingOff := cached.Err != nil && time.Since(cached.AttemptedAt) < MinJWKSRefreshInterval*time.Second
+
+		if isFresh || isBackingOff {
+			return cached.cachedKeys()
+		}
+	}
+
+	keys, err, _ := jwksFetchGroup.Do(url, func() (interface{}, error) {
+		return fetchJWKS(client, url)
+	})
+
+	jwksCacheLock.Lock()
+	defer jwksCacheLock.Unlock()
+
+	updated := cachedJWKS{AttemptedAt: time.Now(), Err: err}
+
+	if err != nil {
+
+		log.Printf("Error fetching JWKS document %s : %v", url, err)
+
+		// Keep serving outdated keys rather than failing if the JWKS endpoint is unavailable
+		if previous, isCached := jwksCache[url]; isCached {
+			updated.Keys, updated.FetchedAt = previous.Keys, previous.FetchedAt
+		}
+
+	} else {
+		updated.Keys, updated.FetchedAt = keys.([]*verificationKey), updated.AttemptedAt
+	}
+
+	jwksCache[url] = &updated
+
+	return updated.cachedKeys()
+}
+
+// getCachedJWKS : Return a copy of the cached JWKS document of URL
+func getCachedJWKS(url string) (cachedJWKS, bool) {
+
+	jwksCacheLock.Lock()
+	defer jwksCacheLock.Unlock()
+
+	cached, isCached := jwksCache[url]
+
+	if !isCached {
+		return cachedJWKS{}, false
+	}
+
+	return *cached, true
+}
+
+// cachedKeys : Return keys of the last successful fetch, or the error of the last attempt if none succeeded
+func (cached *cachedJWKS) cachedKeys() ([]*verificationKey, error) {
+
+	if cached.FetchedAt.IsZero() {
+		return nil, cached.Err
+	}
+
+	return cached.Keys, nil
+}
+
+// fetchJWKS : Download and parse JWKS document
+func fetchJWKS(client *AuthHTTPClient, url string) ([]*verificationKey, error) {
+
+	res, err := client.Do(func() (*http.Request, error) {
+		return http.NewRequest("GET", url, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error fetching JWKS document %s : status %d", url, res.StatusCode)
+	}
+
+	jwks := models.JSONWebKeySet{}
+	err = json.NewDecoder(res.Body).Decode(&jwks)
+
+	if err != nil {
+		return nil, fmt.Errorf("error decoding JWKS document %s : %v", url, err)
+	}
+
+	return parseJSONWebKeySet(jwks)
+}
+
+// parseJSONWebKeySet : Parse all keys of a JWKS document, skipping keys not meant for signature verification.
+// Unsupported or malformed keys are skipped, an error is only returned if none of the signature keys could be parsed.
+func parseJSONWebKeySet(jwks models.JSONWebKeySet) ([]*verificationKey, error) {
+
+	keys := []*verificationKey{}
+
+	var lastErr error
+
+	for _, jwk := range jwks.Keys {
+
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJSONWebKey(jwk)
+
+		if err != nil {
+			log.Printf("Skipping JWK %s : %v", jwk.KeyID, err)
+			lastErr = err
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("no usable key in JWKS document : %v", lastErr)
+	}
+
+	return keys, nil
+}
+
+// parseJSONWebKey : Parse RSA, EC (P-256) or symmetric JSON Web Key
+func parseJSONWebKey(jwk models.JSONWebKey) (*verificationKey, error) {
+
+	key := &verificationKey{KeyID: jwk.KeyID, Algorithm: jwk.Algorithm}
+
+	switch jwk.KeyType {
+
+	case "RSA":
+
+		n, err := decodeBigInt(jwk.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported JWK curve %s", jwk.Curve)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid JWK : point is not on curve P-256")
+		}
+
+		key.Key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	case "oct":
+
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK symmetric key : %v", err)
+		}
+
+		key.Key = secret
+
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type %s", jwk.KeyType)
+	}
+
+	return key, nil
+}
+
+// decodeBigInt : Decode base64url encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid JWK integer parameter")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	bytes "bytes"
+	crypto "crypto"
+	ecdsa "crypto/ecdsa"
+	hmac "crypto/hmac"
+	rsa "crypto/rsa"
+	sha256 "crypto/sha256"
+	base64 "encoding/base64"
+	json "encoding/json"
+	errors "errors"
+	big "math/big"
+	strings "strings"
+	time "time"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// DefaultUserIDClaim : JWT claim mapped to the original user ID when none is configured
+	DefaultUserIDClaim = "sub"
+)
+
+var (
+	// SupportedJWTAlgorithms : JWT signature algorithms that can be verified locally
+	SupportedJWTAlgorithms = []string{"RS256", "ES256", "HS256"}
+)
+
+// JWTVerifier : Verify JSON Web Tokens locally against configured static key set and/or JWKS document
+type JWTVerifier struct{}
+
+// jwtHeader : JOSE header fields used for verification
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify : Check JWT signature & registered claims, return configured user ID claim value
+func (verifier *JWTVerifier) Verify(env *models.Env, token string) (*Identity, error) {
+
+	config := env.Config().JWT
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	header := jwtHeader{}
+	err := decodeJWTSegment(parts[0], &header)
+
+	if err != nil || !isJWTAlgorithmAllowed(config.Algorithms, header.Algorithm) {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+
+	keys, err := getVerificationKeys(env, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	isSignatureValid := verifyJWTSignature(keys, header, signingInput, signature)
+
+	// Key may have been rotated since JWKS document was fetched
+	if !isSignatureValid && config.JWKSURL != "" && header.KeyID != "" && !hasKeyID(keys, header.KeyID) {
+
+		keys, err = getVerificationKeys(env, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		isSignatureValid = verifyJWTSignature(keys, header, signingInput, signature)
+	}
+
+	if !isSignatureValid {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeJWTSegment(parts[1], &claims)
+
+	if err != nil || !areJWTClaimsValid(config, claims) {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	userIDClaim := config.UserIDClaim
+
+	if userIDClaim == "" {
+		userIDClaim = DefaultUserIDClaim
+	}
+
+	originalUserID := claimToString(claims[userIDClaim])
+
+	if originalUserID == "" {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	// Expiration was checked by areJWTClaimsValid
+	expiresAt, _ := claimToInt64(claims["exp"])
+
+	return &Identity{OriginalUserID: originalUserID, ExpiresAt: expiresAt}, nil
+}
+
+// decodeJWTSegment : Decode base64url encoded JSON segment of a JWT
+func decodeJWTSegment(segment string, v interface{}) error {
+
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// isJWTAlgorithmAllowed : Check if algorithm is supported and allowed by config (all supported algorithms are allowed if none is configured)
+func isJWTAlgorithmAllowed(allowedAlgorithms []string, algorithm string) bool {
+
+	if len(allowedAlgorithms) == 0 {
+		allowedAlgorithms = SupportedJWTAlgorithms
+	}
+
+	for _, allowed := range allowedAlgorithms {
+		if allowed == algorithm {
+			for _, supported := range SupportedJWTAlgorithms {
+				if supported == algorithm {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// hasKeyID : Check if key set contains a key with provided ID
+func hasKeyID(keys []*verificationKey, keyID string) bool {
+
+	for _, key := range keys {
+		if key.KeyID == keyID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyJWTSignature : Check signature against every candidate key.
+// Key type must match algorithm so that a public key can never be used as an HMAC secret.
+func verifyJWTSignature(keys []*verificationKey, header jwtHeader, signingInput []byte, signature []byte) bool {
+
+	digest := sha256.Sum256(signingInput)
+
+	for _, key := range keys {
+
+		if header.KeyID != "" && key.KeyID != "" && key.KeyID != header.KeyID {
+			continue
+		}
+
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+
+		switch header.Algorithm {
+
+		case "RS256":
+
+			publicKey, ok := key.Key.(*rsa.PublicKey)
+
+			if ok && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+
+		case "ES256":
+
+			publicKey, ok := key.Key.(*ecdsa.PublicKey)
+
+			if ok && len(signature) == 64 {
+
+				r := new(big.Int).SetBytes(signature[:32])
+				s := new(big.Int).SetBytes(signature[32:])
+
+				if ecdsa.Verify(publicKey, digest[:], r, s) {
+					return true
+				}
+			}
+
+		case "HS256":
+
+			secret, ok := key.Key.([]byte)
+
+			if ok {
+
+				mac := hmac.New(sha256.New, secret)
+				mac.Write(signingInput)
+
+				if hmac.Equal(mac.Sum(nil), signature) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// areJWTClaimsValid : Check exp, nbf, iss and aud registered claims.
+// Tokens without exp claim are rejected, as they would stay valid forever.
+func areJWTClaimsValid(config models.JWTConfig, claims map[string]interface{}) bool {
+
+	now := time.Now().Unix()
+	leeway := int64(config.Leeway)
+
+	expiresAt, ok := claimToInt64(claims["exp"])
+
+	if !ok || now > expiresAt+leeway {
+		return false
+	}
+
+	if nbf, isSet := claims["nbf"]; isSet {
+
+		notBefore, ok := claimToInt64(nbf)
+
+		if !ok || now < notBefore-leeway {
+			return false
+		}
+	}
+
+	if config.Issuer != "" && claimToString(claims["iss"]) != config.Issuer {
+		return false
+	}
+
+	if config.Audience != "" {
+
+		switch aud := claims["aud"].(type) {
+
+		case string:
+			return aud == config.Audience
+
+		case []interface{}:
+			for _, audience := range aud {
+				if claimToString(audience) == config.Audience {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// claimToInt64 : Convert numeric claim to int64
+func claimToInt64(claim interface{}) (int64, bool) {
+
+	number, ok := claim.(json.Number)
+
+	if !ok {
+		return 0, false
+	}
+
+	value, err := number.Int64()
+
+	if err != nil {
+
+		floatValue, err := number.Float64()
+
+		if err != nil {
+			return 0, false
+		}
+
+		return int64(floatValue), true
+	}
+
+	return value, true
+}
+
+// claimToString : Convert string or numeric claim to string, other types are converted to an empty string
+func claimToString(claim interface{}) string {
+
+	switch value := claim.(type) {
+
+	case string:
+		return value
+
+	case json.Number:
+		return value.String()
+	}
+
+	return ""
+}
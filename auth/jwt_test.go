@@ -0,0 +1,519 @@
+package auth
+
+import (
+	crypto "crypto"
+	ecdsa "crypto/ecdsa"
+	elliptic "crypto/elliptic"
+	hmac "crypto/hmac"
+	rand "crypto/rand"
+	rsa "crypto/rsa"
+	sha256 "crypto/sha256"
+	x509 "crypto/x509"
+	base64 "encoding/base64"
+	json "encoding/json"
+	pem "encoding/pem"
+	big "math/big"
+	http "net/http"
+	httptest "net/http/httptest"
+	sync "sync"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+// signTestJWT : Return JWT holding claims signed with key (RSA or EC private key, HMAC secret or nil for alg none)
+func signTestJWT(t *testing.T, algorithm string, keyID string, key interface{}, claims map[string]interface{}) string {
+
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature := []byte{}
+
+	switch privateKey := key.(type) {
+
+	case *rsa.PrivateKey:
+
+		signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+	case *ecdsa.PrivateKey:
+
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// r & s are encoded as fixed size big-endian integers
+		signature = make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+
+	case []byte:
+
+		mac := hmac.New(sha256.New, privateKey)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWK : Return public JSON Web Key of an RSA or EC private key
+func testJWK(keyID string, key interface{}) models.JSONWebKey {
+
+	switch privateKey := key.(type) {
+
+	case *rsa.PrivateKey:
+		return models.JSONWebKey{
+			KeyType: "RSA",
+			KeyID:   keyID,
+			N:       base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		}
+
+	case *ecdsa.PrivateKey:
+		return models.JSONWebKey{
+			KeyType: "EC",
+			KeyID:   keyID,
+			Curve:   "P-256",
+			X:       base64.RawURLEncoding.EncodeToString(privateKey.X.Bytes()),
+			Y:       base64.RawURLEncoding.EncodeToString(privateKey.Y.Bytes()),
+		}
+	}
+
+	return models.JSONWebKey{}
+}
+
+// testClaims : Return claims of a token of user expiring in expiresIn seconds
+func testClaims(originalUserID string, expiresIn int64) map[string]interface{} {
+	return map[string]interface{}{"sub": originalUserID, "exp": time.Now().Unix() + expiresIn}
+}
+
+func generateTestKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rsaKey, ecKey
+}
+
+func TestJWTVerify(t *testing.T) {
+
+	rsaKey, ecKey := generateTestKeys(t)
+	otherRSAKey, otherECKey := generateTestKeys(t)
+
+	// PEM encoded public RSA key, as used by algorithm confusion attacks
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	env := &models.Env{}
+	env.SetConfig(&models.Config{
+		AuthenticationMode: "jwt",
+		JWT: models.JWTConfig{
+			JWKS: models.JSONWebKeySet{Keys: []models.JSONWebKey{
+				testJWK("rsa1", rsaKey),
+				testJWK("ec1", ecKey),
+			}},
+			Issuer:   "https://www.myapp.com",
+			Audience: "wave",
+		},
+	})
+
+	claims := func(expiresIn int64, overrides map[string]interface{}) map[string]interface{} {
+
+		claims := testClaims("user1", expiresIn)
+		claims["iss"] = "https://www.myapp.com"
+		claims["aud"] = []string{"other", "wave"}
+
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+
+		return claims
+	}
+
+	valid := signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, nil))
+
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", valid, true},
+		{"RS256 without key ID", signTestJWT(t, "RS256", "", rsaKey, claims(60, nil)), true},
+		{"ES256", signTestJWT(t, "ES256", "ec1", ecKey, claims(60, nil)), true},
+		{"audience string", signTestJWT(t, "ES256", "ec1", ecKey, claims(60, map[string]interface{}{"aud": "wave"})), true},
+
+		{"expired", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(-60, nil)), false},
+		{"without expiration", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, map[string]interface{}{"exp": nil})), false},
+		{"not yet valid", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, map[string]interface{}{"nbf": time.Now().Unix() + 60})), false},
+		{"other issuer", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, map[string]interface{}{"iss": "https://evil.com"})), false},
+		{"other audience", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, map[string]interface{}{"aud": "other"})), false},
+		{"without user ID", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(60, map[string]interface{}{"sub": nil})), false},
+
+		{"unknown RSA key", signTestJWT(t, "RS256", "rsa1", otherRSAKey, claims(60, nil)), false},
+		{"unknown EC key", signTestJWT(t, "ES256", "ec1", otherECKey, claims(60, nil)), false},
+		{"key ID of another key", signTestJWT(t, "RS256", "ec1", rsaKey, claims(60, nil)), false},
+		{"tampered claims", valid[:len(valid)-2] + "AA", false},
+		{"malformed", "not.a.jwt", false},
+
+		// Algorithm confusion
+		{"alg none", signTestJWT(t, "none", "", nil, claims(60, nil)), false},
+		{"alg none with key ID", signTestJWT(t, "none", "rsa1", nil, claims(60, nil)), false},
+		{"HS256 with PEM public RSA key", signTestJWT(t, "HS256", "rsa1", publicKeyPEM, claims(60, nil)), false},
+		{"HS256 with DER public RSA key", signTestJWT(t, "HS256", "", publicKeyDER, claims(60, nil)), false},
+		{"HS256 with RSA modulus", signTestJWT(t, "HS256", "rsa1", rsaKey.N.Bytes(), claims(60, nil)), false},
+	}
+
+	verifier := &JWTVerifier{}
+
+	for _, c := range cases {
+
+		identity, err := verifier.Verify(env, c.token)
+
+		if c.valid && (err != nil || identity.OriginalUserID != "user1") {
+			t.Errorf("%s : expected valid token, got %v", c.name, err)
+		}
+
+		if !c.valid && (err == nil || err.Error() != logruswrapper.CodeInvalidToken) {
+			t.Errorf("%s : expected invalid token, got %v", c.name, err)
+		}
+	}
+}
+
+func TestJWTVerifyAlgorithms(t *testing.T) {
+
+	rsaKey, ecKey := generateTestKeys(t)
+
+	secret := []byte("a-shared-secret-of-32-bytes-long")
+
+	env := &models.Env{}
+	env.SetConfig(&models.Config{
+		AuthenticationMode: "jwt",
+		JWT: models.JWTConfig{
+			Algorithms: []string{"ES256", "HS256"},
+			JWKS: models.JSONWebKeySet{Keys: []models.JSONWebKey{
+				testJWK("rsa1", rsaKey),
+				testJWK("ec1", ecKey),
+				{KeyType: "oct", KeyID: "hs1", K: base64.RawURLEncoding.EncodeToString(secret)},
+			}},
+			Leeway: 120,
+		},
+	})
+
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"allowed ES256", signTestJWT(t, "ES256", "ec1", ecKey, testClaims("user1", 60)), true},
+		{"allowed HS256", signTestJWT(t, "HS256", "hs1", secret, testClaims("user1", 60)), true},
+		{"expired within leeway", signTestJWT(t, "ES256", "ec1", ecKey, testClaims("user1", -60)), true},
+		{"expired beyond leeway", signTestJWT(t, "ES256", "ec1", ecKey, testClaims("user1", -180)), false},
+		{"RS256 not allowed", signTestJWT(t, "RS256", "rsa1", rsaKey, testClaims("user1", 60)), false},
+		{"HS256 with other secret", signTestJWT(t, "HS256", "hs1", []byte("other-secret"), testClaims("user1", 60)), false},
+	}
+
+	verifier := &JWTVerifier{}
+
+	for _, c := range cases {
+
+		_, err := verifier.Verify(env, c.token)
+
+		if c.valid != (err == nil) {
+			t.Errorf("%s : expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestJWTVerifyUnknownKeyIDRefresh(t *testing.T) {
+
+	rsaKey, ecKey := generateTestKeys(t)
+
+	var mutex sync.Mutex
+
+	fetches := 0
+	jwks := models.JSONWebKeySet{Keys: []models.JSONWebKey{testJWK("key1", rsaKey)}}
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		fetches++
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	defer jwksServer.Close()
+
+	env := &models.Env{}
+	env.SetConfig(&models.Config{AuthenticationMode: "jwt", JWT: models.JWTConfig{JWKSURL: jwksServer.URL}})
+
+	verifier := &JWTVerifier{}
+
+	_, err := verifier.Verify(env, signTestJWT(t, "RS256", "key1", rsaKey, testClaims("user1", 60)))
+
+	if err != nil || fetches != 1 {
+		t.Fatalf("expected valid token after 1 fetch, got %v after %d", err, fetches)
+	}
+
+	// Keys are rotated
+	mutex.Lock()
+	jwks = models.JSONWebKeySet{Keys: []models.JSONWebKey{testJWK("key1", rsaKey), testJWK("key2", ecKey)}}
+	mutex.Unlock()
+
+	rotatedToken := signTestJWT(t, "ES256", "key2", ecKey, testClaims("user1", 60))
+
+	// JWKS document is not fetched again sooner than MinJWKSRefreshInterval, even for unknown key IDs
+	_, err = verifier.Verify(env, rotatedToken)
+
+	if err == nil || fetches != 1 {
+		t.Fatalf("expected invalid token without fetch, got %v after %d fetches", err, fetches)
+	}
+
+	jwksCacheLock.Lock()
+	jwksCache[jwksServer.URL].FetchedAt = time.Now().Add(-2 * MinJWKSRefreshInterval * time.Second)
+	jwksCacheLock.Unlock()
+
+	// Known key IDs don't trigger a fetch
+	_, err = verifier.Verify(env, signTestJWT(t, "RS256", "key1", rsaKey, testClaims("user1", 60)))
+
+	if err != nil || fetches != 1 {
+		t.Fatalf("expected valid token without fetch, got %v after %d fetches", err, fetches)
+	}
+
+	// Unknown key ID triggers a fetch
+	identity, err := verifier.Verify(env, rotatedToken)
+
+	if err != nil || identity.OriginalUserID != "user1" || fetches != 2 {
+		t.Fatalf("expected valid token after 2 fetches, got %v after %d", err, fetches)
+	}
+
+	// Still unknown key IDs are rejected
+	_, err = verifier.Verify(env, signTestJWT(t, "RS256", "key3", rsaKey, testClaims("user1", 60)))
+
+	if err == nil || fetches != 2 {
+		t.Fatalf("expected invalid token without fetch, got %v after %d fetches", err, fetches)
+	}
+}
+
+func TestParseJSONWebKeySetSkipsUnsupportedKeys(t *testing.T) {
+
+	rsaKey, _ := generateTestKeys(t)
+
+	unsupportedKeys := []models.JSONWebKey{
+		{KeyType: "EC", KeyID: "p384", Curve: "P-384", X: "AQ", Y: "AQ"},
+		{KeyType: "OKP", KeyID: "ed25519", Curve: "Ed25519", X: "AQ"},
+		{KeyType: "RSA", KeyID: "malformed"},
+	}
+
+	// Unsupported keys are skipped
+	keys, err := parseJSONWebKeySet(models.JSONWebKeySet{Keys: append(unsupportedKeys, testJWK("key1", rsaKey))})
+
+	if err != nil || len(keys) != 1 || keys[0].KeyID != "key1" {
+		t.Fatalf("expected only key1, got %v : %v", keys, err)
+	}
+
+	// Document without any usable key is rejected
+	_, err = parseJSONWebKeySet(models.JSONWebKeySet{Keys: unsupportedKeys})
+
+	if err == nil {
+		t.Fatal("expected error without usable key")
+	}
+
+	// Encryption keys are ignored
+	keys, err = parseJSONWebKeySet(models.JSONWebKeySet{Keys: []models.JSONWebKey{{KeyType: "OKP", KeyID: "enc", Use: "enc"}}})
+
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected no key and no error, got %v : %v", keys, err)
+	}
+}
+
+func TestGetJWKSFailedFetchBackoff(t *testing.T) {
+
+	rsaKey, _ := generateTestKeys(t)
+
+	var mutex sync.Mutex
+
+	fetches := 0
+	available := false
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		fetches++
+
+		if !available {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(models.JSONWebKeySet{Keys: []models.JSONWebKey{testJWK("key1", rsaKey)}})
+	}))
+
+	defer jwksServer.Close()
+
+	client := NewAuthHTTPClient(models.AuthHTTPClientConfig{}.WithDefaults(), NewCircuitBreaker("testJWKSBackoffCircuitBreaker"))
+
+	_, err := getJWKS(client, jwksServer.URL, 0, false)
+
+	if err == nil || fetches != 1 {
+		t.Fatalf("expected error after 1 fetch, got %v after %d", err, fetches)
+	}
+
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+
+	// Failed fetches are not retried sooner than MinJWKSRefreshInterval, even when forced
+	_, err = getJWKS(client, jwksServer.URL, 0, true)
+
+	if err == nil || fetches != 1 {
+		t.Fatalf("expected error without fetch, got %v after %d fetches", err, fetches)
+	}
+
+	jwksCacheLock.Lock()
+	jwksCache[jwksServer.URL].AttemptedAt = time.Now().Add(-2 * MinJWKSRefreshInterval * time.Second)
+	jwksCacheLock.Unlock()
+
+	keys, err := getJWKS(client, jwksServer.URL, 0, false)
+
+	if err != nil || len(keys) != 1 || fetches != 2 {
+		t.Fatalf("expected 1 key after 2 fetches, got %v : %v after %d", keys, err, fetches)
+	}
+
+	// Outdated keys are served while refreshes fail, failures backing off as well
+	mutex.Lock()
+	available = false
+	mutex.Unlock()
+
+	jwksCacheLock.Lock()
+	jwksCache[jwksServer.URL].FetchedAt = time.Now().Add(-2 * DefaultJWKSRefreshInterval * time.Second)
+	jwksCacheLock.Unlock()
+
+	for i := 0; i < 3; i++ {
+
+		keys, err = getJWKS(client, jwksServer.URL, 0, false)
+
+		if err != nil || len(keys) != 1 || fetches != 3 {
+			t.Fatalf("expected outdated key after 3 fetches, got %v : %v after %d", keys, err, fetches)
+		}
+	}
+}
+
+func TestGetJWKSConcurrentFetches(t *testing.T) {
+
+	rsaKey, _ := generateTestKeys(t)
+
+	var mutex sync.Mutex
+
+	fetches := 0
+	release := make(chan struct{})
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mutex.Lock()
+		fetches++
+		mutex.Unlock()
+
+		<-release
+
+		json.NewEncoder(w).Encode(models.JSONWebKeySet{Keys: []models.JSONWebKey{testJWK("key1", rsaKey)}})
+	}))
+
+	defer slowServer.Close()
+
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.JSONWebKeySet{Keys: []models.JSONWebKey{testJWK("key2", rsaKey)}})
+	}))
+
+	defer fastServer.Close()
+
+	client := NewAuthHTTPClient(models.AuthHTTPClientConfig{}.WithDefaults(), NewCircuitBreaker("testJWKSConcurrencyCircuitBreaker"))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			keys, err := getJWKS(client, slowServer.URL, 0, false)
+
+			if err != nil || len(keys) != 1 {
+				t.Errorf("expected 1 key, got %v : %v", keys, err)
+			}
+		}()
+	}
+
+	// Other JWKS documents are fetched while a fetch is in flight
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+
+		mutex.Lock()
+		isInFlight := fetches > 0
+		mutex.Unlock()
+
+		if isInFlight {
+			break
+		}
+	}
+
+	keys, err := getJWKS(client, fastServer.URL, 0, false)
+
+	if err != nil || len(keys) != 1 || keys[0].KeyID != "key2" {
+		t.Fatalf("expected key2, got %v : %v", keys, err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Concurrent fetches of the same document are deduplicated
+	if fetches != 1 {
+		t.Fatalf("expected a single fetch, got %d", fetches)
+	}
+}
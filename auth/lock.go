@@ -0,0 +1,68 @@
+package auth
+
+import (
+	rand "crypto/rand"
+	hex "encoding/hex"
+	fmt "fmt"
+	log "log"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// LockTTL : Milliseconds after which a lock is released even if its holder never released it
+	LockTTL = 10000
+
+	// LockWaitTimeout : Maximum duration spent waiting for a lock
+	LockWaitTimeout = 5 * time.Second
+
+	// LockRetryInterval : Delay between two lock acquisition attempts
+	LockRetryInterval = 50 * time.Millisecond
+)
+
+// AcquireLock : Acquire Redis lock shared by all replicas, waiting up to LockWaitTimeout.
+// Returns a function releasing the lock, which only deletes it if it is still owned by caller.
+func AcquireLock(env *models.Env, key string) (func(), error) {
+
+	// Random owner value so that a lock expired and taken by someone else is never released by us
+	ownerBytes := make([]byte, 16)
+
+	_, err := rand.Read(ownerBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	owner := []byte(hex.EncodeToString(ownerBytes))
+	deadline := time.Now().Add(LockWaitTimeout)
+
+	for {
+
+		wasAcquired, err := env.Redis.SetNX(key, owner, LockTTL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if wasAcquired {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout acquiring lock %s", key)
+		}
+
+		time.Sleep(LockRetryInterval)
+	}
+
+	release := func() {
+
+		_, err := env.Redis.DeleteIfEquals(key, owner)
+
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return release, nil
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	rand "crypto/rand"
+	sha256 "crypto/sha256"
+	subtle "crypto/subtle"
+	base64 "encoding/base64"
+	hex "encoding/hex"
+	errors "errors"
+	fmt "fmt"
+	strings "strings"
+	models "wave-messaging-management-service/models"
+
+	argon2 "golang.org/x/crypto/argon2"
+	bcrypt "golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordHashAlgorithmBcrypt : bcrypt passhash ($2a$...), default algorithm
+	PasswordHashAlgorithmBcrypt = "bcrypt"
+
+	// PasswordHashAlgorithmSHA256 : Salted SHA-256 passhash ($sha256${salt}${digest}, hex encoded)
+	PasswordHashAlgorithmSHA256 = "sha256"
+
+	// PasswordHashAlgorithmArgon2id : Argon2id passhash in PHC string format ($argon2id$v=19$m=...,t=...,p=...${salt}${key})
+	PasswordHashAlgorithmArgon2id = "argon2id"
+
+	// DefaultBcryptCost : Bcrypt cost used to hash tokens when none is configured
+	DefaultBcryptCost = 14
+
+	// passwordSaltLength : Length in bytes of generated salts
+	passwordSaltLength = 16
+
+	// argon2idKeyLength : Length in bytes of Argon2id derived keys
+	argon2idKeyLength = 32
+)
+
+// PasswordHasher : Hash tokens into VerneMQ profile passhashes and check them
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	Check(password string, passhash string) bool
+	NeedsRehash(passhash string) bool
+}
+
+// GetPasswordHasher : Return password hasher selected by config
+func GetPasswordHasher(env *models.Env) (PasswordHasher, error) {
+	return GetPasswordHasherByAlgorithm(env, env.Config().PasswordHashAlgorithm)
+}
+
+// GetPasswordHasherByAlgorithm : Return password hasher of an algorithm, set up with config parameters.
+// Profiles created before algorithms were recorded have no algorithm and use bcrypt.
+func GetPasswordHasherByAlgorithm(env *models.Env, algorithm string) (PasswordHasher, error) {
+
+	switch algorithm {
+
+	case "", PasswordHashAlgorithmBcrypt:
+		return &BcryptHasher{Cost: env.Config().BcryptCost}, nil
+
+	case PasswordHashAlgorithmSHA256:
+		return &SHA256Hasher{}, nil
+
+	case PasswordHashAlgorithmArgon2id:
+		config := env.Config().Argon2id.WithDefaults()
+		return &Argon2idHasher{Time: config.Time, Memory: config.Memory, Threads: config.Threads}, nil
+	}
+
+	return nil, fmt.Errorf("unknown password hash algorithm %s", algorithm)
+}
+
+// DetectPasswordHashAlgorithm : Return algorithm of a passhash from its format, an empty string if unknown
+func DetectPasswordHashAlgorithm(passhash string) string {
+
+	switch {
+
+	case strings.HasPrefix(passhash, "$2"):
+		return PasswordHashAlgorithmBcrypt
+
+	case strings.HasPrefix(passhash, "$sha256$"):
+		return PasswordHashAlgorithmSHA256
+
+	case strings.HasPrefix(passhash, "$argon2id$"):
+		return PasswordHashAlgorithmArgon2id
+	}
+
+	return ""
+}
+
+// CheckPasswordHash : Check if password matches passhash of a profile hashed with provided algorithm
+func CheckPasswordHash(env *models.Env, password string, passhash string, algorithm string) bool {
+
+	hasher, err := GetPasswordHasherByAlgorithm(env, algorithm)
+
+	if err != nil {
+		return false
+	}
+
+	return hasher.Check(password, passhash)
+}
+
+// BcryptHasher : bcrypt password hasher
+type BcryptHasher struct {
+	Cost int
+}
+
+// Algorithm : Return algorithm name
+func (hasher *BcryptHasher) Algorithm() string {
+	return PasswordHashAlgorithmBcrypt
+}
+
+// cost : Return configured cost, DefaultBcryptCost if cost is out of bcrypt bounds
+func (hasher *BcryptHasher) cost() int {
+
+	if hasher.Cost < bcrypt.MinCost || hasher.Cost > bcrypt.MaxCost {
+		return DefaultBcryptCost
+	}
+
+	return hasher.Cost
+}
+
+// Hash : Hash password using bcrypt with configured cost
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost())
+	return string(bytes), err
+}
+
+// Check : Check if password matches bcrypt passhash
+func (hasher *BcryptHasher) Check(password string, passhash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(passhash), []byte(password))
+	return err == nil
+}
+
+// NeedsRehash : Check if passhash was computed with another cost
+func (hasher *BcryptHasher) NeedsRehash(passhash string) bool {
+	cost, err := bcrypt.Cost([]byte(passhash))
+	return err != nil || cost != hasher.cost()
+}
+
+// SHA256Hasher : Salted SHA-256 password hasher, cheap enough for high-churn tokens
+type SHA256Hasher struct{}
+
+// Algorithm : Return algorithm name
+func (hasher *SHA256Hasher) Algorithm() string {
+	return PasswordHashAlgorithmSHA256
+}
+
+// Hash : Hash password with a random salt
+func (hasher *SHA256Hasher) Hash(password string) (string, error) {
+
+	salt := make([]byte, passwordSaltLength)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$sha256$%s$%s", hex.EncodeToString(salt), hex.EncodeToString(sha256Digest(salt, password))), nil
+}
+
+// Check : Check if password matches salted SHA-256 passhash
+func (hasher *SHA256Hasher) Check(password string, passhash string) bool {
+
+	parts := strings.Split(passhash, "$")
+
+	if len(parts) != 4 || parts[1] != PasswordHashAlgorithmSHA256 {
+		return false
+	}
+
+	salt, err := hex.DecodeString(parts[2])
+
+	if err != nil {
+		return false
+	}
+
+	digest, err := hex.DecodeString(parts[3])
+
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(digest, sha256Digest(salt, password)) == 1
+}
+
+// NeedsRehash : Salted SHA-256 has no parameters, passhash only needs rehashing if malformed
+func (hasher *SHA256Hasher) NeedsRehash(passhash string) bool {
+	return DetectPasswordHashAlgorithm(passhash) != PasswordHashAlgorithmSHA256
+}
+
+// sha256Digest : Return SHA-256 digest of salt followed by password
+func sha256Digest(salt []byte, password string) []byte {
+	digest := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return digest[:]
+}
+
+// Argon2idHasher : Argon2id password hasher
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Algorithm : Return algorithm name
+func (hasher *Argon2idHasher) Algorithm() string {
+	return PasswordHashAlgorithmArgon2id
+}
+
+// Hash : Hash password with a random salt using configured parameters
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+
+	salt := make([]byte, passwordSaltLength)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.Memory, hasher.Threads, argon2idKeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, hasher.Memory, hasher.Time, hasher.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Check : Check if password matches Argon2id passhash, using the parameters it was computed with
+func (hasher *Argon2idHasher) Check(password string, passhash string) bool {
+
+	params, salt, key, err := parseArgon2idPasshash(passhash)
+
+	if err != nil {
+		return false
+	}
+
+	computedKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, computedKey) == 1
+}
+
+// NeedsRehash : Check if passhash was computed with other parameters
+func (hasher *Argon2idHasher) NeedsRehash(passhash string) bool {
+
+	params, _, _, err := parseArgon2idPasshash(passhash)
+
+	return err != nil || *params != *hasher
+}
+
+// parseArgon2idPasshash : Parse Argon2id PHC string into its parameters, salt and derived key
+func parseArgon2idPasshash(passhash string) (*Argon2idHasher, []byte, []byte, error) {
+
+	parts := strings.Split(passhash, "$")
+
+	if len(parts) != 6 || parts[1] != PasswordHashAlgorithmArgon2id {
+		return nil, nil, nil, errors.New("invalid argon2id passhash")
+	}
+
+	version := 0
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2id version")
+	}
+
+	params := Argon2idHasher{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters : %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id salt : %v", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id key")
+	}
+
+	return &params, salt, key, nil
+}
+
+// RehashIfOutdated : Rehash passhash of a device profile with configured algorithm & parameters if it was computed otherwise.
+// Called on login with the token the passhash was computed from, returns true if passhash was rehashed.
+func RehashIfOutdated(env *models.Env, clientID string, token string) (bool, error) {
+
+	hasher, err := GetPasswordHasher(env)
+
+	if err != nil {
+		return false, err
+	}
+
+	profile, err := env.MongoDB.GetProfileACL(clientID)
+
+	if err != nil {
+		return false, err
+	}
+
+	// Revoked profiles are left as is
+	if profile.Passhash == "" {
+		return false, nil
+	}
+
+	if profile.HashAlgorithm == hasher.Algorithm() && !hasher.NeedsRehash(profile.Passhash) {
+		return false, nil
+	}
+
+	// A pending outbox entry will overwrite passhash anyway
+	_, err = env.Redis.HGet(PasshashOutboxKey, clientID)
+
+	if err == nil {
+		return false, nil
+	}
+
+	// Passhash may already belong to a newer token of the device
+	if !CheckPasswordHash(env, token, profile.Passhash, profile.HashAlgorithm) {
+		return false, nil
+	}
+
+	newPasshash, err := hasher.Hash(token)
+
+	if err != nil {
+		return false, err
+	}
+
+	// Passhash is only replaced if it was not rotated in the meantime
+	return env.MongoDB.ReplacePassHash(clientID, profile.Passhash, newPasshash, hasher.Algorithm())
+}
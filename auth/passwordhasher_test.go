@@ -0,0 +1,193 @@
+package auth
+
+import (
+	testing "testing"
+	models "wave-messaging-management-service/models"
+)
+
+// testArgon2idConfig : Argon2id parameters cheap enough for tests
+var testArgon2idConfig = models.Argon2idConfig{Time: 1, Memory: 64, Threads: 1}
+
+func (mongoDB *profilesMongoDB) ReplacePassHash(clientID string, oldPasshash string, newPasshash string, hashAlgorithm string) (bool, error) {
+
+	profile, isStored := mongoDB.profiles[clientID]
+
+	if !isStored || profile.Passhash != oldPasshash {
+		return false, nil
+	}
+
+	profile.Passhash = newPasshash
+	profile.HashAlgorithm = hashAlgorithm
+
+	return true, nil
+}
+
+func TestPasswordHashers(t *testing.T) {
+
+	env := &models.Env{}
+	env.SetConfig(&models.Config{BcryptCost: 4, Argon2id: testArgon2idConfig})
+
+	for _, algorithm := range []string{PasswordHashAlgorithmBcrypt, PasswordHashAlgorithmSHA256, PasswordHashAlgorithmArgon2id} {
+
+		hasher, err := GetPasswordHasherByAlgorithm(env, algorithm)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passhash, err := hasher.Hash("token1")
+
+		if err != nil {
+			t.Fatalf("%s : %v", algorithm, err)
+		}
+
+		otherPasshash, err := hasher.Hash("token1")
+
+		if err != nil {
+			t.Fatalf("%s : %v", algorithm, err)
+		}
+
+		if hasher.Algorithm() != algorithm || DetectPasswordHashAlgorithm(passhash) != algorithm {
+			t.Errorf("%s : passhash %s detected as %s", algorithm, passhash, DetectPasswordHashAlgorithm(passhash))
+		}
+
+		// Passhashes are salted
+		if passhash == otherPasshash {
+			t.Errorf("%s : same passhash computed twice", algorithm)
+		}
+
+		if !hasher.Check("token1", passhash) || !hasher.Check("token1", otherPasshash) || !CheckPasswordHash(env, "token1", passhash, algorithm) {
+			t.Errorf("%s : password does not match its passhash", algorithm)
+		}
+
+		for _, wrongPassword := range []string{"token2", "token", "token1 ", ""} {
+			if hasher.Check(wrongPassword, passhash) || CheckPasswordHash(env, wrongPassword, passhash, algorithm) {
+				t.Errorf("%s : wrong password %q matches passhash", algorithm, wrongPassword)
+			}
+		}
+
+		if hasher.NeedsRehash(passhash) {
+			t.Errorf("%s : fresh passhash needs rehash", algorithm)
+		}
+	}
+
+	// Passhashes are only checked with the algorithm they were computed with
+	sha256Passhash, _ := (&SHA256Hasher{}).Hash("token1")
+
+	if CheckPasswordHash(env, "token1", sha256Passhash, PasswordHashAlgorithmArgon2id) || CheckPasswordHash(env, "token1", sha256Passhash, "md5") {
+		t.Errorf("passhash checked with another algorithm")
+	}
+}
+
+func TestDetectPasswordHashAlgorithm(t *testing.T) {
+
+	for passhash, expected := range map[string]string{
+		"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy": PasswordHashAlgorithmBcrypt,
+		"$2b$04$abcdefghijklmnopqrstuu":                                PasswordHashAlgorithmBcrypt,
+		"$sha256$00ff$abcd":                                            PasswordHashAlgorithmSHA256,
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5":                      PasswordHashAlgorithmArgon2id,
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5":                       "",
+		"sha256$00ff$abcd":                                             "",
+		"token1":                                                       "",
+		"":                                                             "",
+	} {
+
+		if algorithm := DetectPasswordHashAlgorithm(passhash); algorithm != expected {
+			t.Errorf("%q : expected %q, got %q", passhash, expected, algorithm)
+		}
+	}
+}
+
+func TestPasswordHashersNeedsRehash(t *testing.T) {
+
+	bcryptPasshash, _ := (&BcryptHasher{Cost: 4}).Hash("token1")
+	argon2idPasshash, _ := (&Argon2idHasher{Time: 1, Memory: 64, Threads: 1}).Hash("token1")
+
+	for _, test := range []struct {
+		hasher   PasswordHasher
+		passhash string
+		expected bool
+	}{
+		{&BcryptHasher{Cost: 4}, bcryptPasshash, false},
+		{&BcryptHasher{Cost: 5}, bcryptPasshash, true},
+		{&BcryptHasher{Cost: 4}, "$sha256$00ff$abcd", true},
+		{&SHA256Hasher{}, "$sha256$00ff$abcd", false},
+		{&SHA256Hasher{}, bcryptPasshash, true},
+		{&Argon2idHasher{Time: 1, Memory: 64, Threads: 1}, argon2idPasshash, false},
+		{&Argon2idHasher{Time: 2, Memory: 64, Threads: 1}, argon2idPasshash, true},
+		{&Argon2idHasher{Time: 1, Memory: 128, Threads: 1}, argon2idPasshash, true},
+		{&Argon2idHasher{Time: 1, Memory: 64, Threads: 1}, "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$", true},
+	} {
+
+		if needsRehash := test.hasher.NeedsRehash(test.passhash); needsRehash != test.expected {
+			t.Errorf("%s %+v, passhash %s : expected %v, got %v", test.hasher.Algorithm(), test.hasher, test.passhash, test.expected, needsRehash)
+		}
+	}
+}
+
+func TestRehashIfOutdated(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, "")
+	defer closeEnv()
+
+	bcryptPasshash, _ := (&BcryptHasher{Cost: 4}).Hash("token1")
+	argon2idPasshash, _ := (&Argon2idHasher{Time: 1, Memory: 64, Threads: 1}).Hash("token1")
+
+	for _, test := range []struct {
+		name          string
+		config        models.Config
+		passhash      string
+		hashAlgorithm string
+		token         string
+		isPending     bool
+		rehashed      bool
+		algorithm     string
+	}{
+		{"algorithm changed", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmArgon2id, Argon2id: testArgon2idConfig}, bcryptPasshash, PasswordHashAlgorithmBcrypt, "token1", false, true, PasswordHashAlgorithmArgon2id},
+		{"profile without algorithm", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmSHA256}, bcryptPasshash, "", "token1", false, true, PasswordHashAlgorithmSHA256},
+		{"bcrypt cost changed", models.Config{BcryptCost: 5}, bcryptPasshash, PasswordHashAlgorithmBcrypt, "token1", false, true, PasswordHashAlgorithmBcrypt},
+		{"argon2id parameters changed", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmArgon2id, Argon2id: models.Argon2idConfig{Time: 2, Memory: 64, Threads: 1}}, argon2idPasshash, PasswordHashAlgorithmArgon2id, "token1", false, true, PasswordHashAlgorithmArgon2id},
+		{"up to date", models.Config{BcryptCost: 4}, bcryptPasshash, PasswordHashAlgorithmBcrypt, "token1", false, false, PasswordHashAlgorithmBcrypt},
+		{"newer token", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmSHA256}, bcryptPasshash, PasswordHashAlgorithmBcrypt, "token2", false, false, PasswordHashAlgorithmBcrypt},
+		{"pending outbox entry", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmSHA256}, bcryptPasshash, PasswordHashAlgorithmBcrypt, "token1", true, false, PasswordHashAlgorithmBcrypt},
+		{"revoked profile", models.Config{PasswordHashAlgorithm: PasswordHashAlgorithmSHA256}, "", PasswordHashAlgorithmBcrypt, "token1", false, false, PasswordHashAlgorithmBcrypt},
+	} {
+
+		mongoDB := &profilesMongoDB{profiles: map[string]*models.VerneMQACL{
+			"client1": {ClientID: "client1", Passhash: test.passhash, HashAlgorithm: test.hashAlgorithm},
+		}}
+
+		env.MongoDB = mongoDB
+		env.SetConfig(&test.config)
+
+		env.Redis.Delete(PasshashOutboxKey)
+
+		if test.isPending {
+			env.Redis.HSetNX(PasshashOutboxKey, "client1", []byte("pending"))
+		}
+
+		rehashed, err := RehashIfOutdated(env, "client1", test.token)
+
+		if err != nil || rehashed != test.rehashed {
+			t.Errorf("%s : expected rehashed %v, got %v : %v", test.name, test.rehashed, rehashed, err)
+			continue
+		}
+
+		profile := mongoDB.profiles["client1"]
+
+		if !test.rehashed {
+
+			if profile.Passhash != test.passhash {
+				t.Errorf("%s : passhash changed", test.name)
+			}
+
+			continue
+		}
+
+		hasher, _ := GetPasswordHasher(env)
+
+		if profile.HashAlgorithm != test.algorithm || profile.Passhash == test.passhash || !CheckPasswordHash(env, test.token, profile.Passhash, profile.HashAlgorithm) || hasher.NeedsRehash(profile.Passhash) {
+			t.Errorf("%s : unexpected rehashed profile %+v", test.name, profile)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	fmt "fmt"
+	log "log"
+	strings "strings"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// PasshashOutboxKey : Redis hash of device profile passhashes written to Redis but not yet to MongoDB, by MQTT client ID
+	PasshashOutboxKey = "outbox:passhash"
+
+	// DefaultReconciliationInterval : Seconds between two reconciliations when none is configured
+	DefaultReconciliationInterval = 60
+)
+
+// passhashLockKey : Return key of the lock held while the pending passhash of a device profile is applied
+func passhashLockKey(clientID string) string {
+	return fmt.Sprintf("lock:passhash:%s", clientID)
+}
+
+// ApplyPendingPasshash : Write passhash and its algorithm to MongoDB profile and remove matching outbox entry.
+// Passhash is only written while it is still the pending one, so that a newer rotation or revocation applied in the meantime
+// is never overwritten by a stale value.
+func ApplyPendingPasshash(env *models.Env, clientID string, passhash string) error {
+
+	release, err := AcquireLock(env, passhashLockKey(clientID))
+
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	pendingPasshashes, err := env.Redis.HGetMulti([]string{PasshashOutboxKey}, clientID)
+
+	if err != nil {
+		return err
+	}
+
+	// Entry replaced by a newer passhash is applied by its writer, missing entry was already applied
+	if pendingPasshashes[0] == nil || string(pendingPasshashes[0]) != passhash {
+		return nil
+	}
+
+	err = env.MongoDB.UpdatePassHash(clientID, passhash, DetectPasswordHashAlgorithm(passhash))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = env.Redis.HDeleteIfEquals(PasshashOutboxKey, clientID, []byte(passhash))
+
+	return err
+}
+
+// ReconcilePendingPasshashes : Apply every pending outbox passhash left by partially rotated users.
+// Entries that can't be applied are logged and kept for the next run, without preventing the others from being applied.
+// Returns the number of repaired profiles, and an error counting failed entries if any.
+func ReconcilePendingPasshashes(env *models.Env) (int, error) {
+
+	pendingPasshashes, err := env.Redis.HGetAll(PasshashOutboxKey)
+
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	failed := 0
+
+	for clientID, passhash := range pendingPasshashes {
+
+		err = ApplyPendingPasshash(env, clientID, string(passhash))
+
+		if err != nil {
+			log.Printf("Error applying pending passhash of %s : %v", clientID, err)
+			failed++
+			continue
+		}
+
+		repaired++
+	}
+
+	if failed > 0 {
+		return repaired, fmt.Errorf("%d of %d pending passhash(es) could not be applied", failed, len(pendingPasshashes))
+	}
+
+	return repaired, nil
+}
+
+// RepairMapping : Restore internal Wave user ID of a mapping from the session of its latest token digest.
+// Returns the restored internal Wave user ID, or an empty string if it could not be recovered.
+func RepairMapping(env *models.Env, originalUserID string) (string, error) {
+
+	mappingKey := fmt.Sprintf("mapping:%s", originalUserID)
+
+	tokenDigest, err := env.Redis.HGet(mappingKey, "tokenDigest")
+
+	if err != nil || len(tokenDigest) == 0 {
+		return "", err
+	}
+
+	internalWaveUserID, err := CheckIfTokenDigestIsCached(env, string(tokenDigest))
+
+	if err != nil || internalWaveUserID == "" {
+		return "", err
+	}
+
+	err = env.Redis.Transaction(
+		models.NewRedisCommand("HSET", mappingKey, "tokenDigest", tokenDigest, "internalWaveUserID", internalWaveUserID),
+		reverseMappingCommand(internalWaveUserID, originalUserID),
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	return internalWaveUserID, nil
+}
+
+// RepairMappings : Detect mappings whose internal Wave user ID was lost and restore them.
+// Returns the number of repaired mappings.
+func RepairMappings(env *models.Env) (int, error) {
+
+	mappingKeys, err := env.Redis.GetKeys("mapping:*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+
+	for _, mappingKey := range mappingKeys {
+
+		internalWaveUserID, _ := env.Redis.HGet(mappingKey, "internalWaveUserID")
+
+		if len(internalWaveUserID) > 0 {
+			continue
+		}
+
+		restoredInternalWaveUserID, err := RepairMapping(env, strings.TrimPrefix(mappingKey, "mapping:"))
+
+		if err != nil || restoredInternalWaveUserID == "" {
+			log.Printf("Mapping %s has no internal Wave user ID and could not be repaired : %v", mappingKey, err)
+			continue
+		}
+
+		repaired++
+	}
+
+	return repaired, nil
+}
+
+// StartReconciliation : Periodically apply pending passhashes, interval is read from config on each run
+func StartReconciliation(env *models.Env) {
+
+	for {
+
+		interval := env.Config().ReconciliationInterval
+
+		if interval <= 0 {
+			interval = DefaultReconciliationInterval
+		}
+
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		repaired, err := ReconcilePendingPasshashes(env)
+
+		if err != nil {
+			log.Println(err)
+		}
+
+		if repaired > 0 {
+			log.Printf("Reconciliation repaired %d partially rotated profile(s)", repaired)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	errors "errors"
+	sync "sync"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+)
+
+// passhashMongoDB : Profiles passhashes store, failing updates of some client IDs
+type passhashMongoDB struct {
+	models.MongoDBInterface
+
+	mutex            sync.Mutex
+	passhashes       map[string]string
+	failingClientIDs map[string]bool
+}
+
+func (mongoDB *passhashMongoDB) UpdatePassHash(clientID string, passhash string, hashAlgorithm string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	if mongoDB.failingClientIDs[clientID] {
+		return errors.New("profile update failed")
+	}
+
+	mongoDB.passhashes[clientID] = passhash
+
+	return nil
+}
+
+func TestReconcilePendingPasshashesContinuesOnError(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, "")
+	defer closeEnv()
+
+	mongoDB := &passhashMongoDB{
+		passhashes:       map[string]string{},
+		failingClientIDs: map[string]bool{"client2": true},
+	}
+
+	env.MongoDB = mongoDB
+
+	err := env.Redis.HSet(PasshashOutboxKey, "client1", []byte("passhash1"), "client2", []byte("passhash2"))
+
+	if err == nil {
+		err = env.Redis.HSet(PasshashOutboxKey, "client3", []byte("passhash3"), "client4", []byte("passhash4"))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repaired, err := ReconcilePendingPasshashes(env)
+
+	if err == nil || repaired != 3 {
+		t.Fatalf("expected 3 repaired profiles and an error, got %d : %v", repaired, err)
+	}
+
+	if mongoDB.passhashes["client1"] != "passhash1" || mongoDB.passhashes["client3"] != "passhash3" || mongoDB.passhashes["client4"] != "passhash4" {
+		t.Errorf("unexpected profile passhashes %v", mongoDB.passhashes)
+	}
+
+	// Failed entry is kept for the next run
+	pendingPasshashes, err := env.Redis.HGetAll(PasshashOutboxKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pendingPasshashes) != 1 || string(pendingPasshashes["client2"]) != "passhash2" {
+		t.Fatalf("unexpected pending passhashes %v", pendingPasshashes)
+	}
+
+	mongoDB.failingClientIDs = map[string]bool{}
+
+	repaired, err = ReconcilePendingPasshashes(env)
+
+	if err != nil || repaired != 1 || mongoDB.passhashes["client2"] != "passhash2" {
+		t.Fatalf("expected failed entry to be applied on next run, got %d : %v", repaired, err)
+	}
+}
+
+// Reconciliation working from an outdated snapshot of the outbox never restores a replaced passhash
+func TestApplyPendingPasshashIgnoresStaleEntries(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, "")
+	defer closeEnv()
+
+	mongoDB := &passhashMongoDB{passhashes: map[string]string{}, failingClientIDs: map[string]bool{}}
+
+	env.MongoDB = mongoDB
+
+	// Snapshot read by reconciliation before the device is rotated then revoked
+	err := env.Redis.HSet(PasshashOutboxKey, "client1", []byte("passhash1"), "client2", []byte("passhash2"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stalePendingPasshashes, err := env.Redis.HGetAll(PasshashOutboxKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Newer passhash of client1 still pending, revocation of client2 already applied
+	err = env.Redis.HSet(PasshashOutboxKey, "client1", []byte("rotatedpasshash1"), "client2", []byte(""))
+
+	if err == nil {
+		err = ApplyPendingPasshash(env, "client2", "")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for clientID, passhash := range stalePendingPasshashes {
+
+		err = ApplyPendingPasshash(env, clientID, string(passhash))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if passhash, isSet := mongoDB.passhashes["client1"]; isSet {
+		t.Errorf("stale passhash %s applied over a pending one", passhash)
+	}
+
+	if mongoDB.passhashes["client2"] != "" {
+		t.Errorf("revoked device got its credentials back : %s", mongoDB.passhashes["client2"])
+	}
+
+	repaired, err := ReconcilePendingPasshashes(env)
+
+	if err != nil || repaired != 1 || mongoDB.passhashes["client1"] != "rotatedpasshash1" {
+		t.Fatalf("expected pending passhash to be applied, got %d %v : %v", repaired, mongoDB.passhashes, err)
+	}
+}
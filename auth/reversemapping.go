@@ -0,0 +1,112 @@
+package auth
+
+import (
+	fmt "fmt"
+	strings "strings"
+	models "wave-messaging-management-service/models"
+)
+
+// reverseMappingKey : Return key of the reverse mapping of an internal Wave user ID
+func reverseMappingKey(internalWaveUserID string) string {
+	return fmt.Sprintf("internal:%s", internalWaveUserID)
+}
+
+// reverseMappingCommand : Return command storing internal:{internalWaveUserID} -> {originalUserID} reverse mapping.
+// Queued along with every mapping update so that both stay consistent.
+func reverseMappingCommand(internalWaveUserID string, originalUserID string) models.RedisCommand {
+	return models.NewRedisCommand("SET", reverseMappingKey(internalWaveUserID), originalUserID)
+}
+
+// GetOriginalUserID : Return original user ID mapped with an internal Wave user ID, an empty string if unknown
+func GetOriginalUserID(env *models.Env, internalWaveUserID string) (string, error) {
+
+	originalUserIDs, err := GetOriginalUserIDs(env, []string{internalWaveUserID})
+
+	if err != nil {
+		return "", err
+	}
+
+	return originalUserIDs[0], nil
+}
+
+// GetOriginalUserIDs : Return original user IDs mapped with internal Wave user IDs in a single round trip,
+// in the same order, an empty string for unknown users
+func GetOriginalUserIDs(env *models.Env, internalWaveUserIDs []string) ([]string, error) {
+
+	keys := make([]string, len(internalWaveUserIDs))
+
+	for i, internalWaveUserID := range internalWaveUserIDs {
+		keys[i] = reverseMappingKey(internalWaveUserID)
+	}
+
+	values, err := env.Redis.MGet(keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesToStrings(values), nil
+}
+
+// GetInternalWaveUserIDs : Return internal Wave user IDs mapped with original user IDs in a single round trip,
+// in the same order, an empty string for unknown users
+func GetInternalWaveUserIDs(env *models.Env, originalUserIDs []string) ([]string, error) {
+
+	keys := make([]string, len(originalUserIDs))
+
+	for i, originalUserID := range originalUserIDs {
+		keys[i] = fmt.Sprintf("mapping:%s", originalUserID)
+	}
+
+	values, err := env.Redis.HGetMulti(keys, "internalWaveUserID")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesToStrings(values), nil
+}
+
+// bytesToStrings : Convert Redis values to strings, nil values being converted to empty strings
+func bytesToStrings(values [][]byte) []string {
+
+	strs := make([]string, len(values))
+
+	for i, value := range values {
+		strs[i] = string(value)
+	}
+
+	return strs
+}
+
+// BackfillReverseMappings : Store reverse mappings of mappings written before reverse mappings were maintained.
+// Returns the number of stored reverse mappings.
+func BackfillReverseMappings(env *models.Env) (int, error) {
+
+	mappingKeys, err := env.Redis.GetKeys("mapping:*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	backfilled := 0
+
+	for _, mappingKey := range mappingKeys {
+
+		internalWaveUserID, _ := env.Redis.HGet(mappingKey, "internalWaveUserID")
+
+		if len(internalWaveUserID) == 0 {
+			continue
+		}
+
+		err = env.Redis.Set(reverseMappingKey(string(internalWaveUserID)), []byte(strings.TrimPrefix(mappingKey, "mapping:")))
+
+		if err != nil {
+			return backfilled, err
+		}
+
+		backfilled++
+	}
+
+	return backfilled, nil
+}
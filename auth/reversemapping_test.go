@@ -0,0 +1,57 @@
+package auth
+
+import (
+	reflect "reflect"
+	testing "testing"
+)
+
+func TestGetMappedUserIDs(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, "")
+	defer closeEnv()
+
+	for _, originalUserID := range []string{"user1", "user2", "user3"} {
+
+		err := env.Redis.HSet("mapping:"+originalUserID, "internalWaveUserID", []byte("internal-"+originalUserID), "tokenDigest", []byte("digest"))
+
+		if err == nil {
+			err = env.Redis.Set(reverseMappingKey("internal-"+originalUserID), []byte(originalUserID))
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// IDs are resolved in request order, duplicates included, unknown users being resolved to empty strings
+	internalWaveUserIDs, err := GetInternalWaveUserIDs(env, []string{"user3", "unknown", "user1", "user3", ""})
+
+	if expected := []string{"internal-user3", "", "internal-user1", "internal-user3", ""}; err != nil || !reflect.DeepEqual(internalWaveUserIDs, expected) {
+		t.Errorf("expected internal Wave user IDs %q, got %q, %v", expected, internalWaveUserIDs, err)
+	}
+
+	originalUserIDs, err := GetOriginalUserIDs(env, []string{"internal-user2", "internal-unknown", "internal-user1", "user1", "internal-user2"})
+
+	if expected := []string{"user2", "", "user1", "", "user2"}; err != nil || !reflect.DeepEqual(originalUserIDs, expected) {
+		t.Errorf("expected original user IDs %q, got %q, %v", expected, originalUserIDs, err)
+	}
+
+	originalUserID, err := GetOriginalUserID(env, "internal-user3")
+
+	if err != nil || originalUserID != "user3" {
+		t.Errorf("expected original user ID user3, got %q, %v", originalUserID, err)
+	}
+
+	// Empty requests resolve no user
+	internalWaveUserIDs, err = GetInternalWaveUserIDs(env, []string{})
+
+	if err != nil || len(internalWaveUserIDs) != 0 {
+		t.Errorf("expected no internal Wave user ID, got %q, %v", internalWaveUserIDs, err)
+	}
+
+	originalUserIDs, err = GetOriginalUserIDs(env, []string{})
+
+	if err != nil || len(originalUserIDs) != 0 {
+		t.Errorf("expected no original user ID, got %q, %v", originalUserIDs, err)
+	}
+}
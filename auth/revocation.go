@@ -0,0 +1,197 @@
+package auth
+
+import (
+	errors "errors"
+	fmt "fmt"
+	log "log"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// CodeUnknownUser : Error code returned when revoking sessions of a user without mapping
+	CodeUnknownUser = "UnknownUser"
+)
+
+var (
+	// ErrUnknownUser : Original user ID has no mapping
+	ErrUnknownUser = errors.New(CodeUnknownUser)
+)
+
+// RevokeSession : End session of token, invalidate VerneMQ credentials of its device and disconnect it.
+// Returns MQTT client ID of the revoked device, or an empty string if no device was revoked.
+func RevokeSession(env *models.Env, token string) (string, error) {
+
+	tokenDigest := DigestToken(env, token)
+
+	internalWaveUserID, _ := CheckIfTokenDigestIsCached(env, tokenDigest)
+
+	if internalWaveUserID == "" {
+		return "", nil
+	}
+
+	originalUserID, err := GetOriginalUserID(env, internalWaveUserID)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Serialize with logins & revocations of the same user.
+	// Users mapped before reverse mappings were maintained can't be locked until BackfillReverseMappings ran.
+	if originalUserID != "" {
+
+		release, err := AcquireLock(env, fmt.Sprintf("lock:mapping:%s", originalUserID))
+
+		if err != nil {
+			return "", err
+		}
+
+		defer release()
+
+		// Session may have been rotated or revoked while waiting for lock
+		internalWaveUserID, _ = CheckIfTokenDigestIsCached(env, tokenDigest)
+
+		if internalWaveUserID == "" {
+			return "", nil
+		}
+
+	} else {
+		log.Printf("Session of %s revoked without lock : no reverse mapping", internalWaveUserID)
+	}
+
+	deviceSessions, err := GetDeviceSessions(env, internalWaveUserID)
+
+	if err != nil {
+		return "", err
+	}
+
+	deviceSession := findDeviceSession(deviceSessions, tokenDigest)
+
+	// Sessions opened before multi-device support belong to the default device
+	if deviceSession == nil {
+
+		// Default device already logged in again since : only end the older session
+		if _, hasDefaultDevice := deviceSessions[models.DefaultDeviceID]; hasDefaultDevice {
+
+			return "", env.Redis.Transaction(
+				models.NewRedisCommand("DEL", fmt.Sprintf("session:%s", tokenDigest)),
+				models.NewRedisCommand("DEL", fmt.Sprintf("revalidation:%s", tokenDigest)),
+			)
+		}
+
+		deviceSession = models.NewDeviceSession(models.DefaultDeviceID, tokenDigest, 0, 0)
+	}
+
+	err = RevokeDeviceSession(env, internalWaveUserID, deviceSession)
+
+	if err != nil {
+		return "", err
+	}
+
+	clientID := models.DeviceClientID(internalWaveUserID, deviceSession.DeviceID)
+
+	disconnectClient(env, clientID)
+
+	return clientID, nil
+}
+
+// RevokeUser : End sessions of all devices of a user, invalidate their VerneMQ credentials and disconnect them.
+// Returns MQTT client IDs of the revoked devices.
+func RevokeUser(env *models.Env, originalUserID string) ([]string, error) {
+
+	// Serialize with logins of the same user
+	release, err := AcquireLock(env, fmt.Sprintf("lock:mapping:%s", originalUserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	internalWaveUserID, latestTokenDigest, _ := CheckIfUserAlreadyHasToken(env, originalUserID)
+
+	if internalWaveUserID == "" {
+		return nil, ErrUnknownUser
+	}
+
+	deviceSessions, err := GetDeviceSessions(env, internalWaveUserID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Latest token may belong to a session opened before multi-device support
+	if latestTokenDigest != "" && findDeviceSession(deviceSessions, latestTokenDigest) == nil {
+
+		if _, hasDefaultDevice := deviceSessions[models.DefaultDeviceID]; !hasDefaultDevice {
+			deviceSessions[models.DefaultDeviceID] = models.NewDeviceSession(models.DefaultDeviceID, latestTokenDigest, 0, 0)
+		}
+	}
+
+	revokedClientIDs := []string{}
+
+	for _, deviceSession := range deviceSessions {
+
+		err = RevokeDeviceSession(env, internalWaveUserID, deviceSession)
+
+		if err != nil {
+			return revokedClientIDs, err
+		}
+
+		clientID := models.DeviceClientID(internalWaveUserID, deviceSession.DeviceID)
+
+		disconnectClient(env, clientID)
+
+		revokedClientIDs = append(revokedClientIDs, clientID)
+	}
+
+	return revokedClientIDs, nil
+}
+
+// DisconnectUserDevices : Disconnect live MQTT clients of all devices of a user so that they reconnect and subscribe again under their current ACLs.
+// Sessions and credentials are left untouched.
+func DisconnectUserDevices(env *models.Env, internalWaveUserID string) error {
+
+	deviceSessions, err := GetDeviceSessions(env, internalWaveUserID)
+
+	if err != nil {
+		return err
+	}
+
+	// Users who only logged in before multi-device support have a single default device
+	if len(deviceSessions) == 0 {
+		disconnectClient(env, models.DeviceClientID(internalWaveUserID, models.DefaultDeviceID))
+	}
+
+	for deviceID := range deviceSessions {
+		disconnectClient(env, models.DeviceClientID(internalWaveUserID, deviceID))
+	}
+
+	return nil
+}
+
+// findDeviceSession : Return device session holding token digest, nil if none does
+func findDeviceSession(deviceSessions map[string]*models.DeviceSession, tokenDigest string) *models.DeviceSession {
+
+	for _, deviceSession := range deviceSessions {
+		if deviceSession.TokenDigest == tokenDigest {
+			return deviceSession
+		}
+	}
+
+	return nil
+}
+
+// disconnectClient : Disconnect live MQTT client through configured broker administration interface if any.
+// Failures are only logged : credentials of the client are already invalidated.
+func disconnectClient(env *models.Env, clientID string) {
+
+	if env.BrokerAdmin == nil {
+		return
+	}
+
+	err := env.BrokerAdmin.DisconnectClient(clientID)
+
+	if err != nil {
+		log.Printf("Disconnection of MQTT client %s failed : %v", clientID, err)
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	sync "sync"
+	testing "testing"
+	time "time"
+	models "wave-messaging-management-service/models"
+)
+
+// fakeBrokerAdmin : Broker administration interface recording disconnected clients instead of calling a broker
+type fakeBrokerAdmin struct {
+	DisconnectedClientIDs []string
+	lock                  sync.Mutex
+}
+
+// DisconnectClient : Record client as disconnected
+func (admin *fakeBrokerAdmin) DisconnectClient(clientID string) error {
+
+	admin.lock.Lock()
+	defer admin.lock.Unlock()
+
+	admin.DisconnectedClientIDs = append(admin.DisconnectedClientIDs, clientID)
+
+	return nil
+}
+
+// disconnectedClientIDs : Return clients disconnected since last call
+func (admin *fakeBrokerAdmin) disconnectedClientIDs() map[string]bool {
+
+	admin.lock.Lock()
+	defer admin.lock.Unlock()
+
+	clientIDs := map[string]bool{}
+
+	for _, clientID := range admin.DisconnectedClientIDs {
+		clientIDs[clientID] = true
+	}
+
+	admin.DisconnectedClientIDs = nil
+
+	return clientIDs
+}
+
+// newTestRevocationEnv : Return environment where user1 is logged in on a phone & a laptop and user2 on a phone,
+// with MQTT client IDs by token
+func newTestRevocationEnv(t *testing.T) (*models.Env, *fakeBrokerAdmin, map[string]string, func()) {
+
+	var verifications int32
+
+	authEndpoint := newTestAuthEndpoint(&verifications)
+
+	env, closeEnv := newTestEnv(t, authEndpoint.URL)
+
+	brokerAdmin := &fakeBrokerAdmin{}
+
+	env.MongoDB = &passhashMongoDB{passhashes: map[string]string{}, failingClientIDs: map[string]bool{}}
+	env.BrokerAdmin = brokerAdmin
+
+	clientIDs := map[string]string{}
+
+	for _, login := range [][2]string{{"user1.phone", "phone"}, {"user1.laptop", "laptop"}, {"user2.phone", "phone"}} {
+
+		MQTTAuthInfos, _, _, err := CheckAuthentication(env, login[0], login[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientIDs[login[0]] = MQTTAuthInfos.ClientID
+	}
+
+	return env, brokerAdmin, clientIDs, func() {
+		closeEnv()
+		authEndpoint.Close()
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+
+	env, brokerAdmin, clientIDs, closeEnv := newTestRevocationEnv(t)
+	defer closeEnv()
+
+	clientID, err := RevokeSession(env, "user1.phone")
+
+	if err != nil || clientID != clientIDs["user1.phone"] {
+		t.Fatalf("expected %s to be revoked, got %s : %v", clientIDs["user1.phone"], clientID, err)
+	}
+
+	// Only the device of the token is disconnected & has its credentials invalidated
+	disconnected := brokerAdmin.disconnectedClientIDs()
+
+	if len(disconnected) != 1 || !disconnected[clientIDs["user1.phone"]] {
+		t.Fatalf("unexpected disconnected clients %v", disconnected)
+	}
+
+	passhash, isSet := env.MongoDB.(*passhashMongoDB).passhashes[clientIDs["user1.phone"]]
+
+	if !isSet || passhash != "" {
+		t.Fatalf("credentials of %s not invalidated", clientIDs["user1.phone"])
+	}
+
+	for token, isCached := range map[string]bool{"user1.phone": false, "user1.laptop": true, "user2.phone": true} {
+
+		internalWaveUserID, _ := CheckIfTokenIsCached(env, token)
+
+		if (internalWaveUserID != "") != isCached {
+			t.Errorf("session of %s cached : %v, expected %v", token, internalWaveUserID != "", isCached)
+		}
+	}
+
+	// Revoked session is no longer known
+	clientID, err = RevokeSession(env, "user1.phone")
+
+	if err != nil || clientID != "" || len(brokerAdmin.disconnectedClientIDs()) != 0 {
+		t.Fatalf("expected nothing to revoke, got %s : %v", clientID, err)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+
+	env, brokerAdmin, clientIDs, closeEnv := newTestRevocationEnv(t)
+	defer closeEnv()
+
+	revokedClientIDs, err := RevokeUser(env, "user1")
+
+	if err != nil || len(revokedClientIDs) != 2 {
+		t.Fatalf("expected 2 revoked devices, got %v : %v", revokedClientIDs, err)
+	}
+
+	// Every device of the user is disconnected, other users are left connected
+	disconnected := brokerAdmin.disconnectedClientIDs()
+
+	if len(disconnected) != 2 || !disconnected[clientIDs["user1.phone"]] || !disconnected[clientIDs["user1.laptop"]] {
+		t.Fatalf("unexpected disconnected clients %v", disconnected)
+	}
+
+	for _, clientID := range revokedClientIDs {
+		if !disconnected[clientID] {
+			t.Errorf("revoked client %s not disconnected", clientID)
+		}
+	}
+
+	internalWaveUserID, _ := CheckIfTokenIsCached(env, "user2.phone")
+
+	if internalWaveUserID == "" {
+		t.Errorf("session of user2 revoked")
+	}
+
+	_, err = RevokeUser(env, "unknown")
+
+	if err != ErrUnknownUser {
+		t.Fatalf("expected unknown user error, got %v", err)
+	}
+}
+
+// Session revocation waits for the mapping lock of its user
+func TestRevokeSessionLock(t *testing.T) {
+
+	env, brokerAdmin, clientIDs, closeEnv := newTestRevocationEnv(t)
+	defer closeEnv()
+
+	release, err := AcquireLock(env, "lock:mapping:user2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string, 1)
+
+	go func() {
+
+		clientID, err := RevokeSession(env, "user2.phone")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		done <- clientID
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("session revoked while mapping lock of its user was held")
+	case <-time.After(5 * LockRetryInterval):
+	}
+
+	release()
+
+	clientID := <-done
+
+	if clientID != clientIDs["user2.phone"] || !brokerAdmin.disconnectedClientIDs()[clientID] {
+		t.Fatalf("expected %s to be revoked & disconnected, got %s", clientIDs["user2.phone"], clientID)
+	}
+}
+
+// Users mapped before reverse mappings were maintained are revoked without lock
+func TestRevokeSessionWithoutReverseMapping(t *testing.T) {
+
+	env, brokerAdmin, clientIDs, closeEnv := newTestRevocationEnv(t)
+	defer closeEnv()
+
+	internalWaveUserID, err := CheckIfTokenIsCached(env, "user1.phone")
+
+	if err == nil {
+		err = env.Redis.Delete(reverseMappingKey(internalWaveUserID))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalUserID, err := GetOriginalUserID(env, internalWaveUserID)
+
+	if err != nil || originalUserID != "" {
+		t.Fatalf("expected unknown user, got %s : %v", originalUserID, err)
+	}
+
+	clientID, err := RevokeSession(env, "user1.phone")
+
+	if err != nil || clientID != clientIDs["user1.phone"] || !brokerAdmin.disconnectedClientIDs()[clientID] {
+		t.Fatalf("expected %s to be revoked & disconnected, got %s : %v", clientIDs["user1.phone"], clientID, err)
+	}
+
+	if cachedInternalWaveUserID, _ := CheckIfTokenIsCached(env, "user1.phone"); cachedInternalWaveUserID != "" {
+		t.Fatalf("session of user1.phone still cached")
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	errors "errors"
+	models "wave-messaging-management-service/models"
+)
+
+var (
+	// ErrEmptyServicePassword : Service own MQTT client has no password, its profile would accept any client sending an empty one
+	ErrEmptyServicePassword = errors.New("mqtt password must be set")
+)
+
+// EnsureServiceProfile : Create or update VerneMQ profile of the service own MQTT client so that the broker accepts
+// its credentials, publications & archiver subscriptions, whether it reads vmq_acl_auth itself or through the service webhooks.
+// Does nothing if no MQTT broker is configured, ErrEmptyServicePassword if the client has no password.
+func EnsureServiceProfile(env *models.Env) error {
+
+	config := env.Config().MQTT.WithDefaults()
+
+	if config.BrokerURL == "" {
+		return nil
+	}
+
+	if config.Password == "" {
+		return ErrEmptyServicePassword
+	}
+
+	// Each replica connects with its own client ID, hence its own profile
+	clientID := config.ReplicaClientID()
+
+	expectedProfile := models.NewServiceVerneMQACL(clientID, config.Username, "", "", config.Archive)
+
+	profile, profileErr := env.MongoDB.GetProfileACL(clientID)
+
+	// Profile is only rewritten if credentials or ACLs changed
+	if profileErr == nil && profile.Username == config.Username &&
+		CheckPasswordHash(env, config.Password, profile.Passhash, profile.HashAlgorithm) &&
+		samePatterns(profile.PublishACL, expectedProfile.PublishACL) &&
+		samePatterns(profile.SubscribeACL, expectedProfile.SubscribeACL) {
+		return nil
+	}
+
+	hasher, err := GetPasswordHasher(env)
+
+	if err != nil {
+		return err
+	}
+
+	passhash, err := hasher.Hash(config.Password)
+
+	if err != nil {
+		return err
+	}
+
+	expectedProfile.Passhash = passhash
+	expectedProfile.HashAlgorithm = hasher.Algorithm()
+
+	if profileErr != nil {
+		return env.MongoDB.AddProfileACL(expectedProfile)
+	}
+
+	return env.MongoDB.UpdateProfileACL(expectedProfile)
+}
+
+// samePatterns : Check if both ACL lists hold the same patterns in the same order
+func samePatterns(acls []*models.ACL, otherACLs []*models.ACL) bool {
+
+	if len(acls) != len(otherACLs) {
+		return false
+	}
+
+	for i := range acls {
+		if acls[i] == nil || otherACLs[i] == nil || acls[i].Pattern != otherACLs[i].Pattern {
+			return false
+		}
+	}
+
+	return true
+}
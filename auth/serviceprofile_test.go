@@ -0,0 +1,74 @@
+package auth
+
+import (
+	errors "errors"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+)
+
+// profilesMongoDB : VerneMQ profiles store
+type profilesMongoDB struct {
+	models.MongoDBInterface
+
+	profiles map[string]*models.VerneMQACL
+}
+
+func (mongoDB *profilesMongoDB) GetProfileACL(clientID string) (*models.VerneMQACL, error) {
+
+	profile, isStored := mongoDB.profiles[clientID]
+
+	if !isStored {
+		return nil, errors.New("mongo: no documents in result")
+	}
+
+	return profile, nil
+}
+
+func (mongoDB *profilesMongoDB) AddProfileACL(profile *models.VerneMQACL) error {
+
+	mongoDB.profiles[profile.ClientID] = profile
+
+	return nil
+}
+
+func (mongoDB *profilesMongoDB) UpdateProfileACL(profile *models.VerneMQACL) error {
+	return mongoDB.AddProfileACL(profile)
+}
+
+func TestEnsureServiceProfile(t *testing.T) {
+
+	mongoDB := &profilesMongoDB{profiles: map[string]*models.VerneMQACL{}}
+
+	env := &models.Env{MongoDB: mongoDB}
+
+	// Nothing to store without broker
+	env.SetConfig(&models.Config{BcryptCost: 4})
+
+	if err := EnsureServiceProfile(env); err != nil || len(mongoDB.profiles) != 0 {
+		t.Fatalf("expected no profile without broker, got %v : %v", mongoDB.profiles, err)
+	}
+
+	// Profile accepting an empty password is never stored
+	env.SetConfig(&models.Config{BcryptCost: 4, MQTT: models.MQTTConfig{BrokerURL: "tcp://localhost:1883", Archive: true}})
+
+	if err := EnsureServiceProfile(env); err != ErrEmptyServicePassword || len(mongoDB.profiles) != 0 {
+		t.Fatalf("expected ErrEmptyServicePassword, got %v : %v", mongoDB.profiles, err)
+	}
+
+	env.SetConfig(&models.Config{BcryptCost: 4, MQTT: models.MQTTConfig{BrokerURL: "tcp://localhost:1883", Password: "password", ReplicaID: "replica1", Archive: true}})
+
+	if err := EnsureServiceProfile(env); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each replica has its own profile, allowed to join the archiver shared subscriptions
+	profile := mongoDB.profiles["wave-management-service-replica1"]
+
+	if profile == nil || !CheckPasswordHash(env, "password", profile.Passhash, profile.HashAlgorithm) || CheckPasswordHash(env, "", profile.Passhash, profile.HashAlgorithm) {
+		t.Fatalf("unexpected service profile %+v", profile)
+	}
+
+	if profile.Username != "wave-management-service" || !profile.CanSubscribe("$share/wave-archiver/conversations/private/#") || !profile.CanSubscribe("conversations/group/#") {
+		t.Fatalf("unexpected service profile ACLs %+v", profile)
+	}
+}
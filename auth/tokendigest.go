@@ -0,0 +1,174 @@
+package auth
+
+import (
+	hmac "crypto/hmac"
+	sha256 "crypto/sha256"
+	hex "encoding/hex"
+	json "encoding/json"
+	fmt "fmt"
+	regexp "regexp"
+	strings "strings"
+	models "wave-messaging-management-service/models"
+)
+
+const (
+	// TokenDigestMigrationKey : Redis key set once raw tokens stored by earlier versions were replaced by their digests
+	TokenDigestMigrationKey = "migration:tokenDigests"
+)
+
+var (
+	// tokenDigestRegex : Format of token digests returned by DigestToken
+	tokenDigestRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// DigestToken : Return keyed hash (hex encoded HMAC-SHA256 with configured secret) of a token.
+// Tokens are only stored in Redis under this form so that Redis content can't be used to impersonate users.
+func DigestToken(env *models.Env, token string) string {
+
+	mac := hmac.New(sha256.New, []byte(env.Config().TokenDigestSecret))
+	mac.Write([]byte(token))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MigrateTokenDigests : Replace raw tokens stored in Redis by earlier versions with their digests.
+// Can safely be run again after a failure or once upgraded instances started : keys already named after a digest are left untouched.
+// Raw tokens having the format of a digest (64 lowercase hex characters) can't be told apart and are not migrated.
+// Returns the number of migrated keys.
+func MigrateTokenDigests(env *models.Env) (int, error) {
+
+	wasMigrated, err := env.Redis.Exists(TokenDigestMigrationKey)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if wasMigrated {
+		return 0, nil
+	}
+
+	migrated := 0
+
+	// session:{token} -> session:{tokenDigest} (TTL is kept)
+	sessionKeys, err := env.Redis.GetKeys("session:*")
+
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, sessionKey := range sessionKeys {
+
+		if isTokenDigest(strings.TrimPrefix(sessionKey, "session:")) {
+			continue
+		}
+
+		err = env.Redis.Rename(sessionKey, fmt.Sprintf("session:%s", DigestToken(env, strings.TrimPrefix(sessionKey, "session:"))))
+
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	// Revalidation markers are dropped : sessions are simply verified again on their next use
+	revalidationKeys, err := env.Redis.GetKeys("revalidation:*")
+
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, revalidationKey := range revalidationKeys {
+
+		if isTokenDigest(strings.TrimPrefix(revalidationKey, "revalidation:")) {
+			continue
+		}
+
+		err = env.Redis.Delete(revalidationKey)
+
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	// mapping:{originalUserID} token {token} -> mapping:{originalUserID} tokenDigest {tokenDigest}
+	mappingKeys, err := env.Redis.GetKeys("mapping:*")
+
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, mappingKey := range mappingKeys {
+
+		token, _ := env.Redis.HGet(mappingKey, "token")
+
+		if len(token) == 0 {
+			continue
+		}
+
+		err = env.Redis.Transaction(
+			models.NewRedisCommand("HSET", mappingKey, "tokenDigest", DigestToken(env, string(token))),
+			models.NewRedisCommand("HDEL", mappingKey, "token"),
+		)
+
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	// sessions:{internalWaveUserID} {deviceID} {"token": ...} -> sessions:{internalWaveUserID} {deviceID} {"tokenDigest": ...}
+	sessionsKeys, err := env.Redis.GetKeys("sessions:*")
+
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, sessionsKey := range sessionsKeys {
+
+		values, err := env.Redis.HGetAll(sessionsKey)
+
+		if err != nil {
+			return migrated, err
+		}
+
+		for deviceID, value := range values {
+
+			legacyDeviceSession := struct {
+				models.DeviceSession
+				Token string `json:"token"`
+			}{}
+
+			if json.Unmarshal(value, &legacyDeviceSession) != nil || legacyDeviceSession.Token == "" {
+				continue
+			}
+
+			deviceSession := legacyDeviceSession.DeviceSession
+			deviceSession.TokenDigest = DigestToken(env, legacyDeviceSession.Token)
+
+			migratedValue, err := json.Marshal(deviceSession)
+
+			if err != nil {
+				return migrated, err
+			}
+
+			_, err = env.Redis.HSetIfEquals(sessionsKey, deviceID, value, migratedValue)
+
+			if err != nil {
+				return migrated, err
+			}
+
+			migrated++
+		}
+	}
+
+	return migrated, env.Redis.Set(TokenDigestMigrationKey, []byte("1"))
+}
+
+// isTokenDigest : Check if a Redis key suffix has the format of a token digest rather than a raw token
+func isTokenDigest(value string) bool {
+	return tokenDigestRegex.MatchString(value)
+}
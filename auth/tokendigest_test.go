@@ -0,0 +1,99 @@
+package auth
+
+import (
+	json "encoding/json"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+)
+
+func TestMigrateTokenDigestsIsIdempotent(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, "")
+	defer closeEnv()
+
+	env.SetConfig(&models.Config{TokenDigestSecret: "secret"})
+
+	rawTokenDigest := DigestToken(env, "rawtoken")
+	upgradedTokenDigest := DigestToken(env, "upgradedtoken")
+
+	legacyDeviceSession, err := json.Marshal(map[string]interface{}{"deviceID": "phone", "token": "rawtoken", "createdAt": 5, "lastSeenAt": 6})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Raw tokens written by earlier versions, next to a session already written by an upgraded instance
+	for key, value := range map[string]string{
+		"session:rawtoken":                    "internal1",
+		"revalidation:rawtoken":               "1",
+		"session:" + upgradedTokenDigest:      "internal2",
+		"revalidation:" + upgradedTokenDigest: "1",
+	} {
+		if err := env.Redis.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = env.Redis.HSet("mapping:user1", "token", []byte("rawtoken"), "internalWaveUserID", []byte("internal1"))
+
+	if err == nil {
+		err = env.Redis.HSet("sessions:internal1", "phone", legacyDeviceSession, "laptop", legacyDeviceSession)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := MigrateTokenDigests(env)
+
+	if err != nil || migrated != 5 {
+		t.Fatalf("expected 5 migrated keys, got %d : %v", migrated, err)
+	}
+
+	// Interrupted migrations are run again from scratch
+	err = env.Redis.Delete(TokenDigestMigrationKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err = MigrateTokenDigests(env)
+
+	if err != nil || migrated != 0 {
+		t.Fatalf("expected nothing to migrate again, got %d : %v", migrated, err)
+	}
+
+	for token, internalWaveUserID := range map[string]string{"rawtoken": "internal1", "upgradedtoken": "internal2"} {
+
+		cachedInternalWaveUserID, err := CheckIfTokenIsCached(env, token)
+
+		if err != nil || cachedInternalWaveUserID != internalWaveUserID {
+			t.Errorf("session of %s lost : %s, %v", token, cachedInternalWaveUserID, err)
+		}
+	}
+
+	for key, shouldExist := range map[string]bool{
+		"session:rawtoken":                    false,
+		"revalidation:rawtoken":               false,
+		"revalidation:" + upgradedTokenDigest: true,
+	} {
+
+		exists, err := env.Redis.Exists(key)
+
+		if err != nil || exists != shouldExist {
+			t.Errorf("%s exists : %v, expected %v", key, exists, shouldExist)
+		}
+	}
+
+	mappingTokenDigest, _ := env.Redis.HGet("mapping:user1", "tokenDigest")
+
+	if string(mappingTokenDigest) != rawTokenDigest {
+		t.Errorf("mapping token digest %s, expected %s", mappingTokenDigest, rawTokenDigest)
+	}
+
+	deviceSessions, err := GetDeviceSessions(env, "internal1")
+
+	if err != nil || len(deviceSessions) != 2 || deviceSessions["phone"].TokenDigest != rawTokenDigest || deviceSessions["phone"].CreatedAt != 5 {
+		t.Fatalf("unexpected device sessions %v : %v", deviceSessions, err)
+	}
+}
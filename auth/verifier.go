@@ -0,0 +1,212 @@
+package auth
+
+import (
+	json "encoding/json"
+	errors "errors"
+	fmt "fmt"
+	io "io"
+	http "net/http"
+	strconv "strconv"
+	strings "strings"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// AuthenticationModeExternal : Tokens are verified by calling the external authentication endpoint
+	AuthenticationModeExternal = "external"
+
+	// AuthenticationModeJWT : Tokens are verified locally as JSON Web Tokens
+	AuthenticationModeJWT = "jwt"
+
+	// AuthenticationModeIntrospection : Tokens are verified with an OAuth2 token introspection endpoint
+	AuthenticationModeIntrospection = "introspection"
+)
+
+// TokenVerifier : Token verification backend, returns the identity owning a valid token.
+// Implementations must return a logruswrapper.CodeInvalidToken error when the token is rejected,
+// any other error meaning the token could not be verified.
+type TokenVerifier interface {
+	Verify(env *models.Env, token string) (*Identity, error)
+}
+
+// Identity : Application user identity a token belongs to, and the Unix date the token expires at (0 if unknown)
+type Identity struct {
+	OriginalUserID string
+	DisplayName    string
+	Roles          []string
+	ExpiresAt      int64
+}
+
+// ExternalEndpointVerifier : Verify tokens with provided external auth endpoint
+type ExternalEndpointVerifier struct{}
+
+// GetTokenVerifier : Return token verifier selected by config authentication mode
+func GetTokenVerifier(env *models.Env) (TokenVerifier, error) {
+
+	switch env.Config().AuthenticationMode {
+
+	case "", AuthenticationModeExternal:
+		return &ExternalEndpointVerifier{}, nil
+
+	case AuthenticationModeJWT:
+		return &JWTVerifier{}, nil
+
+	case AuthenticationModeIntrospection:
+		return &IntrospectionVerifier{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown authentication mode %s", env.Config().AuthenticationMode)
+}
+
+// Verify : Ask provided external auth endpoint for the identity owning token.
+// Request & response format are described by config, defaulting to the contract below.
+func (verifier *ExternalEndpointVerifier) Verify(env *models.Env, token string) (*Identity, error) {
+
+	config := env.Config()
+	contract := config.ExternalAuthentication.WithDefaults()
+
+	// Execute request through shared authentication HTTP client, token placeholder is replaced in header & body
+	res, err := GetAuthHTTPClient(env).Do(func() (*http.Request, error) {
+
+		var body io.Reader
+
+		if contract.BodyTemplate != "" {
+			body = strings.NewReader(strings.Replace(contract.BodyTemplate, models.TokenPlaceholder, token, -1))
+		}
+
+		req, err := http.NewRequest(contract.Method, config.AuthenticationCheckEndpoint, body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if contract.ContentType != "" {
+			req.Header.Set("Content-Type", contract.ContentType)
+		}
+
+		// Add token header
+		req.Header.Add(contract.HeaderName, strings.Replace(contract.HeaderTemplate, models.TokenPlaceholder, token, -1))
+
+		return req, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	//=============================================================================
+	// By default, authentication endpoint should return the following if token is invalid :
+	//
+	// HTTP Status Code : 400 (Bad Request)
+	// Empty Body
+	//=============================================================================
+	// By default, authentication endpoint should return the following if token is valid :
+	//
+	// HTTP Status Code : 200 (OK)
+	// Header(s) : content-type:application/json
+	// {"userID": "userID"}
+	//=============================================================================
+	if !isStatusCodeAccepted(contract.AcceptedStatusCodes, res.StatusCode) {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	// Parse response to get Original User ID
+	var authCheckerBody interface{}
+
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+
+	err = decoder.Decode(&authCheckerBody)
+
+	if err != nil {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	userID, _ := lookupJSONPath(authCheckerBody, contract.UserIDPath)
+	originalUserID := claimToString(userID)
+
+	if originalUserID == "" {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	identity := &Identity{OriginalUserID: originalUserID}
+
+	if contract.DisplayNamePath != "" {
+		displayName, _ := lookupJSONPath(authCheckerBody, contract.DisplayNamePath)
+		identity.DisplayName = claimToString(displayName)
+	}
+
+	if contract.RolesPath != "" {
+
+		roles, _ := lookupJSONPath(authCheckerBody, contract.RolesPath)
+
+		switch roles := roles.(type) {
+
+		case string:
+			identity.Roles = []string{roles}
+
+		case []interface{}:
+			for _, role := range roles {
+				if role := claimToString(role); role != "" {
+					identity.Roles = append(identity.Roles, role)
+				}
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// isStatusCodeAccepted : Check if status code is part of accepted status codes
+func isStatusCodeAccepted(acceptedStatusCodes []int, statusCode int) bool {
+
+	for _, accepted := range acceptedStatusCodes {
+		if accepted == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupJSONPath : Get value at dot-separated path (e.g. "data.user.id") in a decoded JSON document.
+// Numeric path segments index arrays.
+func lookupJSONPath(document interface{}, path string) (interface{}, bool) {
+
+	current := document
+
+	for _, segment := range strings.Split(path, ".") {
+
+		switch node := current.(type) {
+
+		case map[string]interface{}:
+
+			value, exists := node[segment]
+
+			if !exists {
+				return nil, false
+			}
+
+			current = value
+
+		case []interface{}:
+
+			index, err := strconv.Atoi(segment)
+
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+
+			current = node[index]
+
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
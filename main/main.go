@@ -1,9 +1,12 @@
 package main
 
 import (
+	flag "flag"
 	fmt "fmt"
 	log "log"
 	os "os"
+	archiver "wave-messaging-management-service/archiver"
+	auth "wave-messaging-management-service/auth"
 	models "wave-messaging-management-service/models"
 	router "wave-messaging-management-service/router"
 )
@@ -45,6 +48,14 @@ var (
 
 func main() {
 
+	repairMappings := flag.Bool("repair-mappings", false, "Repair partially rotated users then exit")
+	migrateTokenDigests := flag.Bool("migrate-token-digests", false, "Replace raw tokens stored in Redis with their digests then exit")
+	backfillReverseMappings := flag.Bool("backfill-reverse-mappings", false, "Store reverse mappings of existing mappings then exit")
+	backfillGroupOwners := flag.Bool("backfill-group-owners", false, "Set owner of existing group conversations to their creator then exit")
+	purgeMessages := flag.Bool("purge-messages", false, "Delete archived messages older than their retention then exit")
+	dryRun := flag.Bool("dry-run", false, "Only count messages -purge-messages would delete")
+	flag.Parse()
+
 	if os.Getenv("WAVE_CONFIG_FILE_PATH") == "" {
 		log.Fatalf("WAVE_CONFIG_FILE_PATH Environment variable must be set !")
 	}
@@ -53,17 +64,17 @@ func main() {
 	// If an error occurs, program is set to panic
 	mongoDB := models.NewMongoDB(MongoDBURL)
 
-	// Get Redis communication interface
-	// If an error occurs, program is set to panic
-	redis := models.NewRedis(RedisURL, RedisPassword)
-
-	// Add interfaces & blank config to the environment
+	// Add interfaces to the environment, config is loaded below
 	env := &models.Env{
 		MongoDB: mongoDB,
-		Redis:   redis,
-		Config:  models.Config{},
 	}
 
+	// Revoked MQTT clients are disconnected through VerneMQ HTTP API if configured
+	env.BrokerAdmin = models.NewVerneMQAdmin(env)
+
+	// System messages are published by the service own MQTT client if configured
+	env.MQTT = models.NewMQTTClient(env)
+
 	// Dynamically load config
 	err := env.RefreshConfig()
 
@@ -71,9 +82,128 @@ func main() {
 		log.Fatalf(err.Error())
 	}
 
+	// Get Redis communication interface, its connection pool is sized by config at startup
+	// If an error occurs, program is set to panic
+	env.Redis = models.NewRedis(RedisURL, RedisPassword, env.Config().RedisPool.WithDefaults())
+
+	// Tokens would otherwise be stored in Redis under an unkeyed digest
+	if env.Config().TokenDigestSecret == "" {
+		log.Fatalf("tokenDigestSecret must be set !")
+	}
+
+	// Profile of the service own MQTT client would otherwise accept anyone sending an empty password
+	if env.Config().MQTT.BrokerURL != "" && env.Config().MQTT.Password == "" {
+		log.Fatalf("mqtt password must be set !")
+	}
+
+	// One-off reconciliation of partially rotated users
+	if *repairMappings {
+
+		repairedMappings, err := auth.RepairMappings(env)
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		repairedProfiles, err := auth.ReconcilePendingPasshashes(env)
+
+		log.Printf("Repaired %d mapping(s) and %d profile passhash(es)", repairedMappings, repairedProfiles)
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		env.Redis.CloseConnection()
+		return
+	}
+
+	// One-off migration of raw tokens stored by earlier versions
+	if *migrateTokenDigests {
+
+		migratedKeys, err := auth.MigrateTokenDigests(env)
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		log.Printf("Migrated %d key(s)", migratedKeys)
+
+		env.Redis.CloseConnection()
+		return
+	}
+
+	// One-off backfill of reverse mappings of users mapped by earlier versions
+	if *backfillReverseMappings {
+
+		backfilledMappings, err := auth.BackfillReverseMappings(env)
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		log.Printf("Backfilled %d reverse mapping(s)", backfilledMappings)
+
+		env.Redis.CloseConnection()
+		return
+	}
+
+	// One-off backfill of owners of group conversations created by earlier versions
+	if *backfillGroupOwners {
+
+		backfilledGroups, err := env.MongoDB.BackfillGroupConversationOwners()
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		log.Printf("Backfilled owner of %d group conversation(s)", backfilledGroups)
+
+		env.Redis.CloseConnection()
+		return
+	}
+
+	// One-off purge of expired archived messages
+	if *purgeMessages {
+
+		report, err := archiver.PurgeExpiredMessages(env, *dryRun)
+
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		log.Println(report)
+
+		env.Redis.CloseConnection()
+		return
+	}
+
+	// Indexes are only created if missing, a failure does not prevent serving requests
+	err = env.MongoDB.EnsureIndexes()
+
+	if err != nil {
+		log.Printf("Failed to create MongoDB indexes : %v", err)
+	}
+
+	// Broker must accept credentials of the service own MQTT client
+	err = auth.EnsureServiceProfile(env)
+
+	if err != nil {
+		log.Printf("Failed to store VerneMQ profile of the service MQTT client : %v", err)
+	}
+
+	// Archive messages of all conversations if enabled, once the broker accepts the archiver subscriptions
+	go archiver.Start(env)
+
+	// Periodically delete archived messages older than their retention
+	go archiver.StartPurge(env)
+
+	// Periodically repair profiles left behind by failed token rotations
+	go auth.StartReconciliation(env)
+
 	router.Listen(env)
 
 	defer func() {
 		env.Redis.CloseConnection()
+		env.MQTT.Disconnect()
 	}()
 }
@@ -0,0 +1,60 @@
+package models
+
+import (
+	fmt "fmt"
+	http "net/http"
+	url "net/url"
+	time "time"
+)
+
+// BrokerAdminInterface : MQTT broker administration interface
+type BrokerAdminInterface interface {
+	DisconnectClient(clientID string) error
+}
+
+// VerneMQAdmin : VerneMQ administration through its HTTP API, enabled by brokerAdmin config
+type VerneMQAdmin struct {
+	Env    *Env
+	Client *http.Client
+}
+
+// NewVerneMQAdmin : Return a new VerneMQ administration interface reading its settings from environment config
+func NewVerneMQAdmin(env *Env) *VerneMQAdmin {
+
+	return &VerneMQAdmin{
+		Env:    env,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// DisconnectClient : Disconnect live MQTT client session, does nothing if no VerneMQ HTTP API is configured
+func (admin *VerneMQAdmin) DisconnectClient(clientID string) error {
+
+	config := admin.Env.Config().BrokerAdmin
+
+	if config.APIURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", config.APIURL+"/api/v1/session/disconnect?client-id="+url.QueryEscape(clientID), nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(config.APIKey, "")
+
+	res, err := admin.Client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("error disconnecting MQTT client %s : status %d", clientID, res.StatusCode)
+	}
+
+	return nil
+}
@@ -1,22 +1,202 @@
 package models
 
 import (
+	time "time"
+
 	uuid "github.com/satori/go.uuid"
 )
 
+const (
+	// GroupRoleOwner : Role of the group member owning the group, the creator unless ownership was transferred
+	GroupRoleOwner = "owner"
+
+	// GroupRoleAdmin : Role of group members allowed to manage the group on behalf of its owner
+	GroupRoleAdmin = "admin"
+
+	// GroupRoleMember : Role of other group members
+	GroupRoleMember = "member"
+
+	// GroupEventMemberJoined : Type of the system message published when a user joins a group with an invite code
+	GroupEventMemberJoined = "memberJoined"
+
+	// GroupEventMemberLeft : Type of the system message published when a member leaves a group
+	GroupEventMemberLeft = "memberLeft"
+
+	// GroupEventDeleted : Type of the system message published when a group is deleted
+	GroupEventDeleted = "groupDeleted"
+
+	// GroupEventMetadataChanged : Type of the system message published when name, description, avatar, attributes or retention of a group change
+	GroupEventMetadataChanged = "metadataChanged"
+)
+
 // GroupConversation : Group conversation struct
 type GroupConversation struct {
-	GroupConversationID string   `json:"GroupConversationID" bson:"groupConversationID"`
-	Name                string   `json:"name" bson:"name"`
-	Members             []string `json:"members" bson:"members"`
-	// TODO: Add message backup support
+	GroupConversationID string            `json:"GroupConversationID" bson:"groupConversationID"`
+	Name                string            `json:"name" bson:"name"`
+	Members             []string          `json:"members" bson:"members"`
+	Owner               string            `json:"owner" bson:"owner"`
+	Admins              []string          `json:"admins" bson:"admins"`
+	Description         string            `json:"description" bson:"description"`
+	AvatarURL           string            `json:"avatarURL" bson:"avatarURL"`
+	Attributes          map[string]string `json:"attributes" bson:"attributes"`
+	Creator             string            `json:"creator" bson:"creator"`
+	CreatedAt           int64             `json:"createdAt" bson:"createdAt"`
+	UpdatedAt           int64             `json:"updatedAt" bson:"updatedAt"`
+
+	// RetentionDays : Days after which archived messages of the group are purged, 0 to apply the global retention
+	RetentionDays int `json:"retentionDays" bson:"retentionDays"`
+}
+
+// GroupMetadata : Editable metadata of a group conversation
+type GroupMetadata struct {
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	AvatarURL     string            `json:"avatarURL"`
+	Attributes    map[string]string `json:"attributes"`
+	RetentionDays int               `json:"retentionDays"`
 }
 
-// NewGroupConversation : Return new VerneMQACL struct pointer
-func NewGroupConversation(name string, members []string) *GroupConversation {
+// GroupMember : Member of a group conversation as returned to other members
+type GroupMember struct {
+	Mapping
+	Role string `json:"role"`
+}
+
+// GroupConversationDetails : Group conversation as returned to one of its members
+type GroupConversationDetails struct {
+	GroupConversationID string            `json:"groupConversationID"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	AvatarURL           string            `json:"avatarURL"`
+	Attributes          map[string]string `json:"attributes"`
+	Creator             Mapping           `json:"creator"`
+	CreatedAt           int64             `json:"createdAt"`
+	UpdatedAt           int64             `json:"updatedAt"`
+	RetentionDays       int               `json:"retentionDays"`
+	Members             []GroupMember     `json:"members"`
+	PublishTopic        string            `json:"publishTopic"`
+	SubscribeTopic      string            `json:"subscribeTopic"`
+}
+
+// GroupConversationsPage : Page of group conversations, NextCursor is empty on the last page
+type GroupConversationsPage struct {
+	GroupConversations []*GroupConversationDetails `json:"groupConversations"`
+	NextCursor         string                      `json:"nextCursor"`
+}
+
+// GroupSystemMessage : Message published by the service on the system topic of a group conversation
+type GroupSystemMessage struct {
+	Type                string         `json:"type"`
+	GroupConversationID string         `json:"groupConversationID"`
+	InternalWaveUserID  string         `json:"internalWaveUserID,omitempty"`
+	Text                string         `json:"text,omitempty"`
+	Metadata            *GroupMetadata `json:"metadata,omitempty"`
+	SentAt              int64          `json:"sentAt"`
+}
+
+// NewGroupConversation : Return new GroupConversation struct pointer owned by its creator (internal Wave user ID)
+func NewGroupConversation(name string, members []string, creator string) *GroupConversation {
+
+	now := time.Now().Unix()
+
 	return &GroupConversation{
 		GroupConversationID: uuid.NewV4().String(),
 		Name:                name,
 		Members:             members,
+		Owner:               creator,
+		Admins:              []string{},
+		Attributes:          map[string]string{},
+		Creator:             creator,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+}
+
+// Metadata : Return editable metadata of the group conversation
+func (groupConversation *GroupConversation) Metadata() *GroupMetadata {
+	return &GroupMetadata{
+		Name:          groupConversation.Name,
+		Description:   groupConversation.Description,
+		AvatarURL:     groupConversation.AvatarURL,
+		Attributes:    groupConversation.Attributes,
+		RetentionDays: groupConversation.RetentionDays,
+	}
+}
+
+// EffectiveRetentionDays : Return days after which archived messages of the group are purged (0 meaning never).
+// The retention of a group may only be shorter than the global retention.
+func (groupConversation *GroupConversation) EffectiveRetentionDays(globalRetentionDays int) int {
+
+	if groupConversation.RetentionDays > 0 && (globalRetentionDays <= 0 || groupConversation.RetentionDays < globalRetentionDays) {
+		return groupConversation.RetentionDays
 	}
+
+	return globalRetentionDays
+}
+
+// IsMember : Check if a user (internal Wave user ID) is a member of the group conversation
+func (groupConversation *GroupConversation) IsMember(internalWaveUserID string) bool {
+
+	for _, member := range groupConversation.Members {
+		if member == internalWaveUserID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Role : Return role of a member (internal Wave user ID) in the group conversation
+func (groupConversation *GroupConversation) Role(internalWaveUserID string) string {
+
+	if internalWaveUserID == groupConversation.Owner {
+		return GroupRoleOwner
+	}
+
+	for _, admin := range groupConversation.Admins {
+		if admin == internalWaveUserID {
+			return GroupRoleAdmin
+		}
+	}
+
+	return GroupRoleMember
+}
+
+// CanManage : Check if a member (internal Wave user ID) may rename the group and manage its members, i.e. is its owner or one of its admins
+func (groupConversation *GroupConversation) CanManage(internalWaveUserID string) bool {
+	return groupConversation.IsMember(internalWaveUserID) && groupConversation.Role(internalWaveUserID) != GroupRoleMember
+}
+
+// NextOwner : Return member the group should be handed over to when its owner leaves :
+// its first admin, its first other member if it has no admin, an empty string if the owner was the last member
+func (groupConversation *GroupConversation) NextOwner() string {
+
+	for _, admin := range groupConversation.Admins {
+		if admin != groupConversation.Owner && groupConversation.IsMember(admin) {
+			return admin
+		}
+	}
+
+	for _, member := range groupConversation.Members {
+		if member != groupConversation.Owner {
+			return member
+		}
+	}
+
+	return ""
+}
+
+// PublishTopic : Return topic a member publishes its messages on
+func (groupConversation *GroupConversation) PublishTopic(internalWaveUserID string) string {
+	return GroupConversationTopicPath + groupConversation.GroupConversationID + "/" + internalWaveUserID
+}
+
+// SubscribeTopic : Return topic filter matching messages of all members
+func (groupConversation *GroupConversation) SubscribeTopic() string {
+	return GroupConversationTopicPath + groupConversation.GroupConversationID + "/+"
+}
+
+// SystemTopic : Return topic the service publishes system messages of the group conversation on
+func (groupConversation *GroupConversation) SystemTopic() string {
+	return GroupConversationTopicPath + groupConversation.GroupConversationID + "/" + GroupSystemTopicLevel
 }
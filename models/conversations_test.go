@@ -0,0 +1,32 @@
+package models
+
+import (
+	testing "testing"
+)
+
+func TestEffectiveRetentionDays(t *testing.T) {
+
+	for _, test := range []struct {
+		groupRetentionDays  int
+		globalRetentionDays int
+		expected            int
+	}{
+		// No retention at all
+		{0, 0, 0},
+		// Global retention applies to groups without their own
+		{0, 30, 30},
+		// Group retention applies without global retention
+		{5, 0, 5},
+		// Shortest retention applies
+		{5, 30, 5},
+		{50, 30, 30},
+		{30, 30, 30},
+	} {
+
+		groupConversation := &GroupConversation{RetentionDays: test.groupRetentionDays}
+
+		if retentionDays := groupConversation.EffectiveRetentionDays(test.globalRetentionDays); retentionDays != test.expected {
+			t.Errorf("group retention %d, global retention %d : expected %d days, got %d", test.groupRetentionDays, test.globalRetentionDays, test.expected, retentionDays)
+		}
+	}
+}
@@ -3,40 +3,299 @@ package models
 import (
 	json "encoding/json"
 	ioutil "io/ioutil"
+	log "log"
 	os "os"
+	sync "sync"
 )
 
-var (
-	configFilePath = os.Getenv("WAVE_CONFIG_FILE_PATH")
+const (
+	// TokenPlaceholder : Placeholder replaced by the token in external authentication header & body templates
+	TokenPlaceholder = "{token}"
 )
 
 // Env : Execution environment containing Datastore communication interfaces (Redis, MongoDB) & Config
 type Env struct {
-	MongoDB MongoDBInterface
-	Redis   RedisInterface
-	Config  Config
+	MongoDB     MongoDBInterface
+	Redis       RedisInterface
+	BrokerAdmin BrokerAdminInterface
+	MQTT        MQTTClientInterface
+
+	// config : Last loaded config, replaced as a whole on each refresh so that readers never see a partially loaded one
+	config      *Config
+	configMutex sync.RWMutex
 }
 
 // Config : Global Config
 type Config struct {
-	AuthenticationCheckEndpoint string `json:"authenticationCheckEndpoint"`
-	TokenValidationRegex        string `json:"tokenValidationRegex"`
+	AuthenticationCheckEndpoint string                       `json:"authenticationCheckEndpoint"`
+	TokenValidationRegex        string                       `json:"tokenValidationRegex"`
+	BcryptCost                  int                          `json:"bcryptCost"`
+	SessionTTL                  int                          `json:"sessionTTL"`
+	SessionSlidingTTL           bool                         `json:"sessionSlidingTTL"`
+	SessionRevalidationInterval int                          `json:"sessionRevalidationInterval"`
+	AuthenticationMode          string                       `json:"authenticationMode"`
+	JWT                         JWTConfig                    `json:"jwt"`
+	Introspection               IntrospectionConfig          `json:"introspection"`
+	ExternalAuthentication      ExternalAuthenticationConfig `json:"externalAuthentication"`
+	AuthHTTPClient              AuthHTTPClientConfig         `json:"authHTTPClient"`
+	ReconciliationInterval      int                          `json:"reconciliationInterval"`
+	MaxDevicesPerUser           int                          `json:"maxDevicesPerUser"`
+	AdminAPIKey                 string                       `json:"adminAPIKey"`
+	WebhookSecret               string                       `json:"webhookSecret"`
+	BrokerAdmin                 BrokerAdminConfig            `json:"brokerAdmin"`
+	TokenDigestSecret           string                       `json:"tokenDigestSecret"`
+	PasswordHashAlgorithm       string                       `json:"passwordHashAlgorithm"`
+	Argon2id                    Argon2idConfig               `json:"argon2id"`
+	MaxMappingBatchSize         int                          `json:"maxMappingBatchSize"`
+	MQTT                        MQTTConfig                   `json:"mqtt"`
+	MessageRetention            MessageRetentionConfig       `json:"messageRetention"`
+	RedisPool                   RedisPoolConfig              `json:"redisPool"`
+}
+
+// Argon2idConfig : Argon2id passhash parameters
+type Argon2idConfig struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// MQTTConfig : Broker connection & credentials of the service own MQTT client
+type MQTTConfig struct {
+	BrokerURL string `json:"brokerURL"`
+	ClientID  string `json:"clientID"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+
+	// ReplicaID : Suffix of the client ID of this replica, so that replicas don't take over each other connection
+	ReplicaID string `json:"replicaID"`
+
+	// Archive : Subscribe to conversation topics and archive their messages in MongoDB
+	Archive bool `json:"archive"`
+}
+
+// WithDefaults : Return a copy of the settings where unset client ID & username are replaced by "wave-management-service"
+// and unset replica ID by the hostname
+func (config MQTTConfig) WithDefaults() MQTTConfig {
+
+	if config.ClientID == "" {
+		config.ClientID = "wave-management-service"
+	}
+
+	if config.Username == "" {
+		config.Username = config.ClientID
+	}
+
+	if config.ReplicaID == "" {
+		config.ReplicaID, _ = os.Hostname()
+	}
+
+	return config
+}
+
+// ReplicaClientID : Return client ID of the service own MQTT client of this replica, {clientID}-{replicaID}
+func (config MQTTConfig) ReplicaClientID() string {
+
+	if config.ReplicaID == "" {
+		return config.ClientID
+	}
+
+	return config.ClientID + "-" + config.ReplicaID
+}
+
+// MessageRetentionConfig : Retention of archived messages, enforced by a periodic purge
+type MessageRetentionConfig struct {
+	// Days : Days after which archived messages are purged (0 keeps them forever), groups may only shorten it
+	Days int `json:"days"`
+
+	// PurgeInterval : Seconds between two purges
+	PurgeInterval int `json:"purgeInterval"`
+
+	// DryRun : Only count messages the purge would delete
+	DryRun bool `json:"dryRun"`
+}
+
+// BrokerAdminConfig : VerneMQ HTTP API settings used to disconnect revoked clients
+type BrokerAdminConfig struct {
+	APIURL string `json:"apiURL"`
+	APIKey string `json:"apiKey"`
+}
+
+// AuthHTTPClientConfig : Timeouts, retries & circuit breaker settings of the HTTP client calling authentication backends
+type AuthHTTPClientConfig struct {
+	ConnectTimeout          int `json:"connectTimeout"`
+	ReadTimeout             int `json:"readTimeout"`
+	MaxRetries              int `json:"maxRetries"`
+	RetryBackoff            int `json:"retryBackoff"`
+	BreakerFailureThreshold int `json:"breakerFailureThreshold"`
+	BreakerOpenDuration     int `json:"breakerOpenDuration"`
+}
+
+// ExternalAuthenticationConfig : Request & response contract of the external authentication endpoint
+type ExternalAuthenticationConfig struct {
+	Method              string `json:"method"`
+	HeaderName          string `json:"headerName"`
+	HeaderTemplate      string `json:"headerTemplate"`
+	BodyTemplate        string `json:"bodyTemplate"`
+	ContentType         string `json:"contentType"`
+	AcceptedStatusCodes []int  `json:"acceptedStatusCodes"`
+	UserIDPath          string `json:"userIDPath"`
+	DisplayNamePath     string `json:"displayNamePath"`
+	RolesPath           string `json:"rolesPath"`
+}
+
+// JWTConfig : Local JSON Web Token verification config
+type JWTConfig struct {
+	Algorithms          []string      `json:"algorithms"`
+	JWKS                JSONWebKeySet `json:"jwks"`
+	JWKSURL             string        `json:"jwksURL"`
+	JWKSRefreshInterval int           `json:"jwksRefreshInterval"`
+	UserIDClaim         string        `json:"userIDClaim"`
+	Issuer              string        `json:"issuer"`
+	Audience            string        `json:"audience"`
+	Leeway              int           `json:"leeway"`
 }
 
-// RefreshConfig : Load current environment values in config
+// IntrospectionConfig : OAuth2 token introspection (RFC 7662) config
+type IntrospectionConfig struct {
+	Endpoint       string   `json:"endpoint"`
+	ClientID       string   `json:"clientID"`
+	ClientSecret   string   `json:"clientSecret"`
+	TokenTypeHint  string   `json:"tokenTypeHint"`
+	RequiredScopes []string `json:"requiredScopes"`
+}
+
+// WithDefaults : Return a copy of the contract where unset fields are replaced by the default contract :
+// GET request with a "token" header holding the raw token, 200 status code and {"userID": "..."} response
+func (contract ExternalAuthenticationConfig) WithDefaults() ExternalAuthenticationConfig {
+
+	if contract.Method == "" {
+		contract.Method = "GET"
+	}
+
+	if contract.HeaderName == "" {
+		contract.HeaderName = "token"
+	}
+
+	if contract.HeaderTemplate == "" {
+		contract.HeaderTemplate = TokenPlaceholder
+	}
+
+	if len(contract.AcceptedStatusCodes) == 0 {
+		contract.AcceptedStatusCodes = []int{200}
+	}
+
+	if contract.UserIDPath == "" {
+		contract.UserIDPath = "userID"
+	}
+
+	return contract
+}
+
+// WithDefaults : Return a copy of the settings where unset fields are replaced by default values.
+// Timeouts & backoff are expressed in milliseconds, breaker open duration in seconds, negative retries disable retrying.
+func (config AuthHTTPClientConfig) WithDefaults() AuthHTTPClientConfig {
+
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = 2000
+	}
+
+	if config.ReadTimeout <= 0 {
+		config.ReadTimeout = 5000
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 2
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100
+	}
+
+	if config.BreakerFailureThreshold <= 0 {
+		config.BreakerFailureThreshold = 5
+	}
+
+	if config.BreakerOpenDuration <= 0 {
+		config.BreakerOpenDuration = 30
+	}
+
+	return config
+}
+
+// WithDefaults : Return a copy of the parameters where unset fields are replaced by default values :
+// 2 passes over 19 MiB (memory is expressed in KiB) with 1 thread
+func (config Argon2idConfig) WithDefaults() Argon2idConfig {
+
+	if config.Time == 0 {
+		config.Time = 2
+	}
+
+	if config.Memory == 0 {
+		config.Memory = 19456
+	}
+
+	if config.Threads == 0 {
+		config.Threads = 1
+	}
+
+	return config
+}
+
+// Config : Return last loaded config, blank if none was loaded yet.
+// Returned config is shared and must not be modified.
+func (env *Env) Config() *Config {
+
+	env.configMutex.RLock()
+	defer env.configMutex.RUnlock()
+
+	if env.config == nil {
+		return &Config{}
+	}
+
+	return env.config
+}
+
+// SetConfig : Replace config as a whole
+func (env *Env) SetConfig(config *Config) {
+
+	env.configMutex.Lock()
+	defer env.configMutex.Unlock()
+
+	env.config = config
+}
+
+// RefreshConfig : Load current environment values in a new config and replace the previous one with it
 func (env *Env) RefreshConfig() error {
 
-	data, err := ioutil.ReadFile(configFilePath)
+	data, err := ioutil.ReadFile(os.Getenv("WAVE_CONFIG_FILE_PATH"))
 
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(data, &env.Config)
+	// Fields removed from the file are reset instead of keeping their previous values
+	config := &Config{}
+
+	err = json.Unmarshal(data, config)
 
 	if err != nil {
 		return err
 	}
 
+	env.configMutex.RLock()
+	previousConfig := env.config
+	env.configMutex.RUnlock()
+
+	// Token digests stored in Redis are keyed with the secret loaded at startup :
+	// changing it while running would make replicas disagree, it is only rotated by restarting all of them
+	if previousConfig != nil && config.TokenDigestSecret != previousConfig.TokenDigestSecret {
+		log.Println("tokenDigestSecret change ignored until restart")
+		config.TokenDigestSecret = previousConfig.TokenDigestSecret
+	}
+
+	env.SetConfig(config)
+
 	return nil
 }
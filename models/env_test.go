@@ -0,0 +1,169 @@
+package models
+
+import (
+	ioutil "io/ioutil"
+	os "os"
+	sync "sync"
+	testing "testing"
+)
+
+// writeConfigFile : Write config file pointed to by WAVE_CONFIG_FILE_PATH, return its path
+func writeConfigFile(t *testing.T, content string) string {
+
+	file, err := ioutil.TempFile("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("WAVE_CONFIG_FILE_PATH", file.Name())
+
+	return file.Name()
+}
+
+func TestRefreshConfigResetsRemovedFields(t *testing.T) {
+
+	env := &Env{}
+
+	defer os.Remove(writeConfigFile(t, `{"adminAPIKey": "key", "maxDevicesPerUser": 3, "jwt": {"algorithms": ["RS256", "ES256"]}}`))
+
+	err := env.RefreshConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := env.Config()
+
+	if config.AdminAPIKey != "key" || config.MaxDevicesPerUser != 3 || len(config.JWT.Algorithms) != 2 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+
+	defer os.Remove(writeConfigFile(t, `{"maxDevicesPerUser": 5, "jwt": {"algorithms": ["ES256"]}}`))
+
+	err = env.RefreshConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env.Config().AdminAPIKey != "" || env.Config().MaxDevicesPerUser != 5 || len(env.Config().JWT.Algorithms) != 1 {
+		t.Fatalf("unexpected config %+v", env.Config())
+	}
+
+	// Previously returned config is left untouched
+	if config.AdminAPIKey != "key" || len(config.JWT.Algorithms) != 2 {
+		t.Fatalf("previous config modified %+v", config)
+	}
+}
+
+func TestRefreshConfigKeepsConfigOnError(t *testing.T) {
+
+	env := &Env{}
+	env.SetConfig(&Config{AdminAPIKey: "key"})
+
+	defer os.Remove(writeConfigFile(t, `{"adminAPIKey": `))
+
+	if env.RefreshConfig() == nil {
+		t.Fatal("expected malformed config error")
+	}
+
+	if env.Config().AdminAPIKey != "key" {
+		t.Fatalf("config replaced by malformed one %+v", env.Config())
+	}
+}
+
+// Run with -race : config is refreshed while being read
+func TestRefreshConfigConcurrentReads(t *testing.T) {
+
+	env := &Env{}
+
+	defer os.Remove(writeConfigFile(t, `{"maxDevicesPerUser": 3, "externalAuthentication": {"acceptedStatusCodes": [200, 204]}, "argon2id": {"time": 3}}`))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				if err := env.RefreshConfig(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+
+				config := env.Config()
+
+				// Fields of a config are all loaded together
+				if config.MaxDevicesPerUser != 0 && (len(config.ExternalAuthentication.WithDefaults().AcceptedStatusCodes) != 2 || config.Argon2id.WithDefaults().Time != 3) {
+					t.Errorf("partially loaded config %+v", config)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestRefreshConfigKeepsTokenDigestSecret(t *testing.T) {
+
+	env := &Env{}
+
+	defer os.Remove(writeConfigFile(t, `{"tokenDigestSecret": "secret1", "maxDevicesPerUser": 3}`))
+
+	err := env.RefreshConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(writeConfigFile(t, `{"tokenDigestSecret": "secret2", "maxDevicesPerUser": 5}`))
+
+	err = env.RefreshConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other fields are refreshed, secret is kept until restart
+	if env.Config().TokenDigestSecret != "secret1" || env.Config().MaxDevicesPerUser != 5 {
+		t.Fatalf("unexpected config %+v", env.Config())
+	}
+}
+
+func TestRedisPoolConfigWithDefaults(t *testing.T) {
+
+	for _, test := range []struct {
+		config   RedisPoolConfig
+		expected RedisPoolConfig
+	}{
+		{RedisPoolConfig{}, RedisPoolConfig{MaxIdle: 10, MaxActive: 100, IdleTimeout: 240, HealthCheckInterval: 60}},
+		{RedisPoolConfig{MaxIdle: 2, MaxActive: 8, IdleTimeout: 30, HealthCheckInterval: 5}, RedisPoolConfig{MaxIdle: 2, MaxActive: 8, IdleTimeout: 30, HealthCheckInterval: 5}},
+		// Negative MaxActive lifts the connection limit
+		{RedisPoolConfig{MaxActive: -1}, RedisPoolConfig{MaxIdle: 10, MaxActive: 0, IdleTimeout: 240, HealthCheckInterval: 60}},
+	} {
+
+		if config := test.config.WithDefaults(); config != test.expected {
+			t.Errorf("%+v : expected %+v, got %+v", test.config, test.expected, config)
+		}
+	}
+}
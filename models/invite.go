@@ -0,0 +1,46 @@
+package models
+
+import (
+	errors "errors"
+)
+
+var (
+	// ErrGroupInviteNotFound : Invite code does not exist, expired or was revoked
+	ErrGroupInviteNotFound = errors.New("Group Invite Not Found")
+
+	// ErrGroupInviteExhausted : Invite code reached its maximum number of uses
+	ErrGroupInviteExhausted = errors.New("Group Invite Exhausted")
+)
+
+// GroupInvite : Invite code allowing users to join a group conversation by themselves
+type GroupInvite struct {
+	Code                string `json:"code"`
+	GroupConversationID string `json:"groupConversationID"`
+	CreatedBy           string `json:"createdBy"`
+	CreatedAt           int64  `json:"createdAt"`
+	ExpiresAt           int64  `json:"expiresAt"`
+
+	// MaxUses : Number of users allowed to join with the invite code (0 means no limit)
+	MaxUses int `json:"maxUses"`
+
+	// Uses : Number of users who joined with the invite code, read from its usage counter
+	Uses int `json:"uses"`
+}
+
+// NewGroupInvite : Return new GroupInvite struct pointer created by a user (internal Wave user ID)
+func NewGroupInvite(code string, groupConversationID string, createdBy string, createdAt int64, expiresAt int64, maxUses int) *GroupInvite {
+
+	return &GroupInvite{
+		Code:                code,
+		GroupConversationID: groupConversationID,
+		CreatedBy:           createdBy,
+		CreatedAt:           createdAt,
+		ExpiresAt:           expiresAt,
+		MaxUses:             maxUses,
+	}
+}
+
+// IsExhausted : Check if the invite code reached its maximum number of uses
+func (groupInvite *GroupInvite) IsExhausted() bool {
+	return groupInvite.MaxUses > 0 && groupInvite.Uses >= groupInvite.MaxUses
+}
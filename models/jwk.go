@@ -0,0 +1,26 @@
+package models
+
+// JSONWebKeySet : JSON Web Key Set document (RFC 7517)
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JSONWebKey : JSON Web Key (RFC 7517), only public RSA & EC keys and symmetric keys are supported
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// RSA public key parameters
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC public key parameters
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+
+	// Symmetric key value
+	K string `json:"k"`
+}
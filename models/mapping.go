@@ -4,4 +4,5 @@ package models
 type Mapping struct {
 	OriginalUserID     string `json:"originalUserID"`
 	InternalWaveUserID string `json:"internalWaveUserID"`
+	Unknown            bool   `json:"unknown,omitempty"`
 }
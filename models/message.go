@@ -0,0 +1,186 @@
+package models
+
+import (
+	errors "errors"
+	fmt "fmt"
+	sort "sort"
+	strconv "strconv"
+	strings "strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrNotConversationTopic : Topic is neither a private nor a group conversation topic a message can be published on
+	ErrNotConversationTopic = errors.New("Not A Conversation Topic")
+
+	// ErrInvalidMessageCursor : Message cursor is not of the form {timestamp}_{messageID}
+	ErrInvalidMessageCursor = errors.New("Invalid Message Cursor")
+)
+
+// ConversationMessage : Message published on a private or group conversation topic, as archived.
+// Sender is the internal Wave user ID of the publisher, or GroupSystemTopicLevel for system messages of a group.
+type ConversationMessage struct {
+	MessageID           string `json:"messageID" bson:"messageID"`
+	ConversationID      string `json:"conversationID" bson:"conversationID"`
+	Sender              string `json:"sender" bson:"sender"`
+	Recipient           string `json:"recipient,omitempty" bson:"recipient,omitempty"`
+	GroupConversationID string `json:"groupConversationID,omitempty" bson:"groupConversationID,omitempty"`
+	Topic               string `json:"topic" bson:"topic"`
+	Payload             []byte `json:"payload" bson:"payload"`
+
+	// Timestamp : Reception date of the message in milliseconds since epoch
+	Timestamp int64 `json:"timestamp" bson:"timestamp"`
+
+	// DeliveryKey : Digest of the topic, payload & packet identifier of the delivery, shared by its redeliveries
+	DeliveryKey string `json:"-" bson:"deliveryKey,omitempty"`
+
+	// Cursor : Position of the message in its conversation, only set in history responses
+	Cursor string `json:"cursor,omitempty" bson:"-"`
+}
+
+// MessageCursor : Position of a message in its conversation, messages being ordered by timestamp then message ID
+type MessageCursor struct {
+	Timestamp int64
+	MessageID string
+}
+
+// ConversationMessagesQuery : Page of archived messages of a conversation to read.
+// Messages closest to Before (or the latest ones) are read, unless only After is set.
+type ConversationMessagesQuery struct {
+	ConversationID string
+	Group          bool
+	Before         *MessageCursor
+	After          *MessageCursor
+	Limit          int64
+}
+
+// ConversationMessagesPurge : Archived messages to delete, older than BeforeTimestamp (milliseconds).
+// Messages of all private or group conversations but the excluded ones are deleted, unless ConversationID is set.
+type ConversationMessagesPurge struct {
+	Group                   bool
+	ConversationID          string
+	ExcludedConversationIDs []string
+	BeforeTimestamp         int64
+	DryRun                  bool
+}
+
+// ConversationMessagesPage : Page of archived messages by date, HasMore tells if more messages match the query beyond the page
+type ConversationMessagesPage struct {
+	Messages []*ConversationMessage `json:"messages"`
+	HasMore  bool                   `json:"hasMore"`
+}
+
+// NewConversationMessage : Return new ConversationMessage struct pointer for a message received on topic.
+// Sender & recipient (or group) are read from the topic path, which the publish ACLs bind to the publisher.
+func NewConversationMessage(topic string, payload []byte, timestamp int64) (*ConversationMessage, error) {
+
+	message := &ConversationMessage{
+		MessageID: uuid.NewV4().String(),
+		Topic:     topic,
+		Payload:   payload,
+		Timestamp: timestamp,
+	}
+
+	switch {
+
+	// conversations/private/{sender}/{recipient}
+	case strings.HasPrefix(topic, PrivateConversationTopicPath):
+
+		levels := strings.Split(strings.TrimPrefix(topic, PrivateConversationTopicPath), "/")
+
+		if len(levels) != 2 || levels[0] == "" || levels[1] == "" {
+			return nil, ErrNotConversationTopic
+		}
+
+		message.Sender = levels[0]
+		message.Recipient = levels[1]
+		message.ConversationID = PrivateConversationID(levels[0], levels[1])
+
+	// conversations/group/{groupConversationID}/{sender}
+	case strings.HasPrefix(topic, GroupConversationTopicPath):
+
+		levels := strings.Split(strings.TrimPrefix(topic, GroupConversationTopicPath), "/")
+
+		if len(levels) != 2 || levels[0] == "" || levels[1] == "" {
+			return nil, ErrNotConversationTopic
+		}
+
+		message.GroupConversationID = levels[0]
+		message.Sender = levels[1]
+		message.ConversationID = levels[0]
+
+	default:
+		return nil, ErrNotConversationTopic
+	}
+
+	return message, nil
+}
+
+// IsAscending : Check if the page is read from the after cursor onwards, rather than backwards from the before cursor or the latest message
+func (query *ConversationMessagesQuery) IsAscending() bool {
+	return query.After != nil && query.Before == nil
+}
+
+// NewConversationMessagesPage : Return page of messages read for query, by date.
+// Messages are read in the order of the query and one more message than its limit is expected if there are more messages beyond the page.
+func NewConversationMessagesPage(query *ConversationMessagesQuery, messages []*ConversationMessage) *ConversationMessagesPage {
+
+	page := &ConversationMessagesPage{Messages: messages}
+
+	if int64(len(messages)) > query.Limit {
+		page.Messages = messages[:query.Limit]
+		page.HasMore = true
+	}
+
+	// Pages read backwards are returned by date too
+	if !query.IsAscending() {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+
+	return page
+}
+
+// MessageCursor : Return position of the message in its conversation
+func (message *ConversationMessage) MessageCursor() *MessageCursor {
+	return &MessageCursor{Timestamp: message.Timestamp, MessageID: message.MessageID}
+}
+
+// String : Return cursor under the form {timestamp}_{messageID}
+func (cursor *MessageCursor) String() string {
+	return fmt.Sprintf("%d_%s", cursor.Timestamp, cursor.MessageID)
+}
+
+// ParseMessageCursor : Return message cursor from its string form, nil if empty
+func ParseMessageCursor(value string) (*MessageCursor, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(value, "_", 2)
+
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidMessageCursor
+	}
+
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return nil, ErrInvalidMessageCursor
+	}
+
+	return &MessageCursor{Timestamp: timestamp, MessageID: parts[1]}, nil
+}
+
+// PrivateConversationID : Return ID of the private conversation between two users (internal Wave user IDs),
+// the same whichever of them sent the message
+func PrivateConversationID(internalWaveUserID string, otherInternalWaveUserID string) string {
+
+	participants := []string{internalWaveUserID, otherInternalWaveUserID}
+	sort.Strings(participants)
+
+	return participants[0] + ":" + participants[1]
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	reflect "reflect"
+	testing "testing"
+)
+
+func TestNewConversationMessage(t *testing.T) {
+
+	for _, test := range []struct {
+		topic    string
+		expected *ConversationMessage
+	}{
+		{"conversations/private/user1/user2", &ConversationMessage{ConversationID: "user1:user2", Sender: "user1", Recipient: "user2"}},
+		{"conversations/private/user2/user1", &ConversationMessage{ConversationID: "user1:user2", Sender: "user2", Recipient: "user1"}},
+		{"conversations/group/group1/user1", &ConversationMessage{ConversationID: "group1", Sender: "user1", GroupConversationID: "group1"}},
+		{"conversations/group/group1/system", &ConversationMessage{ConversationID: "group1", Sender: GroupSystemTopicLevel, GroupConversationID: "group1"}},
+		{"conversations/private/user1", nil},
+		{"conversations/private/user1/", nil},
+		{"conversations/private//user2", nil},
+		{"conversations/private/user1/user2/extra", nil},
+		{"conversations/private/", nil},
+		{"conversations/group/group1", nil},
+		{"conversations/group//user1", nil},
+		{"conversations/group/group1/user1/extra", nil},
+		{"conversations/other/user1/user2", nil},
+		{"users/user1", nil},
+		{"", nil},
+	} {
+
+		message, err := NewConversationMessage(test.topic, []byte("payload"), 1546300800000)
+
+		if test.expected == nil {
+
+			if err != ErrNotConversationTopic {
+				t.Errorf("%s : expected ErrNotConversationTopic, got %+v, %v", test.topic, message, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s : %v", test.topic, err)
+			continue
+		}
+
+		if message.ConversationID != test.expected.ConversationID || message.Sender != test.expected.Sender ||
+			message.Recipient != test.expected.Recipient || message.GroupConversationID != test.expected.GroupConversationID {
+			t.Errorf("%s : unexpected message %+v", test.topic, message)
+		}
+
+		if message.Topic != test.topic || string(message.Payload) != "payload" || message.Timestamp != 1546300800000 || message.MessageID == "" {
+			t.Errorf("%s : incomplete message %+v", test.topic, message)
+		}
+	}
+}
+
+func TestNewConversationMessagesPage(t *testing.T) {
+
+	cursor := &MessageCursor{Timestamp: 1546300800000, MessageID: "message"}
+
+	for _, test := range []struct {
+		name     string
+		query    ConversationMessagesQuery
+		read     []string
+		expected []string
+		hasMore  bool
+	}{
+		// Latest messages & pages before a cursor are read backwards, from the newest message
+		{"latest", ConversationMessagesQuery{Limit: 3}, []string{"m5", "m4", "m3", "m2"}, []string{"m3", "m4", "m5"}, true},
+		{"latest, last page", ConversationMessagesQuery{Limit: 3}, []string{"m3", "m2", "m1"}, []string{"m1", "m2", "m3"}, false},
+		{"before", ConversationMessagesQuery{Before: cursor, Limit: 2}, []string{"m4", "m3", "m2"}, []string{"m3", "m4"}, true},
+		{"before, last page", ConversationMessagesQuery{Before: cursor, Limit: 2}, []string{"m1"}, []string{"m1"}, false},
+		{"before & after", ConversationMessagesQuery{Before: cursor, After: cursor, Limit: 2}, []string{"m4", "m3", "m2"}, []string{"m3", "m4"}, true},
+
+		// Pages after a cursor are read forwards, from the oldest message
+		{"after", ConversationMessagesQuery{After: cursor, Limit: 2}, []string{"m2", "m3", "m4"}, []string{"m2", "m3"}, true},
+		{"after, last page", ConversationMessagesQuery{After: cursor, Limit: 2}, []string{"m2", "m3"}, []string{"m2", "m3"}, false},
+		{"empty", ConversationMessagesQuery{After: cursor, Limit: 2}, []string{}, []string{}, false},
+	} {
+
+		messages := []*ConversationMessage{}
+
+		for _, messageID := range test.read {
+			messages = append(messages, &ConversationMessage{MessageID: messageID})
+		}
+
+		page := NewConversationMessagesPage(&test.query, messages)
+
+		messageIDs := []string{}
+
+		for _, message := range page.Messages {
+			messageIDs = append(messageIDs, message.MessageID)
+		}
+
+		if !reflect.DeepEqual(messageIDs, test.expected) || page.HasMore != test.hasMore {
+			t.Errorf("%s : expected %v (hasMore %v), got %v (hasMore %v)", test.name, test.expected, test.hasMore, messageIDs, page.HasMore)
+		}
+	}
+}
+
+func TestParseMessageCursor(t *testing.T) {
+
+	for value, expected := range map[string]*MessageCursor{
+		"1546300800000_message1":  {Timestamp: 1546300800000, MessageID: "message1"},
+		"1546300800000_message_1": {Timestamp: 1546300800000, MessageID: "message_1"},
+		"0_message1":              {Timestamp: 0, MessageID: "message1"},
+		"":                        nil,
+		"1546300800000":           nil,
+		"1546300800000_":          nil,
+		"_message1":               nil,
+		"date_message1":           nil,
+	} {
+
+		cursor, err := ParseMessageCursor(value)
+
+		if expected == nil {
+
+			if cursor != nil || (value != "" && err != ErrInvalidMessageCursor) {
+				t.Errorf("%q : expected ErrInvalidMessageCursor, got %+v, %v", value, cursor, err)
+			}
+
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(cursor, expected) || cursor.String() != value {
+			t.Errorf("%q : expected %+v, got %+v, %v", value, expected, cursor, err)
+		}
+	}
+}
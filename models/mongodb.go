@@ -2,10 +2,12 @@ package models
 
 import (
 	context "context"
+	errors "errors"
 	utils "wave-messaging-management-service/utils"
 
 	mongoBSON "github.com/mongodb/mongo-go-driver/bson"
 	mongo "github.com/mongodb/mongo-go-driver/mongo"
+	findopt "github.com/mongodb/mongo-go-driver/mongo/findopt"
 	bson "gopkg.in/mgo.v2/bson"
 )
 
@@ -22,24 +24,53 @@ const (
 
 	// GroupConversationCollection : MongoDB Collection containing group private conversations backups
 	GroupConversationCollection = "groupConversations"
+
+	// GroupConversationMessagesCollection : MongoDB Collection containing archived messages of group conversations
+	GroupConversationMessagesCollection = "groupConversationMessages"
+)
+
+var (
+	// ErrGroupConversationNotFound : Returned when no group conversation has the requested ID
+	ErrGroupConversationNotFound = errors.New("group conversation not found")
 )
 
 // MongoDBInterface : MongoDB Communication interface
 type MongoDBInterface interface {
 	AddGroupConversation(groupConversation *GroupConversation) error
+	AddGroupConversationMembers(groupConversationID string, members []string) error
 	AddProfileACL(verneMQACL *VerneMQACL) error
+	ArchiveConversationMessage(message *ConversationMessage) error
 	AuthorizePublishing(userID string, topic string) error
+	BackfillGroupConversationOwners() (int, error)
+	DeleteGroupConversation(groupConversationID string) error
+	EnsureIndexes() error
+	GetConversationMessages(query *ConversationMessagesQuery) (*ConversationMessagesPage, error)
+	GetGroupConversation(groupConversationID string) (*GroupConversation, error)
+	GetGroupConversationsOfMember(member string, afterGroupConversationID string, limit int64) ([]*GroupConversation, error)
+	GetGroupConversationsWithRetention() ([]*GroupConversation, error)
+	GetProfileACL(clientID string) (*VerneMQACL, error)
+	GetUserProfileACL(username string) (*VerneMQACL, error)
+	GrantGroupACL(groupConversation *GroupConversation, members []string) error
+	HasArchivedConversationMessage(group bool, deliveryKey string, sinceTimestamp int64) (bool, error)
+	PurgeConversationMessages(purge *ConversationMessagesPurge) (int64, error)
+	RemoveGroupConversationMembers(groupConversationID string, members []string) error
+	RevokeGroupACL(groupConversation *GroupConversation, members []string) error
+	SetGroupConversationRoles(groupConversationID string, expectedOwner string, owner string, admins []string) (bool, error)
 	UpdateProfilesWithGroupACL(groupConversation *GroupConversation) error
-	UpdatePassHash(userID string, newPasshash string) error
+	UpdateGroupConversationMetadata(groupConversation *GroupConversation) error
+	UpdatePassHash(userID string, newPasshash string, hashAlgorithm string) error
+	UpdateProfileACL(verneMQACL *VerneMQACL) error
+	ReplacePassHash(clientID string, oldPasshash string, newPasshash string, hashAlgorithm string) (bool, error)
 }
 
 // MongoDB : MongoDB communication interface
 type MongoDB struct {
-	Client                         *mongo.Client
-	WaveDB                         *mongo.Database
-	PrivateConversationsCollection *mongo.Collection
-	VerneMQACLCollection           *mongo.Collection
-	GroupConversationCollection    *mongo.Collection
+	Client                              *mongo.Client
+	WaveDB                              *mongo.Database
+	PrivateConversationsCollection      *mongo.Collection
+	VerneMQACLCollection                *mongo.Collection
+	GroupConversationCollection         *mongo.Collection
+	GroupConversationMessagesCollection *mongo.Collection
 }
 
 // NewMongoDB : Return a new MongoDB abstraction struct
@@ -65,14 +96,16 @@ func NewMongoDB(connectionURL string) *MongoDB {
 	privateConversationsCollection := waveDB.Collection(PrivateConversationsCollection)
 	vmqACLCollection := waveDB.Collection(VerneMQACLCollection)
 	groupConversationCollection := waveDB.Collection(GroupConversationCollection)
+	groupConversationMessagesCollection := waveDB.Collection(GroupConversationMessagesCollection)
 
 	// Return new MongoDB abstraction struct
 	return &MongoDB{
-		Client:                         client,
-		WaveDB:                         waveDB,
-		PrivateConversationsCollection: privateConversationsCollection,
-		VerneMQACLCollection:           vmqACLCollection,
-		GroupConversationCollection:    groupConversationCollection,
+		Client:                              client,
+		WaveDB:                              waveDB,
+		PrivateConversationsCollection:      privateConversationsCollection,
+		VerneMQACLCollection:                vmqACLCollection,
+		GroupConversationCollection:         groupConversationCollection,
+		GroupConversationMessagesCollection: groupConversationMessagesCollection,
 	}
 }
 
@@ -96,6 +129,331 @@ func (mongoDB *MongoDB) AddGroupConversation(groupConversation *GroupConversatio
 	return nil
 }
 
+// ArchiveConversationMessage : Add message entry in the private conversations collection, or in the group conversation messages collection
+func (mongoDB *MongoDB) ArchiveConversationMessage(message *ConversationMessage) error {
+
+	// Marshal struct into bson object
+	doc, err := bson.Marshal(*message)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = mongoDB.messagesCollection(message.GroupConversationID != "").InsertOne(nil, doc)
+
+	return err
+}
+
+// GetConversationMessages : Get page of archived messages of a conversation matching query cursors, by date.
+// At most query.Limit messages closest to the before cursor (or the latest ones) are returned, or closest to the after cursor if it is the only one set.
+func (mongoDB *MongoDB) GetConversationMessages(query *ConversationMessagesQuery) (*ConversationMessagesPage, error) {
+
+	filter := mongoBSON.NewDocument(
+		mongoBSON.EC.String("conversationID", query.ConversationID),
+	)
+
+	bounds := []*mongoBSON.Value{}
+
+	if query.Before != nil {
+		bounds = append(bounds, messageCursorBound("$lt", query.Before))
+	}
+
+	if query.After != nil {
+		bounds = append(bounds, messageCursorBound("$gt", query.After))
+	}
+
+	if len(bounds) > 0 {
+		filter.Append(mongoBSON.EC.ArrayFromElements("$and", bounds...))
+	}
+
+	order := int32(-1)
+
+	if query.IsAscending() {
+		order = 1
+	}
+
+	cursor, err := mongoDB.messagesCollection(query.Group).Find(
+		nil,
+		filter,
+		findopt.Sort(mongoBSON.NewDocument(
+			mongoBSON.EC.Int32("timestamp", order),
+			mongoBSON.EC.Int32("messageID", order),
+		)),
+		// One more message is fetched to know if there are more messages beyond the page
+		findopt.Limit(query.Limit+1),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(nil)
+
+	messages := []*ConversationMessage{}
+
+	for cursor.Next(nil) {
+
+		message := ConversationMessage{}
+		err = cursor.Decode(&message)
+
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &message)
+	}
+
+	if cursor.Err() != nil {
+		return nil, cursor.Err()
+	}
+
+	return NewConversationMessagesPage(query, messages), nil
+}
+
+// HasArchivedConversationMessage : Check if a message of a delivery was archived since timestamp (in milliseconds since epoch)
+func (mongoDB *MongoDB) HasArchivedConversationMessage(group bool, deliveryKey string, sinceTimestamp int64) (bool, error) {
+
+	filter := mongoBSON.NewDocument(
+		mongoBSON.EC.String("deliveryKey", deliveryKey),
+		mongoBSON.EC.SubDocumentFromElements("timestamp",
+			mongoBSON.EC.Int64("$gte", sinceTimestamp),
+		),
+	)
+
+	count, err := mongoDB.messagesCollection(group).Count(nil, filter)
+
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// PurgeConversationMessages : Delete archived messages matching purge. Returns the number of deleted messages, only counted in dry run.
+func (mongoDB *MongoDB) PurgeConversationMessages(purge *ConversationMessagesPurge) (int64, error) {
+
+	filter := mongoBSON.NewDocument(
+		mongoBSON.EC.SubDocumentFromElements("timestamp",
+			mongoBSON.EC.Int64("$lt", purge.BeforeTimestamp),
+		),
+	)
+
+	if purge.ConversationID != "" {
+		filter.Append(mongoBSON.EC.String("conversationID", purge.ConversationID))
+	} else if len(purge.ExcludedConversationIDs) > 0 {
+		filter.Append(mongoBSON.EC.SubDocumentFromElements("conversationID",
+			mongoBSON.EC.ArrayFromElements("$nin", stringValues(purge.ExcludedConversationIDs)...),
+		))
+	}
+
+	collection := mongoDB.messagesCollection(purge.Group)
+
+	if purge.DryRun {
+		return collection.Count(nil, filter)
+	}
+
+	result, err := collection.DeleteMany(nil, filter)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// messagesCollection : Return collection holding archived messages of group conversations or private conversations
+func (mongoDB *MongoDB) messagesCollection(group bool) *mongo.Collection {
+
+	if group {
+		return mongoDB.GroupConversationMessagesCollection
+	}
+
+	return mongoDB.PrivateConversationsCollection
+}
+
+// messageCursorBound : Return condition matching messages before ($lt) or after ($gt) a message cursor
+func messageCursorBound(operator string, messageCursor *MessageCursor) *mongoBSON.Value {
+
+	return mongoBSON.VC.DocumentFromElements(
+		mongoBSON.EC.ArrayFromElements("$or",
+			mongoBSON.VC.DocumentFromElements(
+				mongoBSON.EC.SubDocumentFromElements("timestamp",
+					mongoBSON.EC.Int64(operator, messageCursor.Timestamp),
+				),
+			),
+			mongoBSON.VC.DocumentFromElements(
+				mongoBSON.EC.Int64("timestamp", messageCursor.Timestamp),
+				mongoBSON.EC.SubDocumentFromElements("messageID",
+					mongoBSON.EC.String(operator, messageCursor.MessageID),
+				),
+			),
+		),
+	)
+}
+
+// EnsureIndexes : Create indexes used by queries if they do not exist yet
+func (mongoDB *MongoDB) EnsureIndexes() error {
+
+	_, err := mongoDB.GroupConversationCollection.Indexes().CreateMany(
+		nil,
+		[]mongo.IndexModel{
+			// Group lookup by ID
+			{
+				Keys: mongoBSON.NewDocument(
+					mongoBSON.EC.Int32("groupConversationID", 1),
+				),
+				Options: mongo.NewIndexOptionsBuilder().Unique(true).Build(),
+			},
+			// Groups of a member, paginated by ID
+			{
+				Keys: mongoBSON.NewDocument(
+					mongoBSON.EC.Int32("members", 1),
+					mongoBSON.EC.Int32("groupConversationID", 1),
+				),
+			},
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range []*mongo.Collection{mongoDB.PrivateConversationsCollection, mongoDB.GroupConversationMessagesCollection} {
+
+		_, err = collection.Indexes().CreateMany(
+			nil,
+			[]mongo.IndexModel{
+				// Messages of a conversation, paginated by date
+				{
+					Keys: mongoBSON.NewDocument(
+						mongoBSON.EC.Int32("conversationID", 1),
+						mongoBSON.EC.Int32("timestamp", 1),
+						mongoBSON.EC.Int32("messageID", 1),
+					),
+				},
+				// Expired messages of all conversations
+				{
+					Keys: mongoBSON.NewDocument(
+						mongoBSON.EC.Int32("timestamp", 1),
+					),
+				},
+				// Earlier deliveries of redelivered messages
+				{
+					Keys: mongoBSON.NewDocument(
+						mongoBSON.EC.Int32("deliveryKey", 1),
+						mongoBSON.EC.Int32("timestamp", 1),
+					),
+				},
+			},
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetGroupConversation : Get group conversation from database
+func (mongoDB *MongoDB) GetGroupConversation(groupConversationID string) (*GroupConversation, error) {
+
+	groupConversation := GroupConversation{}
+
+	err := mongoDB.GroupConversationCollection.FindOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversationID),
+		),
+	).Decode(&groupConversation)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrGroupConversationNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupConversation, nil
+}
+
+// GetGroupConversationsOfMember : Get at most limit group conversations of a member (internal Wave user ID) from database,
+// ordered by ID and starting after afterGroupConversationID (from the first one if empty)
+func (mongoDB *MongoDB) GetGroupConversationsOfMember(member string, afterGroupConversationID string, limit int64) ([]*GroupConversation, error) {
+
+	cursor, err := mongoDB.GroupConversationCollection.Find(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("members", member),
+			mongoBSON.EC.SubDocumentFromElements("groupConversationID",
+				mongoBSON.EC.String("$gt", afterGroupConversationID),
+			),
+		),
+		findopt.Sort(mongoBSON.NewDocument(
+			mongoBSON.EC.Int32("groupConversationID", 1),
+		)),
+		findopt.Limit(limit),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(nil)
+
+	groupConversations := []*GroupConversation{}
+
+	for cursor.Next(nil) {
+
+		groupConversation := GroupConversation{}
+		err = cursor.Decode(&groupConversation)
+
+		if err != nil {
+			return nil, err
+		}
+
+		groupConversations = append(groupConversations, &groupConversation)
+	}
+
+	return groupConversations, cursor.Err()
+}
+
+// GetGroupConversationsWithRetention : Get group conversations overriding the global retention of their messages
+func (mongoDB *MongoDB) GetGroupConversationsWithRetention() ([]*GroupConversation, error) {
+
+	cursor, err := mongoDB.GroupConversationCollection.Find(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("retentionDays",
+				mongoBSON.EC.Int64("$gt", 0),
+			),
+		),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(nil)
+
+	groupConversations := []*GroupConversation{}
+
+	for cursor.Next(nil) {
+
+		groupConversation := GroupConversation{}
+		err = cursor.Decode(&groupConversation)
+
+		if err != nil {
+			return nil, err
+		}
+
+		groupConversations = append(groupConversations, &groupConversation)
+	}
+
+	return groupConversations, cursor.Err()
+}
+
 // AddProfileACL : Add VerneMQ ACL for user in database
 // Should be trigerred when a user connect for the first time
 func (mongoDB *MongoDB) AddProfileACL(verneMQACL *VerneMQACL) error {
@@ -117,13 +475,13 @@ func (mongoDB *MongoDB) AddProfileACL(verneMQACL *VerneMQACL) error {
 	return nil
 }
 
-// AuthorizePublishing : Authorize publishing on MQTT topic for userID
+// AuthorizePublishing : Authorize publishing on MQTT topic for userID (on all of its devices)
 func (mongoDB *MongoDB) AuthorizePublishing(userID string, topic string) error {
 
-	_, err := mongoDB.VerneMQACLCollection.UpdateOne(
+	_, err := mongoDB.VerneMQACLCollection.UpdateMany(
 		nil,
 		mongoBSON.NewDocument(
-			mongoBSON.EC.String("client_id", userID),
+			mongoBSON.EC.String("username", userID),
 		),
 		mongoBSON.NewDocument(
 			mongoBSON.EC.SubDocumentFromElements("$push",
@@ -137,24 +495,92 @@ func (mongoDB *MongoDB) AuthorizePublishing(userID string, topic string) error {
 	return nil
 }
 
-// UpdateProfilesWithGroupACL : Update VerneMQ Acls in database to grant publish and read access to all members of the group
+// GetProfileACL : Get VerneMQ ACL of a user from database
+func (mongoDB *MongoDB) GetProfileACL(clientID string) (*VerneMQACL, error) {
+
+	verneMQACL := VerneMQACL{}
+
+	err := mongoDB.VerneMQACLCollection.FindOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("client_id", clientID),
+		),
+	).Decode(&verneMQACL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &verneMQACL, nil
+}
+
+// GetUserProfileACL : Get VerneMQ ACL of one of the devices of a user from database
+func (mongoDB *MongoDB) GetUserProfileACL(username string) (*VerneMQACL, error) {
+
+	verneMQACL := VerneMQACL{}
+
+	err := mongoDB.VerneMQACLCollection.FindOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("username", username),
+		),
+	).Decode(&verneMQACL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &verneMQACL, nil
+}
+
+// UpdateProfilesWithGroupACL : Update VerneMQ Acls in database to grant publish and read access to all members of the group (on all of their devices)
 func (mongoDB *MongoDB) UpdateProfilesWithGroupACL(groupConversation *GroupConversation) error {
+	return mongoDB.GrantGroupACL(groupConversation, groupConversation.Members)
+}
+
+// GrantGroupACL : Update VerneMQ Acls in database to grant publish and read access to some members of the group (on all of their devices).
+// Patterns are only added if absent so that granting access twice is harmless.
+func (mongoDB *MongoDB) GrantGroupACL(groupConversation *GroupConversation, members []string) error {
 
-	for _, userID := range groupConversation.Members {
+	for _, userID := range members {
 
-		_, err := mongoDB.VerneMQACLCollection.UpdateOne(
+		_, err := mongoDB.VerneMQACLCollection.UpdateMany(
 			nil,
 			mongoBSON.NewDocument(
-				mongoBSON.EC.String("client_id", userID),
+				mongoBSON.EC.String("username", userID),
 			),
 			mongoBSON.NewDocument(
-				mongoBSON.EC.SubDocumentFromElements("$push",
+				mongoBSON.EC.SubDocumentFromElements("$addToSet",
 					mongoBSON.EC.SubDocumentFromElements("publish_acl",
-						mongoBSON.EC.String("pattern", GroupConversationTopicPath+groupConversation.GroupConversationID+"/"+userID)),
+						mongoBSON.EC.String("pattern", groupConversation.PublishTopic(userID))),
+					mongoBSON.EC.SubDocumentFromElements("subscribe_acl",
+						mongoBSON.EC.String("pattern", groupConversation.SubscribeTopic())),
 				),
-				mongoBSON.EC.SubDocumentFromElements("$push",
+			),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeGroupACL : Update VerneMQ Acls in database to remove publish and read access of some members of the group (on all of their devices)
+func (mongoDB *MongoDB) RevokeGroupACL(groupConversation *GroupConversation, members []string) error {
+
+	for _, userID := range members {
+
+		_, err := mongoDB.VerneMQACLCollection.UpdateMany(
+			nil,
+			mongoBSON.NewDocument(
+				mongoBSON.EC.String("username", userID),
+			),
+			mongoBSON.NewDocument(
+				mongoBSON.EC.SubDocumentFromElements("$pull",
+					mongoBSON.EC.SubDocumentFromElements("publish_acl",
+						mongoBSON.EC.String("pattern", groupConversation.PublishTopic(userID))),
 					mongoBSON.EC.SubDocumentFromElements("subscribe_acl",
-						mongoBSON.EC.String("pattern", GroupConversationTopicPath+groupConversation.GroupConversationID+"/+")),
+						mongoBSON.EC.String("pattern", groupConversation.SubscribeTopic())),
 				),
 			),
 		)
@@ -165,8 +591,208 @@ func (mongoDB *MongoDB) UpdateProfilesWithGroupACL(groupConversation *GroupConve
 	return nil
 }
 
-// UpdatePassHash : Update passhash field in VerneMQ ACLs Collection Acls
-func (mongoDB *MongoDB) UpdatePassHash(userID string, newPasshash string) error {
+// AddGroupConversationMembers : Add members (internal Wave user IDs) to a group conversation, members already in the group are skipped
+func (mongoDB *MongoDB) AddGroupConversationMembers(groupConversationID string, members []string) error {
+
+	_, err := mongoDB.GroupConversationCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversationID),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$addToSet",
+				mongoBSON.EC.SubDocumentFromElements("members",
+					mongoBSON.EC.ArrayFromElements("$each", stringValues(members)...)),
+			),
+		),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveGroupConversationMembers : Remove members (internal Wave user IDs) from a group conversation, along with their admin role
+func (mongoDB *MongoDB) RemoveGroupConversationMembers(groupConversationID string, members []string) error {
+
+	_, err := mongoDB.GroupConversationCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversationID),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$pull",
+				mongoBSON.EC.SubDocumentFromElements("members",
+					mongoBSON.EC.ArrayFromElements("$in", stringValues(members)...)),
+				mongoBSON.EC.SubDocumentFromElements("admins",
+					mongoBSON.EC.ArrayFromElements("$in", stringValues(members)...)),
+			),
+		),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateGroupConversationMetadata : Update name, description, avatar, attributes, retention & update date of a group conversation
+func (mongoDB *MongoDB) UpdateGroupConversationMetadata(groupConversation *GroupConversation) error {
+
+	attributes := []*mongoBSON.Element{}
+
+	for key, value := range groupConversation.Attributes {
+		attributes = append(attributes, mongoBSON.EC.String(key, value))
+	}
+
+	_, err := mongoDB.GroupConversationCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversation.GroupConversationID),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$set",
+				mongoBSON.EC.String("name", groupConversation.Name),
+				mongoBSON.EC.String("description", groupConversation.Description),
+				mongoBSON.EC.String("avatarURL", groupConversation.AvatarURL),
+				mongoBSON.EC.SubDocumentFromElements("attributes", attributes...),
+				mongoBSON.EC.Int64("retentionDays", int64(groupConversation.RetentionDays)),
+				mongoBSON.EC.Int64("updatedAt", groupConversation.UpdatedAt),
+			),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteGroupConversation : Delete group conversation entry from database
+func (mongoDB *MongoDB) DeleteGroupConversation(groupConversationID string) error {
+
+	_, err := mongoDB.GroupConversationCollection.DeleteOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversationID),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetGroupConversationRoles : Set owner and admins of a group conversation only if it is still owned by expectedOwner.
+// Returns false if ownership changed in the meantime.
+func (mongoDB *MongoDB) SetGroupConversationRoles(groupConversationID string, expectedOwner string, owner string, admins []string) (bool, error) {
+
+	res, err := mongoDB.GroupConversationCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("groupConversationID", groupConversationID),
+			mongoBSON.EC.String("owner", expectedOwner),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$set",
+				mongoBSON.EC.String("owner", owner),
+				mongoBSON.EC.ArrayFromElements("admins", stringValues(admins)...),
+			),
+		),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// BackfillGroupConversationOwners : Set owner of group conversations created before roles were recorded to their creator,
+// who was appended last to the members on creation. Returns the number of updated group conversations.
+func (mongoDB *MongoDB) BackfillGroupConversationOwners() (int, error) {
+
+	cursor, err := mongoDB.GroupConversationCollection.Find(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("owner",
+				mongoBSON.EC.Boolean("$exists", false),
+			),
+		),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer cursor.Close(nil)
+
+	backfilled := 0
+
+	for cursor.Next(nil) {
+
+		groupConversation := GroupConversation{}
+		err = cursor.Decode(&groupConversation)
+
+		if err != nil {
+			return backfilled, err
+		}
+
+		if len(groupConversation.Members) == 0 {
+			continue
+		}
+
+		_, err = mongoDB.GroupConversationCollection.UpdateOne(
+			nil,
+			mongoBSON.NewDocument(
+				mongoBSON.EC.String("groupConversationID", groupConversation.GroupConversationID),
+				mongoBSON.EC.SubDocumentFromElements("owner",
+					mongoBSON.EC.Boolean("$exists", false),
+				),
+			),
+			mongoBSON.NewDocument(
+				mongoBSON.EC.SubDocumentFromElements("$set",
+					mongoBSON.EC.String("owner", groupConversation.Members[len(groupConversation.Members)-1]),
+					mongoBSON.EC.ArrayFromElements("admins"),
+				),
+			),
+		)
+
+		if err != nil {
+			return backfilled, err
+		}
+
+		backfilled++
+	}
+
+	return backfilled, cursor.Err()
+}
+
+// aclValues : Convert ACL entries into BSON values
+func aclValues(acls []*ACL) []*mongoBSON.Value {
+
+	values := []*mongoBSON.Value{}
+
+	for _, acl := range acls {
+		values = append(values, mongoBSON.VC.DocumentFromElements(mongoBSON.EC.String("pattern", acl.Pattern)))
+	}
+
+	return values
+}
+
+// stringValues : Convert strings into BSON values
+func stringValues(strs []string) []*mongoBSON.Value {
+
+	values := []*mongoBSON.Value{}
+
+	for _, str := range strs {
+		values = append(values, mongoBSON.VC.String(str))
+	}
+
+	return values
+}
+
+// UpdatePassHash : Update passhash field in VerneMQ ACLs Collection Acls of a device profile (by client ID)
+func (mongoDB *MongoDB) UpdatePassHash(userID string, newPasshash string, hashAlgorithm string) error {
 
 	_, err := mongoDB.VerneMQACLCollection.UpdateOne(
 		nil,
@@ -176,6 +802,33 @@ func (mongoDB *MongoDB) UpdatePassHash(userID string, newPasshash string) error
 		mongoBSON.NewDocument(
 			mongoBSON.EC.SubDocumentFromElements("$set",
 				mongoBSON.EC.String("passhash", newPasshash),
+				mongoBSON.EC.String("hash_algorithm", hashAlgorithm),
+			),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateProfileACL : Replace credentials & ACLs of a device profile (by client ID)
+func (mongoDB *MongoDB) UpdateProfileACL(verneMQACL *VerneMQACL) error {
+
+	_, err := mongoDB.VerneMQACLCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("client_id", verneMQACL.ClientID),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$set",
+				mongoBSON.EC.String("mountpoint", verneMQACL.Mountpoint),
+				mongoBSON.EC.String("username", verneMQACL.Username),
+				mongoBSON.EC.String("passhash", verneMQACL.Passhash),
+				mongoBSON.EC.String("hash_algorithm", verneMQACL.HashAlgorithm),
+				mongoBSON.EC.ArrayFromElements("publish_acl", aclValues(verneMQACL.PublishACL)...),
+				mongoBSON.EC.ArrayFromElements("subscribe_acl", aclValues(verneMQACL.SubscribeACL)...),
 			),
 		),
 	)
@@ -185,3 +838,27 @@ func (mongoDB *MongoDB) UpdatePassHash(userID string, newPasshash string) error
 
 	return nil
 }
+
+// ReplacePassHash : Update passhash field of a device profile only if it still holds oldPasshash.
+// Returns false if passhash was changed in the meantime.
+func (mongoDB *MongoDB) ReplacePassHash(clientID string, oldPasshash string, newPasshash string, hashAlgorithm string) (bool, error) {
+
+	res, err := mongoDB.VerneMQACLCollection.UpdateOne(
+		nil,
+		mongoBSON.NewDocument(
+			mongoBSON.EC.String("client_id", clientID),
+			mongoBSON.EC.String("passhash", oldPasshash),
+		),
+		mongoBSON.NewDocument(
+			mongoBSON.EC.SubDocumentFromElements("$set",
+				mongoBSON.EC.String("passhash", newPasshash),
+				mongoBSON.EC.String("hash_algorithm", hashAlgorithm),
+			),
+		),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
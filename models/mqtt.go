@@ -0,0 +1,198 @@
+package models
+
+import (
+	fmt "fmt"
+	log "log"
+	sync "sync"
+	time "time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// MQTTOperationTimeout : Maximum duration of a connection or publication to the broker
+	MQTTOperationTimeout = 10 * time.Second
+)
+
+// MQTTMessage : Message received on a subscription
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+
+	// PacketID : Packet identifier of the delivery, reused by the broker when redelivering it
+	PacketID uint16
+
+	// Duplicate : Set if the broker delivers the message again because its earlier delivery was not acknowledged
+	Duplicate bool
+}
+
+// MQTTMessageHandler : Function called with each message received on a subscription
+type MQTTMessageHandler func(message *MQTTMessage)
+
+// MQTTClientInterface : MQTT communication interface of the service itself (system messages & archiving)
+type MQTTClientInterface interface {
+	Publish(topic string, payload []byte) error
+	Subscribe(topicFilter string, handler MQTTMessageHandler) error
+	Disconnect()
+}
+
+// MQTTClient : MQTT client connecting to the broker with the service credentials, enabled by mqtt config.
+// Connection is opened on first use and kept open, subscriptions are renewed on every reconnection.
+type MQTTClient struct {
+	Env               *Env
+	client            mqtt.Client
+	lock              sync.Mutex
+	subscriptions     map[string]MQTTMessageHandler
+	subscriptionsLock sync.Mutex
+}
+
+// NewMQTTClient : Return a new MQTT client reading its settings from environment config
+func NewMQTTClient(env *Env) *MQTTClient {
+	return &MQTTClient{Env: env, subscriptions: map[string]MQTTMessageHandler{}}
+}
+
+// Publish : Publish message with QoS 1, does nothing if no broker is configured
+func (mqttClient *MQTTClient) Publish(topic string, payload []byte) error {
+
+	if mqttClient.Env.Config().MQTT.BrokerURL == "" {
+		return nil
+	}
+
+	client, err := mqttClient.connect()
+
+	if err != nil {
+		return err
+	}
+
+	token := client.Publish(topic, 1, false, payload)
+
+	if !token.WaitTimeout(MQTTOperationTimeout) {
+		return fmt.Errorf("error publishing on %s : timeout", topic)
+	}
+
+	if token.Error() != nil {
+		return fmt.Errorf("error publishing on %s : %v", topic, token.Error())
+	}
+
+	return nil
+}
+
+// Subscribe : Subscribe to topic filter with QoS 1, does nothing if no broker is configured
+func (mqttClient *MQTTClient) Subscribe(topicFilter string, handler MQTTMessageHandler) error {
+
+	if mqttClient.Env.Config().MQTT.BrokerURL == "" {
+		return nil
+	}
+
+	// Kept first so that the subscription is renewed by the next reconnection if this one fails
+	mqttClient.subscriptionsLock.Lock()
+	mqttClient.subscriptions[topicFilter] = handler
+	mqttClient.subscriptionsLock.Unlock()
+
+	client, err := mqttClient.connect()
+
+	if err != nil {
+		return err
+	}
+
+	return subscribe(client, topicFilter, handler)
+}
+
+// Disconnect : Close connection to the broker if any
+func (mqttClient *MQTTClient) Disconnect() {
+
+	mqttClient.lock.Lock()
+	defer mqttClient.lock.Unlock()
+
+	if mqttClient.client != nil {
+		mqttClient.client.Disconnect(250)
+		mqttClient.client = nil
+	}
+}
+
+// connect : Return connected client, connecting to the broker if needed
+func (mqttClient *MQTTClient) connect() (mqtt.Client, error) {
+
+	mqttClient.lock.Lock()
+	defer mqttClient.lock.Unlock()
+
+	if mqttClient.client != nil && mqttClient.client.IsConnected() {
+		return mqttClient.client, nil
+	}
+
+	// Client left reconnecting on its own is replaced
+	if mqttClient.client != nil {
+		mqttClient.client.Disconnect(0)
+	}
+
+	config := mqttClient.Env.Config().MQTT.WithDefaults()
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.BrokerURL).
+		SetClientID(config.ReplicaClientID()).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectTimeout(MQTTOperationTimeout).
+		SetOnConnectHandler(mqttClient.renewSubscriptions)
+
+	// Messages published while the archiver is offline are queued by the broker until it reconnects
+	if config.Archive {
+		options.SetCleanSession(false)
+	}
+
+	client := mqtt.NewClient(options)
+
+	token := client.Connect()
+
+	if !token.WaitTimeout(MQTTOperationTimeout) {
+		return nil, fmt.Errorf("error connecting to MQTT broker %s : timeout", config.BrokerURL)
+	}
+
+	if token.Error() != nil {
+		return nil, fmt.Errorf("error connecting to MQTT broker %s : %v", config.BrokerURL, token.Error())
+	}
+
+	mqttClient.client = client
+
+	return client, nil
+}
+
+// renewSubscriptions : Subscribe again to all topic filters once connected to the broker
+func (mqttClient *MQTTClient) renewSubscriptions(client mqtt.Client) {
+
+	mqttClient.subscriptionsLock.Lock()
+	defer mqttClient.subscriptionsLock.Unlock()
+
+	for topicFilter, handler := range mqttClient.subscriptions {
+
+		err := subscribe(client, topicFilter, handler)
+
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// subscribe : Subscribe client to topic filter with QoS 1, calling handler with each received message
+func subscribe(client mqtt.Client, topicFilter string, handler MQTTMessageHandler) error {
+
+	token := client.Subscribe(topicFilter, 1, func(client mqtt.Client, message mqtt.Message) {
+		handler(&MQTTMessage{
+			Topic:     message.Topic(),
+			Payload:   message.Payload(),
+			PacketID:  message.MessageID(),
+			Duplicate: message.Duplicate(),
+		})
+	})
+
+	if !token.WaitTimeout(MQTTOperationTimeout) {
+		return fmt.Errorf("error subscribing to %s : timeout", topicFilter)
+	}
+
+	if token.Error() != nil {
+		return fmt.Errorf("error subscribing to %s : %v", topicFilter, token.Error())
+	}
+
+	return nil
+}
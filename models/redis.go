@@ -2,6 +2,7 @@ package models
 
 import (
 	fmt "fmt"
+	time "time"
 
 	redisgo "github.com/gomodule/redigo/redis"
 )
@@ -11,48 +12,189 @@ type RedisInterface interface {
 	CloseConnection() error
 	Get(key string) ([]byte, error)
 	HGet(key string, field string) ([]byte, error)
+	MGet(keys []string) ([][]byte, error)
+	HGetMulti(keys []string, field string) ([][]byte, error)
 	HSet(key string, field1 string, value1 []byte, field2 string, value2 []byte) error
+	HSetNX(key string, field string, value []byte) (bool, error)
+	HGetAll(key string) (map[string][]byte, error)
+	HDeleteIfEquals(key string, field string, value []byte) (bool, error)
+	HSetIfEquals(key string, field string, oldValue []byte, newValue []byte) (bool, error)
 	Set(key string, value []byte) error
+	SetEx(key string, value []byte, seconds int) error
+	SetNX(key string, value []byte, milliseconds int) (bool, error)
+	Expire(key string, seconds int) error
 	Exists(key string) (bool, error)
 	Delete(key string) error
+	DeleteIfEquals(key string, value []byte) (bool, error)
 	GetKeys(pattern string) ([]string, error)
 	Incr(counterKey string) (int, error)
 	Rename(oldKey string, newKey string) error
+	Transaction(commands ...RedisCommand) error
 }
 
+// RedisCommand : Redis command queued in a transaction
+type RedisCommand struct {
+	Name string
+	Args []interface{}
+}
+
+// NewRedisCommand : Return new RedisCommand struct
+func NewRedisCommand(name string, args ...interface{}) RedisCommand {
+	return RedisCommand{Name: name, Args: args}
+}
+
+var (
+	// deleteIfEqualsScript : Atomically delete a key only if it holds the expected value
+	deleteIfEqualsScript = redisgo.NewScript(1, `
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0
+	`)
+
+	// hDeleteIfEqualsScript : Atomically delete a hash field only if it holds the expected value
+	hDeleteIfEqualsScript = redisgo.NewScript(1, `
+		if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+			return redis.call("HDEL", KEYS[1], ARGV[1])
+		end
+		return 0
+	`)
+
+	// hSetIfEqualsScript : Atomically replace a hash field value only if it holds the expected value
+	hSetIfEqualsScript = redisgo.NewScript(1, `
+		if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+			redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+			return 1
+		end
+		return 0
+	`)
+)
+
 // Redis : Redis communication interface
 type Redis struct {
-	Connection redisgo.Conn
+	Pool *redisgo.Pool
+}
+
+// RedisPoolConfig : Redis connection pool settings
+type RedisPoolConfig struct {
+	// MaxIdle : Maximum number of idle connections kept in the pool
+	MaxIdle int `json:"maxIdle"`
+
+	// MaxActive : Maximum number of connections allocated by the pool at a given time (negative means no limit)
+	MaxActive int `json:"maxActive"`
+
+	// IdleTimeout : Seconds after which idle connections are closed
+	IdleTimeout int `json:"idleTimeout"`
+
+	// HealthCheckInterval : Idle seconds after which a connection is pinged before being borrowed
+	HealthCheckInterval int `json:"healthCheckInterval"`
+}
+
+// WithDefaults : Return Redis pool config with unset fields replaced by their defaults
+func (config RedisPoolConfig) WithDefaults() RedisPoolConfig {
+
+	if config.MaxIdle <= 0 {
+		config.MaxIdle = 10
+	}
+
+	if config.MaxActive == 0 {
+		config.MaxActive = 100
+	} else if config.MaxActive < 0 {
+		config.MaxActive = 0
+	}
+
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 240
+	}
+
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = 60
+	}
+
+	return config
 }
 
 // NewRedis : Return a new Redis abstraction struct
-func NewRedis(connectionURL string, password string) *Redis {
+func NewRedis(connectionURL string, password string, poolConfig RedisPoolConfig) *Redis {
+
+	pool := &redisgo.Pool{
+		MaxIdle:     poolConfig.MaxIdle,
+		MaxActive:   poolConfig.MaxActive,
+		IdleTimeout: time.Duration(poolConfig.IdleTimeout) * time.Second,
+
+		// Block callers until a connection is returned to the pool when MaxActive is reached
+		Wait: true,
+
+		// Initialize connections to the redis instance and authenticate them
+		Dial: func() (redisgo.Conn, error) {
+
+			conn, err := redisgo.DialURL(connectionURL)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if password != "" {
+
+				_, err = conn.Do("AUTH", password)
+
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+
+			return conn, nil
+		},
+
+		// Check connections that stayed idle for too long before handing them out
+		TestOnBorrow: func(conn redisgo.Conn, lastUsed time.Time) error {
+
+			if time.Since(lastUsed) < time.Duration(poolConfig.HealthCheckInterval)*time.Second {
+				return nil
+			}
+
+			_, err := conn.Do("PING")
+
+			return err
+		},
+	}
+
+	// Make sure Redis is reachable before serving requests
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
 
-	// Initialize the redis connection to a redis instance running on your local machine
-	conn, err := redisgo.DialURL(connectionURL)
 	if err != nil {
 		panic(err)
 	}
 
-	// Authenticate to Redis
-	conn.Do("AUTH", password)
-
-	// Return new MongoDB abstraction struct
+	// Return new Redis abstraction struct
 	return &Redis{
-		Connection: conn,
+		Pool: pool,
 	}
 }
 
-// CloseConnection : Close Redis Connection
+// CloseConnection : Close Redis connection pool
 func (redis *Redis) CloseConnection() error {
 
-	return redis.Connection.Close()
+	return redis.Pool.Close()
+}
+
+// do : Borrow a connection from the pool to execute a single command
+func (redis *Redis) do(commandName string, args ...interface{}) (interface{}, error) {
+
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	return conn.Do(commandName, args...)
 }
 
 func (redis *Redis) Get(key string) ([]byte, error) {
 
 	var data []byte
-	data, err := redisgo.Bytes(redis.Connection.Do("GET", key))
+	data, err := redisgo.Bytes(redis.do("GET", key))
 
 	if err != nil {
 		return nil, fmt.Errorf("error getting key %s : %v", key, err)
@@ -63,7 +205,7 @@ func (redis *Redis) Get(key string) ([]byte, error) {
 func (redis *Redis) HGet(key string, field string) ([]byte, error) {
 
 	var data []byte
-	data, err := redisgo.Bytes(redis.Connection.Do("HGET", key, field))
+	data, err := redisgo.Bytes(redis.do("HGET", key, field))
 
 	if err != nil {
 		return nil, fmt.Errorf("error getting key %s : %v", key, err)
@@ -71,18 +213,128 @@ func (redis *Redis) HGet(key string, field string) ([]byte, error) {
 	return data, nil
 }
 
+// MGet : Get values of several keys in a single round trip, missing keys have a nil value
+func (redis *Redis) MGet(keys []string) ([][]byte, error) {
+
+	if len(keys) == 0 {
+		return [][]byte{}, nil
+	}
+
+	args := make([]interface{}, len(keys))
+
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	values, err := redisgo.ByteSlices(redis.do("MGET", args...))
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting %d keys : %v", len(keys), err)
+	}
+	return values, nil
+}
+
+// HGetMulti : Get the same field of several hashes in a single pipelined round trip, missing fields have a nil value
+func (redis *Redis) HGetMulti(keys []string, field string) ([][]byte, error) {
+
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+
+		err := conn.Send("HGET", key, field)
+
+		if err != nil {
+			return nil, fmt.Errorf("error queuing field %s of key %s : %v", field, key, err)
+		}
+	}
+
+	err := conn.Flush()
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending pipeline : %v", err)
+	}
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+
+		value, err := redisgo.Bytes(conn.Receive())
+
+		if err == redisgo.ErrNil {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error getting field %s of key %s : %v", field, key, err)
+		}
+
+		values[i] = value
+	}
+	return values, nil
+}
+
 func (redis *Redis) HSet(key string, field1 string, value1 []byte, field2 string, value2 []byte) error {
 
-	_, err := redis.Connection.Do("HSET", key, field1, value1, field2, value2)
+	_, err := redis.do("HSET", key, field1, value1, field2, value2)
 	if err != nil {
 		return fmt.Errorf("error setting key %s to %s : %v", key, value1, err)
 	}
 	return nil
 }
 
+func (redis *Redis) HSetNX(key string, field string, value []byte) (bool, error) {
+
+	ok, err := redisgo.Bool(redis.do("HSETNX", key, field, value))
+	if err != nil {
+		return ok, fmt.Errorf("error setting field %s of key %s if absent : %v", field, key, err)
+	}
+	return ok, nil
+}
+
+func (redis *Redis) HGetAll(key string) (map[string][]byte, error) {
+
+	values, err := redisgo.Values(redis.do("HGETALL", key))
+	if err != nil {
+		return nil, fmt.Errorf("error getting all fields of key %s : %v", key, err)
+	}
+
+	fields := map[string][]byte{}
+	for i := 0; i+1 < len(values); i += 2 {
+		field, _ := redisgo.String(values[i], nil)
+		value, _ := redisgo.Bytes(values[i+1], nil)
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+func (redis *Redis) HDeleteIfEquals(key string, field string, value []byte) (bool, error) {
+
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	ok, err := redisgo.Bool(hDeleteIfEqualsScript.Do(conn, key, field, value))
+	if err != nil {
+		return ok, fmt.Errorf("error deleting field %s of key %s : %v", field, key, err)
+	}
+	return ok, nil
+}
+
+func (redis *Redis) HSetIfEquals(key string, field string, oldValue []byte, newValue []byte) (bool, error) {
+
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	ok, err := redisgo.Bool(hSetIfEqualsScript.Do(conn, key, field, oldValue, newValue))
+	if err != nil {
+		return ok, fmt.Errorf("error setting field %s of key %s : %v", field, key, err)
+	}
+	return ok, nil
+}
+
 func (redis *Redis) Set(key string, value []byte) error {
 
-	_, err := redis.Connection.Do("SET", key, value)
+	_, err := redis.do("SET", key, value)
 	if err != nil {
 		v := string(value)
 		if len(v) > 15 {
@@ -93,18 +345,83 @@ func (redis *Redis) Set(key string, value []byte) error {
 	return nil
 }
 
+func (redis *Redis) SetEx(key string, value []byte, seconds int) error {
+
+	_, err := redis.do("SETEX", key, seconds, value)
+	if err != nil {
+		v := string(value)
+		if len(v) > 15 {
+			v = v[0:12] + "..."
+		}
+		return fmt.Errorf("error setting key %s to %s with %ds expiry : %v", key, v, seconds, err)
+	}
+	return nil
+}
+
+func (redis *Redis) SetNX(key string, value []byte, milliseconds int) (bool, error) {
+
+	// SET NX replies nil if key already exists
+	reply, err := redis.do("SET", key, value, "NX", "PX", milliseconds)
+	if err != nil {
+		return false, fmt.Errorf("error setting key %s if absent : %v", key, err)
+	}
+	return reply != nil, nil
+}
+
+func (redis *Redis) Expire(key string, seconds int) error {
+
+	_, err := redis.do("EXPIRE", key, seconds)
+	if err != nil {
+		return fmt.Errorf("error setting %ds expiry on key %s : %v", seconds, key, err)
+	}
+	return nil
+}
+
 func (redis *Redis) Rename(oldKey string, newKey string) error {
 
-	_, err := redis.Connection.Do("RENAME", oldKey, newKey)
+	_, err := redis.do("RENAME", oldKey, newKey)
 	if err != nil {
 		return fmt.Errorf("error renaming key %s to %s : %v", oldKey, newKey, err)
 	}
 	return nil
 }
 
+func (redis *Redis) Transaction(commands ...RedisCommand) error {
+
+	// MULTI/EXEC must be sent on a single connection
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	err := conn.Send("MULTI")
+	if err != nil {
+		return fmt.Errorf("error starting transaction : %v", err)
+	}
+
+	for _, command := range commands {
+		err = conn.Send(command.Name, command.Args...)
+		if err != nil {
+			conn.Do("DISCARD")
+			return fmt.Errorf("error queuing %s in transaction : %v", command.Name, err)
+		}
+	}
+
+	replies, err := redisgo.Values(conn.Do("EXEC"))
+	if err != nil {
+		return fmt.Errorf("error executing transaction : %v", err)
+	}
+
+	// Commands failing at execution time don't abort transaction, report first of them
+	for i, reply := range replies {
+		if replyErr, isError := reply.(redisgo.Error); isError {
+			return fmt.Errorf("error executing %s in transaction : %v", commands[i].Name, replyErr)
+		}
+	}
+	return nil
+}
+
 func (redis *Redis) Exists(key string) (bool, error) {
 
-	ok, err := redisgo.Bool(redis.Connection.Do("EXISTS", key))
+	ok, err := redisgo.Bool(redis.do("EXISTS", key))
 	if err != nil {
 		return ok, fmt.Errorf("error checking if key %s exists : %v", key, err)
 	}
@@ -113,7 +430,7 @@ func (redis *Redis) Exists(key string) (bool, error) {
 
 func (redis *Redis) Delete(key string) error {
 
-	_, err := redis.Connection.Do("DEL", key)
+	_, err := redis.do("DEL", key)
 
 	if err != nil {
 		return err
@@ -122,12 +439,24 @@ func (redis *Redis) Delete(key string) error {
 	return nil
 }
 
+func (redis *Redis) DeleteIfEquals(key string, value []byte) (bool, error) {
+
+	conn := redis.Pool.Get()
+	defer conn.Close()
+
+	ok, err := redisgo.Bool(deleteIfEqualsScript.Do(conn, key, value))
+	if err != nil {
+		return ok, fmt.Errorf("error deleting key %s : %v", key, err)
+	}
+	return ok, nil
+}
+
 func (redis *Redis) GetKeys(pattern string) ([]string, error) {
 
 	iter := 0
 	keys := []string{}
 	for {
-		arr, err := redisgo.Values(redis.Connection.Do("SCAN", iter, "MATCH", pattern))
+		arr, err := redisgo.Values(redis.do("SCAN", iter, "MATCH", pattern))
 		if err != nil {
 			return keys, fmt.Errorf("error retrieving '%s' keys", pattern)
 		}
@@ -146,5 +475,5 @@ func (redis *Redis) GetKeys(pattern string) ([]string, error) {
 
 func (redis *Redis) Incr(counterKey string) (int, error) {
 
-	return redisgo.Int(redis.Connection.Do("INCR", counterKey))
+	return redisgo.Int(redis.do("INCR", counterKey))
 }
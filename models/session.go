@@ -0,0 +1,37 @@
+package models
+
+const (
+	// DefaultDeviceID : Device ID of sessions opened without device header
+	DefaultDeviceID = "default"
+)
+
+// DeviceSession : Session of one of the devices of a user
+type DeviceSession struct {
+	DeviceID    string `json:"deviceID"`
+	TokenDigest string `json:"tokenDigest"`
+	CreatedAt   int64  `json:"createdAt"`
+	LastSeenAt  int64  `json:"lastSeenAt"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+}
+
+// NewDeviceSession : Return new DeviceSession struct pointer
+func NewDeviceSession(deviceID string, tokenDigest string, createdAt int64, lastSeenAt int64) *DeviceSession {
+
+	return &DeviceSession{
+		DeviceID:    deviceID,
+		TokenDigest: tokenDigest,
+		CreatedAt:   createdAt,
+		LastSeenAt:  lastSeenAt,
+	}
+}
+
+// DeviceClientID : Return MQTT client ID of a user device.
+// Default device keeps the internal Wave user ID as client ID, other devices are suffixed with their device ID.
+func DeviceClientID(internalWaveUserID string, deviceID string) string {
+
+	if deviceID == "" || deviceID == DefaultDeviceID {
+		return internalWaveUserID
+	}
+
+	return internalWaveUserID + ":" + deviceID
+}
@@ -1,18 +1,29 @@
 package models
 
+import (
+	strings "strings"
+)
+
 const (
 	PrivateConversationTopicPath = "conversations/private/"
 	GroupConversationTopicPath   = "conversations/group/"
+
+	// GroupSystemTopicLevel : Last topic level of group topics the service publishes system messages on, in place of a member ID
+	GroupSystemTopicLevel = "system"
+
+	// ArchiverShareName : Shared subscription group of the archiving replicas, each message being delivered to a single one of them
+	ArchiverShareName = "wave-archiver"
 )
 
 // VerneMQACL : VerneMQ ACL
 type VerneMQACL struct {
-	Mountpoint   string `json:"mountpoint" bson:"mountpoint"`
-	ClientID     string `json:"clientID" bson:"client_id"`
-	Username     string `json:"username" bson:"username"`
-	Passhash     string `json:"passhash" bson:"passhash"`
-	PublishACL   []*ACL `json:"publish_acl" bson:"publish_acl"`
-	SubscribeACL []*ACL `json:"subscribe_acl" bson:"subscribe_acl"`
+	Mountpoint    string `json:"mountpoint" bson:"mountpoint"`
+	ClientID      string `json:"clientID" bson:"client_id"`
+	Username      string `json:"username" bson:"username"`
+	Passhash      string `json:"passhash" bson:"passhash"`
+	HashAlgorithm string `json:"hashAlgorithm" bson:"hash_algorithm"`
+	PublishACL    []*ACL `json:"publish_acl" bson:"publish_acl"`
+	SubscribeACL  []*ACL `json:"subscribe_acl" bson:"subscribe_acl"`
 }
 
 // MQTTAuthInfos : MQTT auth informations
@@ -30,8 +41,9 @@ type ACL struct {
 // NewVerneMQACL : Return new VerneMQACL struct pointer
 func NewVerneMQACL(clientID string, username string, password string) *VerneMQACL {
 
-	pubPrivateACL := ACL{Pattern: PrivateConversationTopicPath + clientID + "/+"}
-	subPrivateACL := ACL{Pattern: PrivateConversationTopicPath + "+/" + clientID}
+	// Topics are bound to the user (username) so that all of its devices share them
+	pubPrivateACL := ACL{Pattern: PrivateConversationTopicPath + username + "/+"}
+	subPrivateACL := ACL{Pattern: PrivateConversationTopicPath + "+/" + username}
 
 	subACLs := []*ACL{&subPrivateACL}
 	pubACLs := []*ACL{&pubPrivateACL}
@@ -46,12 +58,111 @@ func NewVerneMQACL(clientID string, username string, password string) *VerneMQAC
 	}
 }
 
-// NewMQTTAuthInfos : Return new NewMQTTAuthInfos struct pointer
-func NewMQTTAuthInfos(clientID string, token string) *MQTTAuthInfos {
+// NewServiceVerneMQACL : Return new VerneMQACL struct pointer of the service own MQTT client,
+// allowed to subscribe to all conversation topics if it archives their messages.
+// Shared subscription filters are granted as well as plain ones, whether the broker checks them with their $share prefix or not.
+func NewServiceVerneMQACL(clientID string, username string, passhash string, hashAlgorithm string, archive bool) *VerneMQACL {
+
+	pubSystemACL := ACL{Pattern: GroupConversationTopicPath + "+/" + GroupSystemTopicLevel}
+
+	subACLs := []*ACL{}
+
+	if archive {
+		subACLs = append(subACLs,
+			&ACL{Pattern: PrivateConversationTopicPath + "#"},
+			&ACL{Pattern: GroupConversationTopicPath + "#"},
+			&ACL{Pattern: SharedTopicFilter(ArchiverShareName, PrivateConversationTopicPath+"#")},
+			&ACL{Pattern: SharedTopicFilter(ArchiverShareName, GroupConversationTopicPath+"#")},
+		)
+	}
+
+	return &VerneMQACL{
+		Mountpoint:    "",
+		ClientID:      clientID,
+		Username:      username,
+		Passhash:      passhash,
+		HashAlgorithm: hashAlgorithm,
+		SubscribeACL:  subACLs,
+		PublishACL:    []*ACL{&pubSystemACL},
+	}
+}
+
+// SharedTopicFilter : Return shared subscription filter $share/{shareName}/{topicFilter}
+func SharedTopicFilter(shareName string, topicFilter string) string {
+	return "$share/" + shareName + "/" + topicFilter
+}
+
+// NewMQTTAuthInfos : Return new NewMQTTAuthInfos struct pointer for a user device
+func NewMQTTAuthInfos(internalWaveUserID string, deviceID string, token string) *MQTTAuthInfos {
 
 	return &MQTTAuthInfos{
-		ClientID: clientID,
-		Username: clientID,
+		ClientID: DeviceClientID(internalWaveUserID, deviceID),
+		Username: internalWaveUserID,
 		Password: token,
 	}
 }
+
+// CanPublish : Check if one of the publish ACL patterns grants publishing on topic
+func (verneMQACL *VerneMQACL) CanPublish(topic string) bool {
+	return verneMQACL.matchesAny(verneMQACL.PublishACL, topic)
+}
+
+// CanSubscribe : Check if one of the subscribe ACL patterns grants subscribing to topic
+func (verneMQACL *VerneMQACL) CanSubscribe(topic string) bool {
+	return verneMQACL.matchesAny(verneMQACL.SubscribeACL, topic)
+}
+
+// matchesAny : Check if topic matches at least one of the provided ACL patterns
+func (verneMQACL *VerneMQACL) matchesAny(acls []*ACL, topic string) bool {
+
+	for _, acl := range acls {
+
+		if acl == nil {
+			continue
+		}
+
+		// Substitute VerneMQ pattern placeholders with profile values
+		pattern := strings.NewReplacer(
+			"%c", verneMQACL.ClientID,
+			"%u", verneMQACL.Username,
+			"%m", verneMQACL.Mountpoint,
+		).Replace(acl.Pattern)
+
+		if MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchTopic : Check if topic (or topic filter) is covered by pattern using MQTT wildcard semantics.
+// A "+" pattern level matches exactly one topic level and a trailing "#" matches all remaining levels.
+// Wildcards of a topic filter are only covered by a wildcard as broad : "+" by "+" or "#", "#" by "#".
+func MatchTopic(pattern string, topic string) bool {
+
+	patternLevels := strings.Split(pattern, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range patternLevels {
+
+		if level == "#" {
+			return i == len(patternLevels)-1
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+
+		// A "+" level does not cover a "#" filter, which would also receive deeper topics
+		if level == "+" && topicLevels[i] == "#" {
+			return false
+		}
+	}
+
+	return len(patternLevels) == len(topicLevels)
+}
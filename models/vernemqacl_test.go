@@ -0,0 +1,49 @@
+package models
+
+import (
+	testing "testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		// Exact topics
+		{"conversations/private/u1/u2", "conversations/private/u1/u2", true},
+		{"conversations/private/u1/u2", "conversations/private/u1/u3", false},
+		{"conversations/private/u1/u2", "conversations/private/u1", false},
+		{"conversations/private/u1", "conversations/private/u1/u2", false},
+
+		// Single level wildcard
+		{"conversations/group/g1/+", "conversations/group/g1/u1", true},
+		{"conversations/group/g1/+", "conversations/group/g1/u1/more", false},
+		{"conversations/group/g1/+", "conversations/group/g2/u1", false},
+		{"conversations/private/+/u1", "conversations/private/u2/u1", true},
+
+		// Multi level wildcard
+		{"conversations/#", "conversations/group/g1/u1", true},
+		{"conversations/#", "conversations", true},
+		{"conversations/#", "other/group", false},
+
+		// Topic filter wildcards are only covered by wildcards as broad
+		{"conversations/group/g1/+", "conversations/group/g1/+", true},
+		{"conversations/group/g1/+", "conversations/group/g1/#", false},
+		{"conversations/group/+/+", "conversations/group/#", false},
+		{"conversations/group/g1/#", "conversations/group/g1/#", true},
+		{"conversations/group/g1/#", "conversations/group/g1/+", true},
+		{"conversations/group/g1/u1", "conversations/group/g1/+", false},
+		{"conversations/group/g1/u1", "conversations/group/g1/#", false},
+		{"conversations/private/+/u1", "conversations/private/+/+", false},
+		{"conversations/private/+/u1", "conversations/private/#", false},
+		{"conversations/private/+/u1", "conversations/private/+/u1", true},
+	}
+
+	for _, c := range cases {
+		if MatchTopic(c.pattern, c.topic) != c.match {
+			t.Errorf("MatchTopic(%q, %q) : expected %v", c.pattern, c.topic, c.match)
+		}
+	}
+}
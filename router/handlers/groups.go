@@ -0,0 +1,827 @@
+package router
+
+import (
+	json "encoding/json"
+	errors "errors"
+	io "io"
+	log "log"
+	http "net/http"
+	strconv "strconv"
+	time "time"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+	utils "wave-messaging-management-service/utils"
+	validation "wave-messaging-management-service/validation"
+	checkers "wave-messaging-management-service/validation/checkers"
+
+	mux "github.com/gorilla/mux"
+	gocustomhttpresponse "github.com/terryvogelsang/gocustomhttpresponse"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// DefaultGroupConversationsPageSize : Number of group conversations per page when no limit is requested
+	DefaultGroupConversationsPageSize = 20
+
+	// MaxGroupConversationsPageSize : Maximum number of group conversations per page
+	MaxGroupConversationsPageSize = 100
+
+	// CodeGroupConversationNotFound : Error code returned when a group conversation does not exist or caller is not a member
+	CodeGroupConversationNotFound = "GroupConversationNotFound"
+
+	// CodeInvalidParameters : Error code returned when query parameters are malformed
+	CodeInvalidParameters = "InvalidParameters"
+
+	// CodeForbidden : Error code returned when the role of the caller in a group conversation does not allow the operation
+	CodeForbidden = "Forbidden"
+
+	// CodeInvalidFields : Error code returned when request body fields fail validation
+	CodeInvalidFields = "InvalidFields"
+)
+
+// ListGroupConversations : Get group conversations the caller is a member of, paginated by cursor
+func ListGroupConversations(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	limit := DefaultGroupConversationsPageSize
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+
+		limit, err = strconv.Atoi(value)
+
+		if err != nil || limit <= 0 || limit > MaxGroupConversationsPageSize {
+			return errors.New(CodeInvalidParameters)
+		}
+	}
+
+	// One more group conversation is fetched to know if there is a next page
+	groupConversations, err := env.MongoDB.GetGroupConversationsOfMember(MQTTAuthInfos.Username, r.URL.Query().Get("cursor"), int64(limit+1))
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	page := models.GroupConversationsPage{}
+
+	if len(groupConversations) > limit {
+		groupConversations = groupConversations[:limit]
+		page.NextCursor = groupConversations[limit-1].GroupConversationID
+	}
+
+	page.GroupConversations, err = groupConversationsDetails(env, MQTTAuthInfos.Username, groupConversations)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(page, log, w)
+	return nil
+}
+
+// GetGroupConversation : Get a group conversation the caller is a member of
+func GetGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/id", MQTTAuthInfos.Username, groupConversation)
+}
+
+// AddGroupConversationMembers : Add users to a group conversation and grant them access to its topics
+func AddGroupConversationMembers(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	members, err := decodeGroupMembers(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	addedMembers := []string{}
+
+	for _, member := range members {
+		if !groupConversation.IsMember(member) {
+			addedMembers = append(addedMembers, member)
+		}
+	}
+
+	if len(addedMembers) > 0 {
+
+		err = env.MongoDB.AddGroupConversationMembers(groupConversation.GroupConversationID, addedMembers)
+
+		if err != nil {
+			log.Println(err)
+			return errors.New(logruswrapper.CodeInvalidJSON)
+		}
+
+		groupConversation.Members = append(groupConversation.Members, addedMembers...)
+
+		err = env.MongoDB.GrantGroupACL(groupConversation, addedMembers)
+
+		if err != nil {
+			log.Println(err)
+			return errors.New(logruswrapper.CodeInvalidJSON)
+		}
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/id/members", MQTTAuthInfos.Username, groupConversation)
+}
+
+// RemoveGroupConversationMembers : Remove users from a group conversation and revoke their access to its topics.
+// Admins may only remove members and themselves, the owner removing itself hands the group over.
+// Live MQTT clients of removed users are disconnected so that their subscriptions to the group topic end immediately.
+func RemoveGroupConversationMembers(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	members, err := decodeGroupMembers(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	callerIsOwner := groupConversation.Role(MQTTAuthInfos.Username) == models.GroupRoleOwner
+
+	removedMembers := []string{}
+
+	for _, member := range members {
+
+		if !groupConversation.IsMember(member) {
+			continue
+		}
+
+		if groupConversation.Role(member) != models.GroupRoleMember && member != MQTTAuthInfos.Username && !callerIsOwner {
+			return errors.New(CodeForbidden)
+		}
+
+		removedMembers = append(removedMembers, member)
+	}
+
+	err = removeGroupConversationMembers(env, groupConversation, removedMembers)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/id/members", MQTTAuthInfos.Username, groupConversation)
+}
+
+// UpdateGroupConversationMetadata : Update name, description, avatar, attributes and retention of a group conversation (owner & admins only).
+// Members are notified with a metadata-changed system message.
+func UpdateGroupConversationMetadata(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	reqBody := utils.GroupMetadataBody{}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	err = validateRequestBody(reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	if reqBody.Name != nil {
+		groupConversation.Name = *reqBody.Name
+	}
+
+	if reqBody.Description != nil {
+		groupConversation.Description = *reqBody.Description
+	}
+
+	if reqBody.AvatarURL != nil {
+		groupConversation.AvatarURL = *reqBody.AvatarURL
+	}
+
+	// Attributes are replaced as a whole
+	if reqBody.Attributes != nil {
+		groupConversation.Attributes = reqBody.Attributes
+	}
+
+	if reqBody.RetentionDays != nil {
+		groupConversation.RetentionDays = *reqBody.RetentionDays
+	}
+
+	groupConversation.UpdatedAt = time.Now().Unix()
+
+	err = env.MongoDB.UpdateGroupConversationMetadata(groupConversation)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	publishGroupSystemMessage(env, groupConversation, &models.GroupSystemMessage{
+		Type:               models.GroupEventMetadataChanged,
+		InternalWaveUserID: MQTTAuthInfos.Username,
+		Metadata:           groupConversation.Metadata(),
+	})
+
+	return writeGroupConversation(env, w, "/conversations/group/id", MQTTAuthInfos.Username, groupConversation)
+}
+
+// LeaveGroupConversation : Remove caller from a group conversation and revoke its access to the group topics.
+// The group is handed over if the caller owns it, and deleted if the caller was its last member.
+func LeaveGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	reqBody, err := decodeGroupSystemMessage(r)
+
+	if err != nil {
+		return err
+	}
+
+	// Published before access is revoked so that the leaving member receives it too
+	if reqBody.Notify {
+		publishGroupSystemMessage(env, groupConversation, &models.GroupSystemMessage{Type: models.GroupEventMemberLeft, InternalWaveUserID: MQTTAuthInfos.Username, Text: reqBody.Text})
+	}
+
+	err = removeGroupConversationMembers(env, groupConversation, []string{MQTTAuthInfos.Username})
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group/id/leave", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(nil, log, w)
+	return nil
+}
+
+// DeleteGroupConversation : Delete a group conversation and revoke access of all of its members to the group topics (owner only)
+func DeleteGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if groupConversation.Role(MQTTAuthInfos.Username) != models.GroupRoleOwner {
+		return errors.New(CodeForbidden)
+	}
+
+	reqBody, err := decodeGroupSystemMessage(r)
+
+	if err != nil {
+		return err
+	}
+
+	// Published before access is revoked so that all members receive it
+	if reqBody.Notify {
+		publishGroupSystemMessage(env, groupConversation, &models.GroupSystemMessage{Type: models.GroupEventDeleted, InternalWaveUserID: MQTTAuthInfos.Username, Text: reqBody.Text})
+	}
+
+	// Access is revoked first : a failed deletion leaves a group without access rather than access to a deleted group
+	err = env.MongoDB.RevokeGroupACL(groupConversation, groupConversation.Members)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	err = env.MongoDB.DeleteGroupConversation(groupConversation.GroupConversationID)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	revokeDeletedGroupInvites(env, groupConversation.GroupConversationID)
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group/id", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(nil, log, w)
+	return nil
+}
+
+// AddGroupConversationAdmins : Grant admin role to members of a group conversation (owner only)
+func AddGroupConversationAdmins(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+	return updateGroupConversationAdmins(env, w, r, true)
+}
+
+// RemoveGroupConversationAdmins : Revoke admin role of members of a group conversation (owner only)
+func RemoveGroupConversationAdmins(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+	return updateGroupConversationAdmins(env, w, r, false)
+}
+
+// TransferGroupConversationOwnership : Transfer ownership of a group conversation to one of its members (owner only).
+// The former owner becomes an admin.
+func TransferGroupConversationOwnership(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if groupConversation.Role(MQTTAuthInfos.Username) != models.GroupRoleOwner {
+		return errors.New(CodeForbidden)
+	}
+
+	reqBody := utils.GroupOwnerBody{}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	newOwners, err := auth.GetInternalWaveUserIDs(env, []string{reqBody.Owner})
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	newOwner := newOwners[0]
+
+	if !groupConversation.IsMember(newOwner) || newOwner == groupConversation.Owner {
+		return errors.New(CodeInvalidParameters)
+	}
+
+	admins := append(withoutMembers(groupConversation.Admins, []string{newOwner}), groupConversation.Owner)
+
+	err = setGroupConversationRoles(env, groupConversation, newOwner, admins)
+
+	if err != nil {
+		return err
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/id/owner", MQTTAuthInfos.Username, groupConversation)
+}
+
+// updateGroupConversationAdmins : Grant or revoke admin role of members of request body
+func updateGroupConversationAdmins(env *models.Env, w http.ResponseWriter, r *http.Request, grant bool) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if groupConversation.Role(MQTTAuthInfos.Username) != models.GroupRoleOwner {
+		return errors.New(CodeForbidden)
+	}
+
+	members, err := decodeGroupMembers(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	admins := withoutMembers(groupConversation.Admins, members)
+
+	if grant {
+
+		admins = append([]string{}, groupConversation.Admins...)
+
+		for _, member := range members {
+			if groupConversation.IsMember(member) && groupConversation.Role(member) == models.GroupRoleMember {
+				admins = append(admins, member)
+			}
+		}
+	}
+
+	err = setGroupConversationRoles(env, groupConversation, groupConversation.Owner, admins)
+
+	if err != nil {
+		return err
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/id/admins", MQTTAuthInfos.Username, groupConversation)
+}
+
+// setGroupConversationRoles : Store new owner & admins of a group conversation, provided its owner did not change in the meantime
+func setGroupConversationRoles(env *models.Env, groupConversation *models.GroupConversation, owner string, admins []string) error {
+
+	wasSet, err := env.MongoDB.SetGroupConversationRoles(groupConversation.GroupConversationID, groupConversation.Owner, owner, admins)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	// Ownership was transferred concurrently, the caller is no longer the owner
+	if !wasSet {
+		return errors.New(CodeForbidden)
+	}
+
+	groupConversation.Owner = owner
+	groupConversation.Admins = admins
+
+	return nil
+}
+
+// removeGroupConversationMembers : Revoke access of members to the group topics and remove them from the group conversation.
+// The group is handed over if its owner was removed, and deleted if no member remains.
+// Live MQTT clients of removed members are disconnected so that their subscriptions to the group topic end immediately.
+func removeGroupConversationMembers(env *models.Env, groupConversation *models.GroupConversation, removedMembers []string) error {
+
+	if len(removedMembers) == 0 {
+		return nil
+	}
+
+	// Access is revoked first : a failed removal leaves members without access rather than non-members with access
+	err := env.MongoDB.RevokeGroupACL(groupConversation, removedMembers)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation.Members = withoutMembers(groupConversation.Members, removedMembers)
+	groupConversation.Admins = withoutMembers(groupConversation.Admins, removedMembers)
+
+	if len(groupConversation.Members) == 0 {
+		err = env.MongoDB.DeleteGroupConversation(groupConversation.GroupConversationID)
+	} else {
+		err = env.MongoDB.RemoveGroupConversationMembers(groupConversation.GroupConversationID, removedMembers)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(groupConversation.Members) == 0 {
+		revokeDeletedGroupInvites(env, groupConversation.GroupConversationID)
+	}
+
+	if len(groupConversation.Members) > 0 && !groupConversation.IsMember(groupConversation.Owner) {
+
+		err = handOverGroupConversation(env, groupConversation)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, member := range removedMembers {
+
+		err = auth.DisconnectUserDevices(env, member)
+
+		if err != nil {
+			log.Printf("Disconnection of removed member %s failed : %v", member, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeGroupSystemMessage : Decode optional system message request body
+func decodeGroupSystemMessage(r *http.Request) (*utils.GroupSystemMessageBody, error) {
+
+	reqBody := utils.GroupSystemMessageBody{}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil && err != io.EOF {
+		return nil, errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	return &reqBody, nil
+}
+
+// publishGroupSystemMessage : Publish system message on the system topic of a group conversation.
+// Failures are only logged : system messages are best effort.
+func publishGroupSystemMessage(env *models.Env, groupConversation *models.GroupConversation, message *models.GroupSystemMessage) {
+
+	if env.MQTT == nil {
+		return
+	}
+
+	message.GroupConversationID = groupConversation.GroupConversationID
+	message.SentAt = time.Now().Unix()
+
+	payload, err := json.Marshal(message)
+
+	if err == nil {
+		err = env.MQTT.Publish(groupConversation.SystemTopic(), payload)
+	}
+
+	if err != nil {
+		log.Printf("System message %s of group conversation %s not published : %v", message.Type, groupConversation.GroupConversationID, err)
+	}
+}
+
+// handOverGroupConversation : Hand a group conversation over to its next owner once its owner is no longer a member
+func handOverGroupConversation(env *models.Env, groupConversation *models.GroupConversation) error {
+
+	formerOwner := groupConversation.Owner
+	newOwner := groupConversation.NextOwner()
+	admins := withoutMembers(groupConversation.Admins, []string{newOwner})
+
+	_, err := env.MongoDB.SetGroupConversationRoles(groupConversation.GroupConversationID, formerOwner, newOwner, admins)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation.Owner = newOwner
+	groupConversation.Admins = admins
+
+	if newOwner != "" {
+		log.Printf("Group conversation %s handed over from %s to %s", groupConversation.GroupConversationID, formerOwner, newOwner)
+	}
+
+	return nil
+}
+
+// authenticateRequest : Check token of request and return MQTT auth infos of its device
+func authenticateRequest(env *models.Env, r *http.Request) (*models.MQTTAuthInfos, error) {
+
+	// Retrieve token from request header
+	token := r.Header.Get("token")
+
+	// Check if token has valid format (According to regex provided by environment variable)
+	tokenHasValidFormat, err := checkers.IsTokenValid(env, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// If token is not formatted correctly, return an error response
+	if !tokenHasValidFormat {
+		return nil, errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	// Check authentication with provided endpoint
+	MQTTAuthInfos, _, _, err := auth.CheckAuthentication(env, token, r.Header.Get("device"))
+
+	// If an error occurs, token is invalid
+	if err != nil {
+		return nil, authenticationError(err)
+	}
+
+	return MQTTAuthInfos, nil
+}
+
+// getMemberGroupConversation : Get a group conversation if the user (internal Wave user ID) is one of its members.
+// Groups of other users are reported as not found so that their existence is not disclosed.
+func getMemberGroupConversation(env *models.Env, groupConversationID string, internalWaveUserID string) (*models.GroupConversation, error) {
+
+	groupConversation, err := env.MongoDB.GetGroupConversation(groupConversationID)
+
+	if err == models.ErrGroupConversationNotFound {
+		return nil, errors.New(CodeGroupConversationNotFound)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	if !groupConversation.IsMember(internalWaveUserID) {
+		return nil, errors.New(CodeGroupConversationNotFound)
+	}
+
+	return groupConversation, nil
+}
+
+// validateRequestBody : Validate request body fields against their validation tags
+func validateRequestBody(reqBody interface{}) error {
+
+	fields, err := validation.ValidateStruct(reqBody)
+
+	if err != nil {
+		log.Printf("Invalid request body fields %v : %v", fields, err)
+		return errors.New(CodeInvalidFields)
+	}
+
+	return nil
+}
+
+// decodeGroupMembers : Decode original user IDs of request body into internal Wave user IDs, unknown users and duplicates being skipped
+func decodeGroupMembers(env *models.Env, r *http.Request) ([]string, error) {
+
+	reqBody := utils.GroupMembersBody{}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return nil, errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	err = checkMappingBatchSize(env, len(reqBody.Members))
+
+	if err != nil {
+		return nil, err
+	}
+
+	internalWaveUserIDs, err := auth.GetInternalWaveUserIDs(env, reqBody.Members)
+
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	members := []string{}
+	seen := map[string]bool{}
+
+	for _, internalWaveUserID := range internalWaveUserIDs {
+		if internalWaveUserID != "" && !seen[internalWaveUserID] {
+			members = append(members, internalWaveUserID)
+			seen[internalWaveUserID] = true
+		}
+	}
+
+	return members, nil
+}
+
+// withoutMembers : Return members not part of removedMembers
+func withoutMembers(members []string, removedMembers []string) []string {
+
+	removed := map[string]bool{}
+
+	for _, member := range removedMembers {
+		removed[member] = true
+	}
+
+	remainingMembers := []string{}
+
+	for _, member := range members {
+		if !removed[member] {
+			remainingMembers = append(remainingMembers, member)
+		}
+	}
+
+	return remainingMembers
+}
+
+// writeGroupConversation : Write group conversation as seen by a member in response
+func writeGroupConversation(env *models.Env, w http.ResponseWriter, path string, internalWaveUserID string, groupConversation *models.GroupConversation) error {
+
+	details, err := groupConversationsDetails(env, internalWaveUserID, []*models.GroupConversation{groupConversation})
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", path, logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(details[0], log, w)
+	return nil
+}
+
+// groupConversationsDetails : Return group conversations as seen by a member,
+// members of all group conversations being mapped back to original user IDs in a single round trip
+func groupConversationsDetails(env *models.Env, internalWaveUserID string, groupConversations []*models.GroupConversation) ([]*models.GroupConversationDetails, error) {
+
+	// Members of each group conversation followed by its creator
+	users := []string{}
+
+	for _, groupConversation := range groupConversations {
+		users = append(append(users, groupConversation.Members...), groupConversation.Creator)
+	}
+
+	originalUserIDs, err := auth.GetOriginalUserIDs(env, users)
+
+	if err != nil {
+		return nil, err
+	}
+
+	details := []*models.GroupConversationDetails{}
+
+	for _, groupConversation := range groupConversations {
+
+		groupMembers := []models.GroupMember{}
+
+		for _, member := range groupConversation.Members {
+
+			groupMembers = append(groupMembers, models.GroupMember{
+				Mapping: models.Mapping{OriginalUserID: originalUserIDs[0], InternalWaveUserID: member, Unknown: originalUserIDs[0] == ""},
+				Role:    groupConversation.Role(member),
+			})
+			originalUserIDs = originalUserIDs[1:]
+		}
+
+		// Group conversations created before metadata were recorded have no creator
+		creator := models.Mapping{}
+
+		if groupConversation.Creator != "" {
+			creator = models.Mapping{OriginalUserID: originalUserIDs[0], InternalWaveUserID: groupConversation.Creator, Unknown: originalUserIDs[0] == ""}
+		}
+
+		originalUserIDs = originalUserIDs[1:]
+
+		attributes := groupConversation.Attributes
+
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+
+		details = append(details, &models.GroupConversationDetails{
+			GroupConversationID: groupConversation.GroupConversationID,
+			Name:                groupConversation.Name,
+			Description:         groupConversation.Description,
+			AvatarURL:           groupConversation.AvatarURL,
+			Attributes:          attributes,
+			Creator:             creator,
+			CreatedAt:           groupConversation.CreatedAt,
+			UpdatedAt:           groupConversation.UpdatedAt,
+			RetentionDays:       groupConversation.RetentionDays,
+			Members:             groupMembers,
+			PublishTopic:        groupConversation.PublishTopic(internalWaveUserID),
+			SubscribeTopic:      groupConversation.SubscribeTopic(),
+		})
+	}
+
+	return details, nil
+}
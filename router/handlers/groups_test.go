@@ -0,0 +1,190 @@
+package router
+
+import (
+	reflect "reflect"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+// testGroupUsers : Original user IDs of the users of the role tests group, logged in as internal-{originalUserID}
+var testGroupUsers = []string{"owner", "admin1", "admin2", "member1", "member2", "outsider"}
+
+// newTestRolesGroup : Store group owned by owner, administered by admin1 & admin2, with member1 & member2 as plain members
+func newTestRolesGroup(mongoDB *groupsMongoDB) {
+
+	mongoDB.groupConversations["group1"] = &models.GroupConversation{
+		GroupConversationID: "group1",
+		Owner:               "internal-owner",
+		Admins:              []string{"internal-admin1", "internal-admin2"},
+		Members:             []string{"internal-owner", "internal-admin1", "internal-admin2", "internal-member1", "internal-member2"},
+	}
+}
+
+func TestGroupConversationRoles(t *testing.T) {
+
+	for _, test := range []struct {
+		name    string
+		caller  string
+		handler Handler
+		body    string
+		code    string
+		owner   string
+		admins  []string
+		members []string
+	}{
+		// Member management
+		{"admin removes member", "admin1", RemoveGroupConversationMembers, `{"members": ["member1"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member2"}},
+		{"admin removes owner", "admin1", RemoveGroupConversationMembers, `{"members": ["owner"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin removes admin", "admin1", RemoveGroupConversationMembers, `{"members": ["admin2"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin removes member & admin", "admin1", RemoveGroupConversationMembers, `{"members": ["member1", "admin2"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin removes itself", "admin1", RemoveGroupConversationMembers, `{"members": ["admin1"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin2"}, []string{"owner", "admin2", "member1", "member2"}},
+		{"member removes member", "member1", RemoveGroupConversationMembers, `{"members": ["member2"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"owner removes admin", "owner", RemoveGroupConversationMembers, `{"members": ["admin2"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin1"}, []string{"owner", "admin1", "member1", "member2"}},
+		{"member adds member", "member1", AddGroupConversationMembers, `{"members": ["outsider"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin adds member", "admin1", AddGroupConversationMembers, `{"members": ["outsider"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2", "outsider"}},
+
+		// Admin role management
+		{"owner grants admin", "owner", AddGroupConversationAdmins, `{"members": ["member1", "outsider"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin1", "admin2", "member1"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin grants admin", "admin1", AddGroupConversationAdmins, `{"members": ["member1"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"member grants admin", "member1", AddGroupConversationAdmins, `{"members": ["member1"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"owner revokes admin", "owner", RemoveGroupConversationAdmins, `{"members": ["admin2"]}`, logruswrapper.CodeSuccess,
+			"owner", []string{"admin1"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin revokes admin", "admin1", RemoveGroupConversationAdmins, `{"members": ["admin2"]}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+
+		// Ownership transfer, the former owner becoming an admin
+		{"owner transfers to member", "owner", TransferGroupConversationOwnership, `{"owner": "member1"}`, logruswrapper.CodeSuccess,
+			"member1", []string{"admin1", "admin2", "owner"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"owner transfers to admin", "owner", TransferGroupConversationOwnership, `{"owner": "admin2"}`, logruswrapper.CodeSuccess,
+			"admin2", []string{"admin1", "owner"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"owner transfers to non-member", "owner", TransferGroupConversationOwnership, `{"owner": "outsider"}`, CodeInvalidParameters,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+		{"admin transfers", "admin1", TransferGroupConversationOwnership, `{"owner": "admin1"}`, CodeForbidden,
+			"owner", []string{"admin1", "admin2"}, []string{"owner", "admin1", "admin2", "member1", "member2"}},
+
+		// Handover to the first admin when the owner is removed or leaves
+		{"owner removes itself", "owner", RemoveGroupConversationMembers, `{"members": ["owner"]}`, logruswrapper.CodeSuccess,
+			"admin1", []string{"admin2"}, []string{"admin1", "admin2", "member1", "member2"}},
+		{"owner leaves", "owner", LeaveGroupConversation, ``, logruswrapper.CodeSuccess,
+			"admin1", []string{"admin2"}, []string{"admin1", "admin2", "member1", "member2"}},
+		{"owner removes itself & admins", "owner", RemoveGroupConversationMembers, `{"members": ["owner", "admin1", "admin2"]}`, logruswrapper.CodeSuccess,
+			"member1", []string{}, []string{"member1", "member2"}},
+	} {
+
+		mongoDB := newGroupsMongoDB()
+
+		env, closeEnv := newTestEnv(t, mongoDB)
+
+		tokens := map[string]string{}
+
+		for _, originalUserID := range testGroupUsers {
+			tokens[originalUserID], _ = loginTestUser(t, env, originalUserID)
+		}
+
+		newTestRolesGroup(mongoDB)
+
+		code := callHandler(env, test.handler, "POST", map[string]string{"groupConversationID": "group1"}, tokens[test.caller], test.body)
+
+		closeEnv()
+
+		if code != test.code {
+			t.Errorf("%s : expected %s, got %s", test.name, test.code, code)
+			continue
+		}
+
+		groupConversation := mongoDB.groupConversations["group1"]
+
+		if groupConversation.Owner != "internal-"+test.owner {
+			t.Errorf("%s : expected owner %s, got %s", test.name, test.owner, groupConversation.Owner)
+		}
+
+		if admins := internalWaveUserIDs(test.admins); !reflect.DeepEqual(groupConversation.Admins, admins) {
+			t.Errorf("%s : expected admins %v, got %v", test.name, admins, groupConversation.Admins)
+		}
+
+		if members := internalWaveUserIDs(test.members); !reflect.DeepEqual(groupConversation.Members, members) {
+			t.Errorf("%s : expected members %v, got %v", test.name, members, groupConversation.Members)
+		}
+	}
+}
+
+// Ownership transferred concurrently is never overwritten by a transfer of the former owner
+func TestTransferGroupConversationOwnershipRace(t *testing.T) {
+
+	mongoDB := newGroupsMongoDB()
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	tokens := map[string]string{}
+
+	for _, originalUserID := range testGroupUsers {
+		tokens[originalUserID], _ = loginTestUser(t, env, originalUserID)
+	}
+
+	newTestRolesGroup(mongoDB)
+
+	// Owner transfers the group to admin2 between the read & the write of its transfer to member1
+	mongoDB.beforeSetRoles = func(groupConversation *models.GroupConversation) {
+
+		mongoDB.beforeSetRoles = nil
+
+		groupConversation.Owner = "internal-admin2"
+		groupConversation.Admins = []string{"internal-admin1", "internal-owner"}
+	}
+
+	code := callHandler(env, TransferGroupConversationOwnership, "POST", map[string]string{"groupConversationID": "group1"}, tokens["owner"], `{"owner": "member1"}`)
+
+	if code != CodeForbidden {
+		t.Fatalf("expected %s, got %s", CodeForbidden, code)
+	}
+
+	groupConversation := mongoDB.groupConversations["group1"]
+
+	if groupConversation.Owner != "internal-admin2" || !reflect.DeepEqual(groupConversation.Admins, []string{"internal-admin1", "internal-owner"}) {
+		t.Fatalf("concurrent transfer overwritten : %+v", groupConversation)
+	}
+
+	// Admin role granted by a former owner is refused as well
+	mongoDB.beforeSetRoles = func(groupConversation *models.GroupConversation) {
+
+		mongoDB.beforeSetRoles = nil
+
+		groupConversation.Owner = "internal-admin1"
+		groupConversation.Admins = []string{"internal-admin2", "internal-owner"}
+	}
+
+	code = callHandler(env, AddGroupConversationAdmins, "POST", map[string]string{"groupConversationID": "group1"}, tokens["admin2"], `{"members": ["member1"]}`)
+
+	groupConversation = mongoDB.groupConversations["group1"]
+
+	if code != CodeForbidden || groupConversation.Owner != "internal-admin1" || !reflect.DeepEqual(groupConversation.Admins, []string{"internal-admin2", "internal-owner"}) {
+		t.Fatalf("expected %s without admin granted, got %s : %+v", CodeForbidden, code, groupConversation)
+	}
+}
+
+// internalWaveUserIDs : Return internal Wave user IDs test users are logged in as
+func internalWaveUserIDs(originalUserIDs []string) []string {
+
+	internalWaveUserIDs := []string{}
+
+	for _, originalUserID := range originalUserIDs {
+		internalWaveUserIDs = append(internalWaveUserIDs, "internal-"+originalUserID)
+	}
+
+	return internalWaveUserIDs
+}
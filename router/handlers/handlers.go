@@ -13,6 +13,7 @@ import (
 
 	gocustomhttpresponse "github.com/terryvogelsang/gocustomhttpresponse"
 	logruswrapper "github.com/terryvogelsang/logruswrapper"
+	singleflight "golang.org/x/sync/singleflight"
 )
 
 type (
@@ -20,6 +21,19 @@ type (
 	Handler func(env *models.Env, w http.ResponseWriter, r *http.Request) error
 )
 
+const (
+	// DefaultMaxMappingBatchSize : Maximum number of user IDs per mapping request when none is configured
+	DefaultMaxMappingBatchSize = 1000
+
+	// CodeBatchTooLarge : Error code returned when a mapping request holds more user IDs than allowed
+	CodeBatchTooLarge = "BatchTooLarge"
+)
+
+var (
+	// profileGroup : Deduplicates in-flight device profile writes by client ID
+	profileGroup singleflight.Group
+)
+
 // AddVerneMQACL : Construct and store VerneMQ ACL in database
 func AddVerneMQACL(env *models.Env, w http.ResponseWriter, r *http.Request) error {
 
@@ -40,12 +54,12 @@ func AddVerneMQACL(env *models.Env, w http.ResponseWriter, r *http.Request) erro
 	}
 
 	// Check authentication with provided endpoint
-	MQTTAuthInfos, wasCached, wasTokenUpdated, err := auth.CheckAuthentication(env, token)
+	MQTTAuthInfos, wasCached, wasTokenUpdated, err := auth.CheckAuthentication(env, token, r.Header.Get("device"))
 
 	// If an error occurs, token is invalid
 	if err != nil {
 		log.Println(err)
-		return errors.New(logruswrapper.CodeInvalidToken)
+		return authenticationError(err)
 	}
 
 	if wasTokenUpdated {
@@ -54,14 +68,22 @@ func AddVerneMQACL(env *models.Env, w http.ResponseWriter, r *http.Request) erro
 	}
 
 	if wasCached {
+
+		// Passhash computed with a previous algorithm is upgraded using the token it was computed from
+		_, err = auth.RehashIfOutdated(env, MQTTAuthInfos.ClientID, token)
+
+		if err != nil {
+			log.Println(err)
+		}
+
 		log.Println("Already cached")
 		return errors.New(logruswrapper.CodeAlreadyExists)
 	}
 
-	// Construct MQTT User ACL with MQTT Auth Infos + default ACLs
-	verneMQACL := models.NewVerneMQACL(MQTTAuthInfos.ClientID, MQTTAuthInfos.Username, MQTTAuthInfos.Password)
-
-	err = env.MongoDB.AddProfileACL(verneMQACL)
+	// Concurrent requests sharing the verification that opened the session store the device profile once
+	_, err, _ = profileGroup.Do(MQTTAuthInfos.ClientID, func() (interface{}, error) {
+		return nil, storeVerneMQACL(env, MQTTAuthInfos)
+	})
 
 	if err != nil {
 		log.Println(err)
@@ -74,6 +96,31 @@ func AddVerneMQACL(env *models.Env, w http.ResponseWriter, r *http.Request) erro
 	return nil
 }
 
+// storeVerneMQACL : Store VerneMQ ACL of a device session, or only restore its credentials if the device profile still exists
+// (e.g. if the device was evicted or revoked earlier, or if it was stored by a request sharing the same verification)
+func storeVerneMQACL(env *models.Env, MQTTAuthInfos *models.MQTTAuthInfos) error {
+
+	existingACL, err := env.MongoDB.GetProfileACL(MQTTAuthInfos.ClientID)
+
+	if err == nil && existingACL != nil {
+		return env.MongoDB.UpdatePassHash(MQTTAuthInfos.ClientID, MQTTAuthInfos.Password, auth.DetectPasswordHashAlgorithm(MQTTAuthInfos.Password))
+	}
+
+	// Construct MQTT User ACL with MQTT Auth Infos + default ACLs
+	verneMQACL := models.NewVerneMQACL(MQTTAuthInfos.ClientID, MQTTAuthInfos.Username, MQTTAuthInfos.Password)
+	verneMQACL.HashAlgorithm = auth.DetectPasswordHashAlgorithm(MQTTAuthInfos.Password)
+
+	// Additional devices of a user get the ACLs of its other devices (group conversations)
+	userACL, userACLErr := env.MongoDB.GetUserProfileACL(MQTTAuthInfos.Username)
+
+	if userACLErr == nil && userACL != nil {
+		verneMQACL.PublishACL = userACL.PublishACL
+		verneMQACL.SubscribeACL = userACL.SubscribeACL
+	}
+
+	return env.MongoDB.AddProfileACL(verneMQACL)
+}
+
 // AddGroupConversation : Add group conversation ACLs in database
 func AddGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Request) error {
 
@@ -93,11 +140,11 @@ func AddGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Reques
 	}
 
 	// Check authentication with provided endpoint
-	MQTTAuthInfos, _, _, err := auth.CheckAuthentication(env, token)
+	MQTTAuthInfos, _, _, err := auth.CheckAuthentication(env, token, r.Header.Get("device"))
 
 	// If an error occurs, token is invalid
 	if err != nil {
-		return errors.New(logruswrapper.CodeInvalidToken)
+		return authenticationError(err)
 	}
 
 	reqBody := utils.GroupConversationBody{}
@@ -107,6 +154,12 @@ func AddGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Reques
 		return errors.New(logruswrapper.CodeInvalidJSON)
 	}
 
+	err = validateRequestBody(reqBody)
+
+	if err != nil {
+		return err
+	}
+
 	// create a zero-length slice with the same underlying array
 	tmp := reqBody.Members[:0]
 
@@ -133,7 +186,7 @@ func AddGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Reques
 			}
 
 			// Remove potential duplicate of emitter user ID
-			if string(internalWaveUserID) != MQTTAuthInfos.ClientID {
+			if string(internalWaveUserID) != MQTTAuthInfos.Username {
 				tmp = append(tmp, string(internalWaveUserID))
 			}
 		}
@@ -143,11 +196,23 @@ func AddGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Reques
 	reqBody.Members = tmp
 
 	// Create new group conversation struct
-	groupConv := models.NewGroupConversation(reqBody.Name, append(reqBody.Members, MQTTAuthInfos.ClientID))
+	groupConv := models.NewGroupConversation(reqBody.Name, append(reqBody.Members, MQTTAuthInfos.Username), MQTTAuthInfos.Username)
+	groupConv.Description = reqBody.Description
+	groupConv.AvatarURL = reqBody.AvatarURL
+	groupConv.RetentionDays = reqBody.RetentionDays
+
+	if reqBody.Attributes != nil {
+		groupConv.Attributes = reqBody.Attributes
+	}
 
 	// Store conversation infos in DB
 	err = env.MongoDB.AddGroupConversation(groupConv)
 
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
 	// Update ACL in DB (Request maker get publish rights on recipient private topic)
 	err = env.MongoDB.UpdateProfilesWithGroupACL(groupConv)
 
@@ -175,6 +240,53 @@ func CustomHandle(env *models.Env, handlers ...Handler) http.Handler {
 	})
 }
 
+// authenticationError : Map authentication failure to an error code,
+// keeping authentication backend unavailability distinct from invalid tokens
+func authenticationError(err error) error {
+
+	if err == auth.ErrAuthenticationUnavailable {
+		return err
+	}
+
+	return errors.New(logruswrapper.CodeInvalidToken)
+}
+
+// checkMappingBatchSize : Check that a mapping request does not hold more user IDs than configured maximum batch size
+func checkMappingBatchSize(env *models.Env, count int) error {
+
+	maxBatchSize := env.Config().MaxMappingBatchSize
+
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxMappingBatchSize
+	}
+
+	if count > maxBatchSize {
+		log.Printf("Mapping request rejected : %d user IDs requested, at most %d allowed", count, maxBatchSize)
+		return errors.New(CodeBatchTooLarge)
+	}
+
+	return nil
+}
+
+// newMappings : Return mappings of original user IDs & internal Wave user IDs resolved in the same order, in request order.
+// Users resolved to an empty ID are flagged unknown, and skipped unless includeUnknown is set.
+func newMappings(originalUserIDs []string, internalWaveUserIDs []string, includeUnknown bool) []models.Mapping {
+
+	mappings := []models.Mapping{}
+
+	for i := range originalUserIDs {
+
+		mapping := models.Mapping{OriginalUserID: originalUserIDs[i], InternalWaveUserID: internalWaveUserIDs[i]}
+		mapping.Unknown = mapping.OriginalUserID == "" || mapping.InternalWaveUserID == ""
+
+		if !mapping.Unknown || includeUnknown {
+			mappings = append(mappings, mapping)
+		}
+	}
+
+	return mappings
+}
+
 // GetMappingForUsers : Get internal wave user IDs
 func GetMappingForUsers(env *models.Env, w http.ResponseWriter, r *http.Request) error {
 
@@ -194,11 +306,11 @@ func GetMappingForUsers(env *models.Env, w http.ResponseWriter, r *http.Request)
 	}
 
 	// Check authentication with provided endpoint
-	_, _, _, err = auth.CheckAuthentication(env, token)
+	_, _, _, err = auth.CheckAuthentication(env, token, r.Header.Get("device"))
 
 	// If an error occurs, token is invalid
 	if err != nil {
-		return errors.New(logruswrapper.CodeInvalidToken)
+		return authenticationError(err)
 	}
 
 	reqBody := utils.MappingRequestBody{}
@@ -209,21 +321,82 @@ func GetMappingForUsers(env *models.Env, w http.ResponseWriter, r *http.Request)
 		return errors.New(logruswrapper.CodeInvalidJSON)
 	}
 
-	mappings := []models.Mapping{}
+	err = checkMappingBatchSize(env, len(reqBody.UserIDs))
 
-	for _, userID := range reqBody.UserIDs {
+	if err != nil {
+		return err
+	}
 
-		internalWaveUserID, _ := env.Redis.HGet("mapping:"+userID, "internalWaveUserID")
+	// Resolve all users in a single round trip
+	internalWaveUserIDs, err := auth.GetInternalWaveUserIDs(env, reqBody.UserIDs)
 
-		fmt.Println(string(internalWaveUserID))
-		if string(internalWaveUserID) != "" {
-			mappings = append(mappings, models.Mapping{OriginalUserID: userID, InternalWaveUserID: string(internalWaveUserID)})
-		}
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
 	}
 
+	mappings := newMappings(reqBody.UserIDs, internalWaveUserIDs, reqBody.IncludeUnknown)
+
 	log := logruswrapper.NewEntry("MessagingService", "/profiles/mappings", logruswrapper.CodeSuccess)
 
 	gocustomhttpresponse.WriteResponse(mappings, log, w)
 
 	return nil
 }
+
+// GetReverseMappingForUsers : Get original user IDs of internal wave user IDs
+func GetReverseMappingForUsers(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	// Retrieve token from request header
+	token := r.Header.Get("token")
+
+	// Check if token has valid format (According to regex provided by environment variable)
+	tokenHasValidFormat, err := checkers.IsTokenValid(env, token)
+
+	if err != nil {
+		return err
+	}
+
+	// If token is not formatted correctly, return an error response
+	if !tokenHasValidFormat {
+		return errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	// Check authentication with provided endpoint
+	_, _, _, err = auth.CheckAuthentication(env, token, r.Header.Get("device"))
+
+	// If an error occurs, token is invalid
+	if err != nil {
+		return authenticationError(err)
+	}
+
+	reqBody := utils.ReverseMappingRequestBody{}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	err = checkMappingBatchSize(env, len(reqBody.InternalWaveUserIDs))
+
+	if err != nil {
+		return err
+	}
+
+	// Resolve all users in a single round trip
+	originalUserIDs, err := auth.GetOriginalUserIDs(env, reqBody.InternalWaveUserIDs)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	mappings := newMappings(originalUserIDs, reqBody.InternalWaveUserIDs, reqBody.IncludeUnknown)
+
+	log := logruswrapper.NewEntry("MessagingService", "/profiles/mappings/reverse", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(mappings, log, w)
+
+	return nil
+}
@@ -0,0 +1,519 @@
+package router
+
+import (
+	fmt "fmt"
+	http "net/http"
+	httptest "net/http/httptest"
+	os "os"
+	reflect "reflect"
+	strings "strings"
+	sync "sync"
+	atomic "sync/atomic"
+	testing "testing"
+	time "time"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+
+	mux "github.com/gorilla/mux"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// testRedisDatabase : Redis database flushed by handlers tests, other packages use their own
+	testRedisDatabase = 2
+)
+
+// groupsMongoDB : Group conversations store, failing the methods named in failing.
+// beforeSetRoles is called before roles are compared and set, to simulate concurrent updates.
+type groupsMongoDB struct {
+	models.MongoDBInterface
+
+	mutex              sync.Mutex
+	groupConversations map[string]*models.GroupConversation
+	groupACLs          map[string]map[string]bool
+	failing            map[string]bool
+	beforeSetRoles     func(groupConversation *models.GroupConversation)
+}
+
+func newGroupsMongoDB() *groupsMongoDB {
+	return &groupsMongoDB{
+		groupConversations: map[string]*models.GroupConversation{},
+		groupACLs:          map[string]map[string]bool{},
+		failing:            map[string]bool{},
+	}
+}
+
+func (mongoDB *groupsMongoDB) fail(method string) error {
+
+	if mongoDB.failing[method] {
+		return fmt.Errorf("%s failed", method)
+	}
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) AddGroupConversation(groupConversation *models.GroupConversation) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	if err := mongoDB.fail("AddGroupConversation"); err != nil {
+		return err
+	}
+
+	stored := *groupConversation
+	stored.Members = append([]string{}, groupConversation.Members...)
+	stored.Admins = append([]string{}, groupConversation.Admins...)
+	mongoDB.groupConversations[groupConversation.GroupConversationID] = &stored
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) GetGroupConversation(groupConversationID string) (*models.GroupConversation, error) {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	stored, isStored := mongoDB.groupConversations[groupConversationID]
+
+	if !isStored {
+		return nil, models.ErrGroupConversationNotFound
+	}
+
+	groupConversation := *stored
+	groupConversation.Members = append([]string{}, stored.Members...)
+	groupConversation.Admins = append([]string{}, stored.Admins...)
+
+	return &groupConversation, nil
+}
+
+func (mongoDB *groupsMongoDB) AddGroupConversationMembers(groupConversationID string, members []string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	if err := mongoDB.fail("AddGroupConversationMembers"); err != nil {
+		return err
+	}
+
+	groupConversation, isStored := mongoDB.groupConversations[groupConversationID]
+
+	if !isStored {
+		return models.ErrGroupConversationNotFound
+	}
+
+	for _, member := range members {
+		if !groupConversation.IsMember(member) {
+			groupConversation.Members = append(groupConversation.Members, member)
+		}
+	}
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) RemoveGroupConversationMembers(groupConversationID string, members []string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	groupConversation, isStored := mongoDB.groupConversations[groupConversationID]
+
+	if !isStored {
+		return models.ErrGroupConversationNotFound
+	}
+
+	// Removed members lose their admin role as well
+	groupConversation.Members = withoutMembers(groupConversation.Members, members)
+	groupConversation.Admins = withoutMembers(groupConversation.Admins, members)
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) SetGroupConversationRoles(groupConversationID string, expectedOwner string, owner string, admins []string) (bool, error) {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	groupConversation, isStored := mongoDB.groupConversations[groupConversationID]
+
+	if !isStored {
+		return false, models.ErrGroupConversationNotFound
+	}
+
+	if mongoDB.beforeSetRoles != nil {
+		mongoDB.beforeSetRoles(groupConversation)
+	}
+
+	if groupConversation.Owner != expectedOwner {
+		return false, nil
+	}
+
+	groupConversation.Owner = owner
+	groupConversation.Admins = append([]string{}, admins...)
+
+	return true, nil
+}
+
+func (mongoDB *groupsMongoDB) DeleteGroupConversation(groupConversationID string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	delete(mongoDB.groupConversations, groupConversationID)
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) UpdateProfilesWithGroupACL(groupConversation *models.GroupConversation) error {
+	return mongoDB.GrantGroupACL(groupConversation, groupConversation.Members)
+}
+
+func (mongoDB *groupsMongoDB) GrantGroupACL(groupConversation *models.GroupConversation, members []string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	if err := mongoDB.fail("GrantGroupACL"); err != nil {
+		return err
+	}
+
+	for _, member := range members {
+
+		if mongoDB.groupACLs[member] == nil {
+			mongoDB.groupACLs[member] = map[string]bool{}
+		}
+
+		mongoDB.groupACLs[member][groupConversation.GroupConversationID] = true
+	}
+
+	return nil
+}
+
+func (mongoDB *groupsMongoDB) RevokeGroupACL(groupConversation *models.GroupConversation, members []string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	for _, member := range members {
+		delete(mongoDB.groupACLs[member], groupConversation.GroupConversationID)
+	}
+
+	return nil
+}
+
+// profilesMongoDB : Device profiles store, refusing to insert a profile twice as a unique index on client IDs would
+type profilesMongoDB struct {
+	models.MongoDBInterface
+
+	mutex    sync.Mutex
+	profiles map[string]*models.VerneMQACL
+	inserts  int
+}
+
+func (mongoDB *profilesMongoDB) GetProfileACL(clientID string) (*models.VerneMQACL, error) {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	profile, isStored := mongoDB.profiles[clientID]
+
+	if !isStored {
+		return nil, fmt.Errorf("profile %s not found", clientID)
+	}
+
+	return profile, nil
+}
+
+func (mongoDB *profilesMongoDB) GetUserProfileACL(username string) (*models.VerneMQACL, error) {
+	return nil, fmt.Errorf("no profile for user %s", username)
+}
+
+func (mongoDB *profilesMongoDB) AddProfileACL(verneMQACL *models.VerneMQACL) error {
+
+	// Concurrent inserts of the same profile overlap
+	time.Sleep(20 * time.Millisecond)
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	if _, isStored := mongoDB.profiles[verneMQACL.ClientID]; isStored {
+		return fmt.Errorf("duplicate key %s", verneMQACL.ClientID)
+	}
+
+	mongoDB.profiles[verneMQACL.ClientID] = verneMQACL
+	mongoDB.inserts++
+
+	return nil
+}
+
+func (mongoDB *profilesMongoDB) UpdatePassHash(clientID string, newPasshash string, hashAlgorithm string) error {
+
+	mongoDB.mutex.Lock()
+	defer mongoDB.mutex.Unlock()
+
+	mongoDB.profiles[clientID].Passhash = newPasshash
+	mongoDB.profiles[clientID].HashAlgorithm = hashAlgorithm
+
+	return nil
+}
+
+// newTestEnv : Return environment backed by the Redis instance at WAVE_TEST_REDIS_ADDRESS and mongoDB,
+// with config accepting tokens prefixed with "token-". Test is skipped if no Redis instance is provided.
+func newTestEnv(t *testing.T, mongoDB models.MongoDBInterface) (*models.Env, func()) {
+
+	redisAddress := os.Getenv("WAVE_TEST_REDIS_ADDRESS")
+
+	if redisAddress == "" {
+		t.Skip("WAVE_TEST_REDIS_ADDRESS is not set")
+	}
+
+	redis := models.NewRedis(fmt.Sprintf("redis://%s/%d", redisAddress, testRedisDatabase), "", models.RedisPoolConfig{
+		MaxIdle:     4,
+		MaxActive:   16,
+		IdleTimeout: 60,
+	})
+
+	conn := redis.Pool.Get()
+	_, err := conn.Do("FLUSHDB")
+	conn.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFilePath := writeTestConfig(t, `{"tokenValidationRegex": "^token-", "tokenDigestSecret": "secret"}`)
+
+	env := &models.Env{Redis: redis, MongoDB: mongoDB}
+
+	return env, func() {
+		redis.CloseConnection()
+		os.Remove(configFilePath)
+	}
+}
+
+// loginTestUser : Open a session for originalUserID, return its token and internal Wave user ID
+func loginTestUser(t *testing.T, env *models.Env, originalUserID string) (string, string) {
+
+	token := "token-" + originalUserID
+	internalWaveUserID := "internal-" + originalUserID
+
+	err := env.RefreshConfig()
+
+	if err == nil {
+		err = env.Redis.Set("session:"+auth.DigestToken(env, token), []byte(internalWaveUserID))
+	}
+
+	if err == nil {
+		err = env.Redis.HSet("mapping:"+originalUserID, "internalWaveUserID", []byte(internalWaveUserID), "tokenDigest", []byte(auth.DigestToken(env, token)))
+	}
+
+	if err == nil {
+		err = env.Redis.Set("internal:"+internalWaveUserID, []byte(originalUserID))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token, internalWaveUserID
+}
+
+// callHandler : Call handler with a request of token, return the error code it responded with (Success if none)
+func callHandler(env *models.Env, handler Handler, method string, vars map[string]string, token string, body string) string {
+
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set("token", token)
+	req = mux.SetURLVars(req, vars)
+
+	err := handler(env, httptest.NewRecorder(), req)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return logruswrapper.CodeSuccess
+}
+
+func TestAddGroupConversationInsertError(t *testing.T) {
+
+	mongoDB := newGroupsMongoDB()
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	token, internalWaveUserID := loginTestUser(t, env, "user1")
+	_, memberInternalWaveUserID := loginTestUser(t, env, "user2")
+
+	mongoDB.failing["AddGroupConversation"] = true
+
+	code := callHandler(env, AddGroupConversation, "POST", nil, token, `{"name": "group", "members": ["user2"]}`)
+
+	if code == logruswrapper.CodeSuccess {
+		t.Fatalf("expected group creation to fail")
+	}
+
+	// No ACL is granted for a group that was not stored
+	if len(mongoDB.groupACLs) != 0 {
+		t.Fatalf("ACLs granted for a group that was not stored : %v", mongoDB.groupACLs)
+	}
+
+	mongoDB.failing["AddGroupConversation"] = false
+
+	code = callHandler(env, AddGroupConversation, "POST", nil, token, `{"name": "group", "members": ["user2"]}`)
+
+	if code != logruswrapper.CodeSuccess || len(mongoDB.groupConversations) != 1 {
+		t.Fatalf("expected group to be created, got %s", code)
+	}
+
+	for _, member := range []string{internalWaveUserID, memberInternalWaveUserID} {
+		if len(mongoDB.groupACLs[member]) != 1 {
+			t.Errorf("ACL of the group not granted to %s", member)
+		}
+	}
+}
+
+func TestNewMappings(t *testing.T) {
+
+	// Mappings resolved by GetMappingForUsers & GetReverseMappingForUsers, in request order
+	originalUserIDs := []string{"user3", "unknown", "user1", ""}
+	internalWaveUserIDs := []string{"internal-user3", "", "internal-user1", "internal-unknown"}
+
+	known := []models.Mapping{
+		{OriginalUserID: "user3", InternalWaveUserID: "internal-user3"},
+		{OriginalUserID: "user1", InternalWaveUserID: "internal-user1"},
+	}
+
+	if mappings := newMappings(originalUserIDs, internalWaveUserIDs, false); !reflect.DeepEqual(mappings, known) {
+		t.Errorf("expected %+v, got %+v", known, mappings)
+	}
+
+	all := []models.Mapping{
+		{OriginalUserID: "user3", InternalWaveUserID: "internal-user3"},
+		{OriginalUserID: "unknown", Unknown: true},
+		{OriginalUserID: "user1", InternalWaveUserID: "internal-user1"},
+		{InternalWaveUserID: "internal-unknown", Unknown: true},
+	}
+
+	if mappings := newMappings(originalUserIDs, internalWaveUserIDs, true); !reflect.DeepEqual(mappings, all) {
+		t.Errorf("expected %+v, got %+v", all, mappings)
+	}
+
+	if mappings := newMappings([]string{}, []string{}, true); mappings == nil || len(mappings) != 0 {
+		t.Errorf("expected empty mappings, got %+v", mappings)
+	}
+}
+
+func TestMappingBatchSize(t *testing.T) {
+
+	env, closeEnv := newTestEnv(t, newGroupsMongoDB())
+	defer closeEnv()
+
+	token, _ := loginTestUser(t, env, "user1")
+
+	userIDs := func(count int) string {
+		return `["user1"` + strings.Repeat(`, "user1"`, count-1) + `]`
+	}
+
+	for _, test := range []struct {
+		maxMappingBatchSize int
+		count               int
+		code                string
+	}{
+		{2, 2, logruswrapper.CodeSuccess},
+		{2, 3, CodeBatchTooLarge},
+		{0, DefaultMaxMappingBatchSize, logruswrapper.CodeSuccess},
+		{0, DefaultMaxMappingBatchSize + 1, CodeBatchTooLarge},
+	} {
+
+		defer os.Remove(writeTestConfig(t, fmt.Sprintf(`{"tokenValidationRegex": "^token-", "tokenDigestSecret": "secret", "maxMappingBatchSize": %d}`, test.maxMappingBatchSize)))
+
+		if err := env.RefreshConfig(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, request := range []struct {
+			handler Handler
+			body    string
+		}{
+			{GetMappingForUsers, `{"userIDs": ` + userIDs(test.count) + `, "includeUnknown": true}`},
+			{GetReverseMappingForUsers, `{"internalWaveUserIDs": ` + userIDs(test.count) + `}`},
+		} {
+			if code := callHandler(env, request.handler, "POST", nil, token, request.body); code != test.code {
+				t.Errorf("%d user IDs, at most %d : expected %s, got %s", test.count, test.maxMappingBatchSize, test.code, code)
+			}
+		}
+	}
+}
+
+// Concurrent first connections of a device are all answered with its profile, stored once
+func TestAddVerneMQACLConcurrent(t *testing.T) {
+
+	var verifications int32
+
+	release := make(chan struct{})
+
+	authEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&verifications, 1)
+		<-release
+
+		fmt.Fprint(w, `{"userID": "user1"}`)
+	}))
+
+	defer authEndpoint.Close()
+
+	mongoDB := &profilesMongoDB{profiles: map[string]*models.VerneMQACL{}}
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	defer os.Remove(writeTestConfig(t, fmt.Sprintf(`{"tokenValidationRegex": "^token-", "tokenDigestSecret": "secret", "authenticationCheckEndpoint": %q, "bcryptCost": 4}`, authEndpoint.URL)))
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+
+	codes := make([]string, callers)
+
+	for i := 0; i < callers; i++ {
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			codes[i] = callHandler(env, AddVerneMQACL, "POST", nil, "token-user1", "")
+		}(i)
+	}
+
+	// Verification is held until other requests waited for it
+	for i := 0; i < 100 && atomic.LoadInt32(&verifications) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != logruswrapper.CodeSuccess {
+			t.Errorf("request %d : expected %s, got %s", i, logruswrapper.CodeSuccess, code)
+		}
+	}
+
+	if verifications != 1 || mongoDB.inserts != 1 || len(mongoDB.profiles) != 1 {
+		t.Fatalf("expected a single verification & profile, got %d verifications, %d profiles", verifications, mongoDB.inserts)
+	}
+
+	for _, profile := range mongoDB.profiles {
+		if !auth.CheckPasswordHash(env, "token-user1", profile.Passhash, profile.HashAlgorithm) {
+			t.Errorf("profile passhash does not match token : %+v", profile)
+		}
+	}
+
+	// Later connections find the session cached
+	if code := callHandler(env, AddVerneMQACL, "POST", nil, "token-user1", ""); code != logruswrapper.CodeAlreadyExists {
+		t.Errorf("expected %s, got %s", logruswrapper.CodeAlreadyExists, code)
+	}
+}
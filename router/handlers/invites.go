@@ -0,0 +1,290 @@
+package router
+
+import (
+	json "encoding/json"
+	errors "errors"
+	fmt "fmt"
+	io "io"
+	log "log"
+	http "net/http"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+	utils "wave-messaging-management-service/utils"
+
+	mux "github.com/gorilla/mux"
+	gocustomhttpresponse "github.com/terryvogelsang/gocustomhttpresponse"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// DefaultGroupInviteTTL : Seconds after which an invite code expires when no expiry is requested
+	DefaultGroupInviteTTL = 7 * 24 * 3600
+
+	// CodeGroupInviteNotFound : Error code returned when an invite code does not exist, expired or was revoked
+	CodeGroupInviteNotFound = "GroupInviteNotFound"
+
+	// CodeGroupInviteExhausted : Error code returned when an invite code reached its maximum number of uses
+	CodeGroupInviteExhausted = "GroupInviteExhausted"
+)
+
+// CreateGroupInvite : Create an invite code allowing users to join a group conversation (owner & admins only)
+func CreateGroupInvite(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	reqBody := utils.GroupInviteBody{}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil && err != io.EOF {
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	err = validateRequestBody(reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	if reqBody.ExpiresIn == 0 {
+		reqBody.ExpiresIn = DefaultGroupInviteTTL
+	}
+
+	groupInvite, err := auth.CreateGroupInvite(env, groupConversation.GroupConversationID, MQTTAuthInfos.Username, reqBody.ExpiresIn, reqBody.MaxUses)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group/id/invites", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(groupInvite, log, w)
+	return nil
+}
+
+// ListGroupInvites : Get active invite codes of a group conversation (owner & admins only)
+func ListGroupInvites(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	groupInvites, err := auth.GetGroupInvites(env, groupConversation.GroupConversationID)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group/id/invites", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(groupInvites, log, w)
+	return nil
+}
+
+// RevokeGroupInvite : Revoke an invite code of a group conversation (owner & admins only)
+func RevokeGroupInvite(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !groupConversation.CanManage(MQTTAuthInfos.Username) {
+		return errors.New(CodeForbidden)
+	}
+
+	groupInvite, err := getGroupInvite(env, mux.Vars(r)["code"])
+
+	if err != nil {
+		return err
+	}
+
+	// Invite codes of other groups are reported as not found
+	if groupInvite.GroupConversationID != groupConversation.GroupConversationID {
+		return errors.New(CodeGroupInviteNotFound)
+	}
+
+	err = auth.RevokeGroupInvite(env, groupInvite)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/conversations/group/id/invites/code", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(nil, log, w)
+	return nil
+}
+
+// JoinGroupConversation : Add caller to the group conversation of an invite code and grant it access to the group topics.
+// Members of the group are notified with a member-joined system message. Joining a group the caller is already a member of does not count as a use.
+func JoinGroupConversation(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	groupInvite, err := getGroupInvite(env, mux.Vars(r)["code"])
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := env.MongoDB.GetGroupConversation(groupInvite.GroupConversationID)
+
+	// Invite codes left behind by a deleted group are reported as not found
+	if err == models.ErrGroupConversationNotFound {
+		return errors.New(CodeGroupInviteNotFound)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	if !groupConversation.IsMember(MQTTAuthInfos.Username) {
+
+		// Serialize joins of the same user so that concurrent ones count a single use
+		release, err := auth.AcquireLock(env, fmt.Sprintf("lock:join:%s:%s", groupConversation.GroupConversationID, MQTTAuthInfos.Username))
+
+		if err != nil {
+			log.Println(err)
+			return errors.New(logruswrapper.CodeInvalidJSON)
+		}
+
+		defer release()
+
+		// User may have joined while waiting for lock
+		groupConversation, err = env.MongoDB.GetGroupConversation(groupInvite.GroupConversationID)
+
+		if err == models.ErrGroupConversationNotFound {
+			return errors.New(CodeGroupInviteNotFound)
+		}
+
+		if err != nil {
+			log.Println(err)
+			return errors.New(logruswrapper.CodeInvalidJSON)
+		}
+	}
+
+	if !groupConversation.IsMember(MQTTAuthInfos.Username) {
+
+		joinedMembers := []string{MQTTAuthInfos.Username}
+
+		// Use is only counted once the user is a member granted the group ACLs
+		err = auth.UseGroupInvite(env, groupInvite, func() error {
+			return addGroupConversationMembers(env, groupConversation, joinedMembers)
+		})
+
+		if err == models.ErrGroupInviteNotFound {
+			return errors.New(CodeGroupInviteNotFound)
+		}
+
+		if err == models.ErrGroupInviteExhausted {
+			return errors.New(CodeGroupInviteExhausted)
+		}
+
+		if err != nil {
+			log.Println(err)
+			return errors.New(logruswrapper.CodeInvalidJSON)
+		}
+
+		publishGroupSystemMessage(env, groupConversation, &models.GroupSystemMessage{Type: models.GroupEventMemberJoined, InternalWaveUserID: MQTTAuthInfos.Username})
+	}
+
+	return writeGroupConversation(env, w, "/conversations/group/join/code", MQTTAuthInfos.Username, groupConversation)
+}
+
+// addGroupConversationMembers : Add members to a group conversation and grant them the group ACLs.
+// Members are removed again if their ACLs can't be granted, so that a failed join can be retried.
+func addGroupConversationMembers(env *models.Env, groupConversation *models.GroupConversation, members []string) error {
+
+	err := env.MongoDB.AddGroupConversationMembers(groupConversation.GroupConversationID, members)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation.Members = append(groupConversation.Members, members...)
+
+	err = env.MongoDB.GrantGroupACL(groupConversation, members)
+
+	if err != nil {
+
+		rollbackErr := env.MongoDB.RemoveGroupConversationMembers(groupConversation.GroupConversationID, members)
+
+		if rollbackErr != nil {
+			log.Printf("Members %v of group conversation %s left without ACLs : %v", members, groupConversation.GroupConversationID, rollbackErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// getGroupInvite : Get an invite code, reported as not found if it does not exist
+func getGroupInvite(env *models.Env, code string) (*models.GroupInvite, error) {
+
+	groupInvite, err := auth.GetGroupInvite(env, code)
+
+	if err == models.ErrGroupInviteNotFound {
+		return nil, errors.New(CodeGroupInviteNotFound)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	return groupInvite, nil
+}
+
+// revokeDeletedGroupInvites : Clean up invite codes of a deleted group conversation.
+// Failures are only logged : invite codes of deleted groups can no longer be used anyway.
+func revokeDeletedGroupInvites(env *models.Env, groupConversationID string) {
+
+	err := auth.RevokeGroupInvites(env, groupConversationID)
+
+	if err != nil {
+		log.Printf("Invites of deleted group conversation %s not revoked : %v", groupConversationID, err)
+	}
+}
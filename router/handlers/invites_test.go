@@ -0,0 +1,184 @@
+package router
+
+import (
+	fmt "fmt"
+	sync "sync"
+	testing "testing"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+// newTestGroupInvite : Store a group conversation owned by user1 and an invite code to join it
+func newTestGroupInvite(t *testing.T, env *models.Env, mongoDB *groupsMongoDB, maxUses int) (*models.GroupConversation, *models.GroupInvite) {
+
+	_, ownerInternalWaveUserID := loginTestUser(t, env, "user1")
+
+	groupConversation := models.NewGroupConversation("group", []string{ownerInternalWaveUserID}, ownerInternalWaveUserID)
+
+	err := mongoDB.AddGroupConversation(groupConversation)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupInvite, err := auth.CreateGroupInvite(env, groupConversation.GroupConversationID, ownerInternalWaveUserID, 3600, maxUses)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return groupConversation, groupInvite
+}
+
+// groupInviteUses : Return number of uses of an invite code, -1 if it was revoked
+func groupInviteUses(t *testing.T, env *models.Env, code string) int {
+
+	groupInvite, err := auth.GetGroupInvite(env, code)
+
+	if err == models.ErrGroupInviteNotFound {
+		return -1
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return groupInvite.Uses
+}
+
+func TestJoinGroupConversationFailureKeepsUse(t *testing.T) {
+
+	mongoDB := newGroupsMongoDB()
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	groupConversation, groupInvite := newTestGroupInvite(t, env, mongoDB, 1)
+
+	token, internalWaveUserID := loginTestUser(t, env, "user2")
+	vars := map[string]string{"code": groupInvite.Code}
+
+	for _, failingMethod := range []string{"AddGroupConversationMembers", "GrantGroupACL"} {
+
+		mongoDB.failing = map[string]bool{failingMethod: true}
+
+		code := callHandler(env, JoinGroupConversation, "POST", vars, token, "")
+
+		if code == logruswrapper.CodeSuccess {
+			t.Fatalf("expected join to fail with failing %s", failingMethod)
+		}
+
+		// User is not left a member without ACLs, use is given back
+		storedGroupConversation, _ := mongoDB.GetGroupConversation(groupConversation.GroupConversationID)
+
+		if storedGroupConversation.IsMember(internalWaveUserID) {
+			t.Fatalf("user left a member after failing %s", failingMethod)
+		}
+
+		if uses := groupInviteUses(t, env, groupInvite.Code); uses != 0 {
+			t.Fatalf("failed join counted as %d uses with failing %s", uses, failingMethod)
+		}
+	}
+
+	mongoDB.failing = map[string]bool{}
+
+	code := callHandler(env, JoinGroupConversation, "POST", vars, token, "")
+
+	if code != logruswrapper.CodeSuccess || !mongoDB.groupACLs[internalWaveUserID][groupConversation.GroupConversationID] {
+		t.Fatalf("expected join to succeed, got %s", code)
+	}
+
+	// Invite code is revoked on its last use
+	if uses := groupInviteUses(t, env, groupInvite.Code); uses != -1 {
+		t.Fatalf("exhausted invite code still active with %d uses", uses)
+	}
+}
+
+func TestJoinGroupConversationConcurrent(t *testing.T) {
+
+	mongoDB := newGroupsMongoDB()
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	groupConversation, groupInvite := newTestGroupInvite(t, env, mongoDB, 3)
+
+	vars := map[string]string{"code": groupInvite.Code}
+
+	// Concurrent joins of the same user count a single use
+	token, internalWaveUserID := loginTestUser(t, env, "user2")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if code := callHandler(env, JoinGroupConversation, "POST", vars, token, ""); code != logruswrapper.CodeSuccess {
+				t.Errorf("expected join to succeed, got %s", code)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if uses := groupInviteUses(t, env, groupInvite.Code); uses != 1 {
+		t.Fatalf("concurrent joins of a user counted as %d uses", uses)
+	}
+
+	storedGroupConversation, _ := mongoDB.GetGroupConversation(groupConversation.GroupConversationID)
+
+	if len(storedGroupConversation.Members) != 2 || !storedGroupConversation.IsMember(internalWaveUserID) {
+		t.Fatalf("unexpected members %v", storedGroupConversation.Members)
+	}
+
+	// Concurrent joins of distinct users never exceed the remaining uses
+	codes := make(chan string, 10)
+
+	for i := 0; i < 10; i++ {
+
+		token, _ := loginTestUser(t, env, fmt.Sprintf("joiner%d", i))
+
+		wg.Add(1)
+
+		go func(token string) {
+			defer wg.Done()
+
+			codes <- callHandler(env, JoinGroupConversation, "POST", vars, token, "")
+		}(token)
+	}
+
+	wg.Wait()
+	close(codes)
+
+	joins := 0
+
+	for code := range codes {
+
+		switch code {
+
+		case logruswrapper.CodeSuccess:
+			joins++
+
+		case CodeGroupInviteExhausted, CodeGroupInviteNotFound:
+
+		default:
+			t.Errorf("unexpected join error %s", code)
+		}
+	}
+
+	storedGroupConversation, _ = mongoDB.GetGroupConversation(groupConversation.GroupConversationID)
+
+	if joins != 2 || len(storedGroupConversation.Members) != 4 {
+		t.Fatalf("expected 2 more joins, got %d with members %v", joins, storedGroupConversation.Members)
+	}
+
+	if uses := groupInviteUses(t, env, groupInvite.Code); uses != -1 {
+		t.Fatalf("exhausted invite code still active with %d uses", uses)
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	errors "errors"
+	log "log"
+	http "net/http"
+	strconv "strconv"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+
+	mux "github.com/gorilla/mux"
+	gocustomhttpresponse "github.com/terryvogelsang/gocustomhttpresponse"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// DefaultMessagesPageSize : Number of messages per page when no limit is requested
+	DefaultMessagesPageSize = 50
+
+	// MaxMessagesPageSize : Maximum number of messages per page
+	MaxMessagesPageSize = 200
+)
+
+// GetPrivateConversationMessages : Get archived messages of the private conversation between the caller and a peer (internal Wave user ID)
+func GetPrivateConversationMessages(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	query, err := parseConversationMessagesQuery(r)
+
+	if err != nil {
+		return err
+	}
+
+	peerInternalWaveUserID := mux.Vars(r)["peerInternalID"]
+
+	// Peer must be a mapped user
+	originalUserIDs, err := auth.GetOriginalUserIDs(env, []string{peerInternalWaveUserID})
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	if originalUserIDs[0] == "" {
+		return auth.ErrUnknownUser
+	}
+
+	// Conversation ID is derived from the caller so that only conversations of the caller can be read
+	query.ConversationID = models.PrivateConversationID(MQTTAuthInfos.Username, peerInternalWaveUserID)
+
+	return writeConversationMessages(env, w, "/conversations/private/id/messages", query)
+}
+
+// GetGroupConversationMessages : Get archived messages of a group conversation the caller is a member of.
+// Whole history is readable by members, whether they were added or joined with an invite code after the messages were sent.
+func GetGroupConversationMessages(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	MQTTAuthInfos, err := authenticateRequest(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	query, err := parseConversationMessagesQuery(r)
+
+	if err != nil {
+		return err
+	}
+
+	groupConversation, err := getMemberGroupConversation(env, mux.Vars(r)["groupConversationID"], MQTTAuthInfos.Username)
+
+	if err != nil {
+		return err
+	}
+
+	query.ConversationID = groupConversation.GroupConversationID
+	query.Group = true
+
+	return writeConversationMessages(env, w, "/conversations/group/id/messages", query)
+}
+
+// parseConversationMessagesQuery : Read limit, before & after query parameters
+func parseConversationMessagesQuery(r *http.Request) (*models.ConversationMessagesQuery, error) {
+
+	var err error
+
+	query := &models.ConversationMessagesQuery{Limit: DefaultMessagesPageSize}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+
+		query.Limit, err = strconv.ParseInt(value, 10, 64)
+
+		if err != nil || query.Limit <= 0 || query.Limit > MaxMessagesPageSize {
+			return nil, errors.New(CodeInvalidParameters)
+		}
+	}
+
+	query.Before, err = models.ParseMessageCursor(r.URL.Query().Get("before"))
+
+	if err != nil {
+		return nil, errors.New(CodeInvalidParameters)
+	}
+
+	query.After, err = models.ParseMessageCursor(r.URL.Query().Get("after"))
+
+	if err != nil {
+		return nil, errors.New(CodeInvalidParameters)
+	}
+
+	return query, nil
+}
+
+// writeConversationMessages : Write page of archived messages matching query in response, along with the cursor of each message
+func writeConversationMessages(env *models.Env, w http.ResponseWriter, path string, query *models.ConversationMessagesQuery) error {
+
+	page, err := env.MongoDB.GetConversationMessages(query)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	for _, message := range page.Messages {
+		message.Cursor = message.MessageCursor().String()
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", path, logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(page, log, w)
+	return nil
+}
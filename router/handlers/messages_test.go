@@ -0,0 +1,244 @@
+package router
+
+import (
+	fmt "fmt"
+	httptest "net/http/httptest"
+	reflect "reflect"
+	sort "sort"
+	testing "testing"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+
+	mux "github.com/gorilla/mux"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+// messagesMongoDB : Archived messages store paging messages as the MongoDB queries do, keeping the last page it returned
+type messagesMongoDB struct {
+	*groupsMongoDB
+
+	messages []*models.ConversationMessage
+	lastPage *models.ConversationMessagesPage
+}
+
+// isBefore : Check if cursor precedes other, messages being ordered by timestamp then message ID
+func isBefore(cursor *models.MessageCursor, other *models.MessageCursor) bool {
+	return cursor.Timestamp < other.Timestamp || (cursor.Timestamp == other.Timestamp && cursor.MessageID < other.MessageID)
+}
+
+func (mongoDB *messagesMongoDB) GetConversationMessages(query *models.ConversationMessagesQuery) (*models.ConversationMessagesPage, error) {
+
+	messages := []*models.ConversationMessage{}
+
+	for _, stored := range mongoDB.messages {
+
+		isGroup := stored.GroupConversationID != ""
+
+		if stored.ConversationID != query.ConversationID || isGroup != query.Group {
+			continue
+		}
+
+		if query.Before != nil && !isBefore(stored.MessageCursor(), query.Before) {
+			continue
+		}
+
+		if query.After != nil && !isBefore(query.After, stored.MessageCursor()) {
+			continue
+		}
+
+		message := *stored
+		messages = append(messages, &message)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+
+		if query.IsAscending() {
+			return isBefore(messages[i].MessageCursor(), messages[j].MessageCursor())
+		}
+
+		return isBefore(messages[j].MessageCursor(), messages[i].MessageCursor())
+	})
+
+	if int64(len(messages)) > query.Limit+1 {
+		messages = messages[:query.Limit+1]
+	}
+
+	mongoDB.lastPage = models.NewConversationMessagesPage(query, messages)
+
+	return mongoDB.lastPage, nil
+}
+
+// getConversationMessages : Call history handler with a request of token and query parameters,
+// return the error code it responded with (Success if none)
+func getConversationMessages(env *models.Env, handler Handler, vars map[string]string, token string, parameters string) string {
+
+	req := httptest.NewRequest("GET", "/?"+parameters, nil)
+	req.Header.Set("token", token)
+	req = mux.SetURLVars(req, vars)
+
+	err := handler(env, httptest.NewRecorder(), req)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return logruswrapper.CodeSuccess
+}
+
+// pageMessageIDs : Return IDs & cursors of the messages of page
+func pageMessageIDs(page *models.ConversationMessagesPage) ([]string, []string) {
+
+	messageIDs := []string{}
+	cursors := []string{}
+
+	for _, message := range page.Messages {
+		messageIDs = append(messageIDs, message.MessageID)
+		cursors = append(cursors, message.Cursor)
+	}
+
+	return messageIDs, cursors
+}
+
+func TestGetPrivateConversationMessagesPaging(t *testing.T) {
+
+	mongoDB := &messagesMongoDB{groupsMongoDB: newGroupsMongoDB()}
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	token, internalWaveUserID := loginTestUser(t, env, "user1")
+	_, peerInternalWaveUserID := loginTestUser(t, env, "user2")
+	_, otherInternalWaveUserID := loginTestUser(t, env, "user3")
+
+	conversationID := models.PrivateConversationID(internalWaveUserID, peerInternalWaveUserID)
+
+	// Messages by date, some of them sharing their timestamp, stored out of order along with messages of another conversation
+	timestamps := []int64{1000, 1000, 2000, 3000, 3000, 3000, 4000}
+	expected := []string{}
+
+	for i, timestamp := range timestamps {
+
+		messageID := fmt.Sprintf("m%d", i)
+		expected = append(expected, messageID)
+
+		mongoDB.messages = append([]*models.ConversationMessage{
+			{MessageID: messageID, ConversationID: conversationID, Timestamp: timestamp},
+			{MessageID: messageID + "-other", ConversationID: models.PrivateConversationID(internalWaveUserID, otherInternalWaveUserID), Timestamp: timestamp},
+		}, mongoDB.messages...)
+	}
+
+	vars := map[string]string{"peerInternalID": peerInternalWaveUserID}
+
+	// Latest messages, then older ones before the first cursor of each page
+	parameters := "limit=3"
+
+	for i, pageMessageIDsExpected := range [][]string{{"m4", "m5", "m6"}, {"m1", "m2", "m3"}, {"m0"}} {
+
+		code := getConversationMessages(env, GetPrivateConversationMessages, vars, token, parameters)
+
+		if code != logruswrapper.CodeSuccess {
+			t.Fatalf("before, page %d : expected success, got %s", i, code)
+		}
+
+		messageIDs, cursors := pageMessageIDs(mongoDB.lastPage)
+
+		if !reflect.DeepEqual(messageIDs, pageMessageIDsExpected) || mongoDB.lastPage.HasMore != (i < 2) {
+			t.Fatalf("before, page %d : expected %v, got %v (hasMore %v)", i, pageMessageIDsExpected, messageIDs, mongoDB.lastPage.HasMore)
+		}
+
+		parameters = "limit=3&before=" + cursors[0]
+	}
+
+	// Newer messages after the last cursor of each page, from the first message
+	cursor := (&models.MessageCursor{Timestamp: 1000, MessageID: "m0"}).String()
+
+	for i, pageMessageIDsExpected := range [][]string{{"m1", "m2", "m3"}, {"m4", "m5", "m6"}, {}} {
+
+		code := getConversationMessages(env, GetPrivateConversationMessages, vars, token, "limit=3&after="+cursor)
+
+		if code != logruswrapper.CodeSuccess {
+			t.Fatalf("after, page %d : expected success, got %s", i, code)
+		}
+
+		messageIDs, cursors := pageMessageIDs(mongoDB.lastPage)
+
+		if !reflect.DeepEqual(messageIDs, pageMessageIDsExpected) || mongoDB.lastPage.HasMore != (i == 0) {
+			t.Fatalf("after, page %d : expected %v, got %v (hasMore %v)", i, pageMessageIDsExpected, messageIDs, mongoDB.lastPage.HasMore)
+		}
+
+		if len(cursors) > 0 {
+			cursor = cursors[len(cursors)-1]
+		}
+	}
+
+	// Latest messages between both cursors
+	code := getConversationMessages(env, GetPrivateConversationMessages, vars, token, "limit=2&after=1000_m0&before=3000_m5")
+
+	if messageIDs, _ := pageMessageIDs(mongoDB.lastPage); code != logruswrapper.CodeSuccess || !reflect.DeepEqual(messageIDs, []string{"m3", "m4"}) || !mongoDB.lastPage.HasMore {
+		t.Fatalf("between cursors : expected [m3 m4] (hasMore true), got %s %v (hasMore %v)", code, messageIDs, mongoDB.lastPage.HasMore)
+	}
+
+	// Default limit
+	code = getConversationMessages(env, GetPrivateConversationMessages, vars, token, "")
+
+	if messageIDs, _ := pageMessageIDs(mongoDB.lastPage); code != logruswrapper.CodeSuccess || !reflect.DeepEqual(messageIDs, expected) || mongoDB.lastPage.HasMore {
+		t.Fatalf("default limit : expected %v, got %s %v (hasMore %v)", expected, code, messageIDs, mongoDB.lastPage.HasMore)
+	}
+
+	for _, parameters := range []string{"limit=0", "limit=-1", "limit=201", "limit=ten", "before=m1", "before=1000_", "after=_m1"} {
+		if code := getConversationMessages(env, GetPrivateConversationMessages, vars, token, parameters); code != CodeInvalidParameters {
+			t.Errorf("%s : expected %s, got %s", parameters, CodeInvalidParameters, code)
+		}
+	}
+
+	if code := getConversationMessages(env, GetPrivateConversationMessages, map[string]string{"peerInternalID": "internal-unknown"}, token, ""); code != auth.CodeUnknownUser {
+		t.Errorf("unknown peer : expected %s, got %s", auth.CodeUnknownUser, code)
+	}
+}
+
+func TestGetGroupConversationMessages(t *testing.T) {
+
+	mongoDB := &messagesMongoDB{groupsMongoDB: newGroupsMongoDB()}
+
+	env, closeEnv := newTestEnv(t, mongoDB)
+	defer closeEnv()
+
+	token, internalWaveUserID := loginTestUser(t, env, "user1")
+	outsiderToken, _ := loginTestUser(t, env, "user2")
+
+	mongoDB.groupConversations["group1"] = &models.GroupConversation{GroupConversationID: "group1", Members: []string{internalWaveUserID}}
+
+	mongoDB.messages = []*models.ConversationMessage{
+		{MessageID: "m1", ConversationID: "group1", GroupConversationID: "group1", Timestamp: 2000},
+		{MessageID: "m0", ConversationID: "group1", GroupConversationID: "group1", Timestamp: 1000},
+		{MessageID: "m2", ConversationID: "group2", GroupConversationID: "group2", Timestamp: 3000},
+	}
+
+	vars := map[string]string{"groupConversationID": "group1"}
+
+	code := getConversationMessages(env, GetGroupConversationMessages, vars, token, "limit=1")
+
+	if messageIDs, cursors := pageMessageIDs(mongoDB.lastPage); code != logruswrapper.CodeSuccess || !reflect.DeepEqual(messageIDs, []string{"m1"}) || !mongoDB.lastPage.HasMore ||
+		!reflect.DeepEqual(cursors, []string{"2000_m1"}) {
+		t.Fatalf("expected [m1] (hasMore true), got %s %v %v (hasMore %v)", code, messageIDs, cursors, mongoDB.lastPage.HasMore)
+	}
+
+	code = getConversationMessages(env, GetGroupConversationMessages, vars, token, "limit=1&before=2000_m1")
+
+	if messageIDs, _ := pageMessageIDs(mongoDB.lastPage); code != logruswrapper.CodeSuccess || !reflect.DeepEqual(messageIDs, []string{"m0"}) || mongoDB.lastPage.HasMore {
+		t.Fatalf("expected [m0] (hasMore false), got %s %v (hasMore %v)", code, messageIDs, mongoDB.lastPage.HasMore)
+	}
+
+	// Messages of groups the caller is not a member of are not readable
+	for _, test := range []struct {
+		token string
+		vars  map[string]string
+	}{
+		{outsiderToken, vars},
+		{token, map[string]string{"groupConversationID": "group2"}},
+	} {
+		if code := getConversationMessages(env, GetGroupConversationMessages, test.vars, test.token, ""); code != CodeGroupConversationNotFound {
+			t.Errorf("%v : expected %s, got %s", test.vars, CodeGroupConversationNotFound, code)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	subtle "crypto/subtle"
+	errors "errors"
+	expvar "expvar"
+	log "log"
+	http "net/http"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+	checkers "wave-messaging-management-service/validation/checkers"
+
+	mux "github.com/gorilla/mux"
+	gocustomhttpresponse "github.com/terryvogelsang/gocustomhttpresponse"
+	logruswrapper "github.com/terryvogelsang/logruswrapper"
+)
+
+const (
+	// CodeUnauthorized : Error code returned when admin API key is missing or invalid
+	CodeUnauthorized = "Unauthorized"
+)
+
+// DeleteSession : End session of provided token (logout) and invalidate MQTT credentials of its device
+func DeleteSession(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	// Retrieve token from request header
+	token := r.Header.Get("token")
+
+	// Check if token has valid format (According to regex provided by environment variable)
+	tokenHasValidFormat, err := checkers.IsTokenValid(env, token)
+
+	if err != nil {
+		return err
+	}
+
+	// If token is not formatted correctly, return an error response
+	if !tokenHasValidFormat {
+		return errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	clientID, err := auth.RevokeSession(env, token)
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	// Token has no session
+	if clientID == "" {
+		return errors.New(logruswrapper.CodeInvalidToken)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/profiles/session", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(nil, log, w)
+	return nil
+}
+
+// RevokeUserSessions : End sessions of all devices of a user and invalidate their MQTT credentials (admin only)
+func RevokeUserSessions(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	err := checkAdminAPIKey(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	revokedClientIDs, err := auth.RevokeUser(env, mux.Vars(r)["originalUserID"])
+
+	if err == auth.ErrUnknownUser {
+		return err
+	}
+
+	if err != nil {
+		log.Println(err)
+		return errors.New(logruswrapper.CodeInvalidJSON)
+	}
+
+	log := logruswrapper.NewEntry("MessagingService", "/admin/users/revoke", logruswrapper.CodeSuccess)
+
+	gocustomhttpresponse.WriteResponse(revokedClientIDs, log, w)
+	return nil
+}
+
+// GetMetrics : Serve expvar metrics (authentication circuit breaker state & counters, memory stats) (admin only)
+func GetMetrics(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	err := checkAdminAPIKey(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	expvar.Handler().ServeHTTP(w, r)
+	return nil
+}
+
+// checkAdminAPIKey : Check that request carries configured admin API key, admin endpoints are disabled if none is configured
+func checkAdminAPIKey(env *models.Env, r *http.Request) error {
+
+	// Refresh config to get actual environment values
+	err := env.RefreshConfig()
+
+	if err != nil {
+		return err
+	}
+
+	adminAPIKey := env.Config().AdminAPIKey
+
+	if adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(adminAPIKey)) != 1 {
+		return errors.New(CodeUnauthorized)
+	}
+
+	return nil
+}
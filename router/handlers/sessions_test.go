@@ -0,0 +1,71 @@
+package router
+
+import (
+	ioutil "io/ioutil"
+	httptest "net/http/httptest"
+	os "os"
+	strings "strings"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+)
+
+// writeTestConfig : Write config file pointed to by WAVE_CONFIG_FILE_PATH, return its path
+func writeTestConfig(t *testing.T, content string) string {
+
+	file, err := ioutil.TempFile("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("WAVE_CONFIG_FILE_PATH", file.Name())
+
+	return file.Name()
+}
+
+func TestGetMetricsRequiresAdminAPIKey(t *testing.T) {
+
+	env := &models.Env{}
+	handler := CustomHandle(env, GetMetrics)
+
+	getMetrics := func(adminAPIKey string) string {
+
+		req := httptest.NewRequest("GET", "/debug/vars", nil)
+
+		if adminAPIKey != "" {
+			req.Header.Set("X-Admin-Key", adminAPIKey)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec.Body.String()
+	}
+
+	// Metrics are disabled without admin API key
+	defer os.Remove(writeTestConfig(t, `{}`))
+
+	if body := getMetrics("anything"); strings.Contains(body, "authCircuitBreaker") {
+		t.Fatalf("metrics served without configured admin API key : %s", body)
+	}
+
+	defer os.Remove(writeTestConfig(t, `{"adminAPIKey": "admin-key"}`))
+
+	for _, adminAPIKey := range []string{"", "wrong-key"} {
+		if body := getMetrics(adminAPIKey); strings.Contains(body, "authCircuitBreaker") {
+			t.Fatalf("metrics served with admin API key %q : %s", adminAPIKey, body)
+		}
+	}
+
+	if body := getMetrics("admin-key"); !strings.Contains(body, "authCircuitBreaker") {
+		t.Fatalf("metrics not served with admin API key : %s", body)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	http "net/http"
 	models "wave-messaging-management-service/models"
 	handlers "wave-messaging-management-service/router/handlers"
+	webhooks "wave-messaging-management-service/router/webhooks"
 
 	mux "github.com/gorilla/mux"
 	cors "github.com/rs/cors"
@@ -27,15 +28,49 @@ func Listen(env *models.Env) {
 	aclV1 := v1.PathPrefix("/profiles").Subrouter()
 	aclV1.Handle("", handlers.CustomHandle(env, handlers.AddVerneMQACL)).Methods("POST")
 	aclV1.Handle("/mappings", handlers.CustomHandle(env, handlers.GetMappingForUsers)).Methods("POST")
+	aclV1.Handle("/mappings/reverse", handlers.CustomHandle(env, handlers.GetReverseMappingForUsers)).Methods("POST")
+	aclV1.Handle("/session", handlers.CustomHandle(env, handlers.DeleteSession)).Methods("DELETE")
 
 	conversationsV1 := v1.PathPrefix("/conversations").Subrouter()
 	conversationsV1.Handle("/group", handlers.CustomHandle(env, handlers.AddGroupConversation)).Methods("POST")
+	conversationsV1.Handle("/group", handlers.CustomHandle(env, handlers.ListGroupConversations)).Methods("GET")
+	conversationsV1.Handle("/group/join/{code}", handlers.CustomHandle(env, handlers.JoinGroupConversation)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}", handlers.CustomHandle(env, handlers.GetGroupConversation)).Methods("GET")
+	conversationsV1.Handle("/group/{groupConversationID}", handlers.CustomHandle(env, handlers.UpdateGroupConversationMetadata)).Methods("PATCH")
+	conversationsV1.Handle("/group/{groupConversationID}", handlers.CustomHandle(env, handlers.DeleteGroupConversation)).Methods("DELETE")
+	conversationsV1.Handle("/group/{groupConversationID}/leave", handlers.CustomHandle(env, handlers.LeaveGroupConversation)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}/members", handlers.CustomHandle(env, handlers.AddGroupConversationMembers)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}/members", handlers.CustomHandle(env, handlers.RemoveGroupConversationMembers)).Methods("DELETE")
+	conversationsV1.Handle("/group/{groupConversationID}/admins", handlers.CustomHandle(env, handlers.AddGroupConversationAdmins)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}/admins", handlers.CustomHandle(env, handlers.RemoveGroupConversationAdmins)).Methods("DELETE")
+	conversationsV1.Handle("/group/{groupConversationID}/owner", handlers.CustomHandle(env, handlers.TransferGroupConversationOwnership)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}/invites", handlers.CustomHandle(env, handlers.CreateGroupInvite)).Methods("POST")
+	conversationsV1.Handle("/group/{groupConversationID}/invites", handlers.CustomHandle(env, handlers.ListGroupInvites)).Methods("GET")
+	conversationsV1.Handle("/group/{groupConversationID}/invites/{code}", handlers.CustomHandle(env, handlers.RevokeGroupInvite)).Methods("DELETE")
+	conversationsV1.Handle("/group/{groupConversationID}/messages", handlers.CustomHandle(env, handlers.GetGroupConversationMessages)).Methods("GET")
+	conversationsV1.Handle("/private/{peerInternalID}/messages", handlers.CustomHandle(env, handlers.GetPrivateConversationMessages)).Methods("GET")
+
+	// Admin Endpoints (Require admin API key)
+	adminV1 := v1.PathPrefix("/admin").Subrouter()
+	adminV1.Handle("/users/{originalUserID}/revoke", handlers.CustomHandle(env, handlers.RevokeUserSessions)).Methods("POST")
+
+	// VerneMQ Webhooks Endpoints
+	webhooksV1 := v1.PathPrefix("/webhooks").Subrouter()
+	webhooksV1.Handle("/auth_on_register", webhooks.HookHandle(env, webhooks.AuthOnRegister)).Methods("POST")
+	webhooksV1.Handle("/auth_on_register_m5", webhooks.HookHandle(env, webhooks.AuthOnRegister)).Methods("POST")
+	webhooksV1.Handle("/auth_on_subscribe", webhooks.HookHandle(env, webhooks.AuthOnSubscribe)).Methods("POST")
+	webhooksV1.Handle("/auth_on_subscribe_m5", webhooks.HookHandle(env, webhooks.AuthOnSubscribeM5)).Methods("POST")
+	webhooksV1.Handle("/auth_on_publish", webhooks.HookHandle(env, webhooks.AuthOnPublish)).Methods("POST")
+	webhooksV1.Handle("/auth_on_publish_m5", webhooks.HookHandle(env, webhooks.AuthOnPublish)).Methods("POST")
+
+	// Metrics Endpoint (Authentication circuit breaker state & counters, require admin API key)
+	r.Handle("/debug/vars", handlers.CustomHandle(env, handlers.GetMetrics)).Methods("GET")
 
 	corsHandler := cors.New(cors.Options{
 		AllowedHeaders:   []string{"X-Requested-With"},
 		AllowedOrigins:   []string{"*"},
 		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 	})
 
 	http.ListenAndServe(":"+fmt.Sprintf("%d", PORT), corsHandler.Handler(r))
@@ -0,0 +1,217 @@
+package webhooks
+
+import (
+	subtle "crypto/subtle"
+	json "encoding/json"
+	errors "errors"
+	log "log"
+	http "net/http"
+	auth "wave-messaging-management-service/auth"
+	models "wave-messaging-management-service/models"
+	utils "wave-messaging-management-service/utils"
+)
+
+const (
+	// ResultOK : Webhook result accepting the request
+	ResultOK = "ok"
+
+	// ResultNotAllowed : Webhook error rejecting the request
+	ResultNotAllowed = "not_allowed"
+
+	// RejectedQoS : QoS value telling VerneMQ to reject a single topic of a subscription
+	RejectedQoS = 128
+)
+
+var (
+	// ErrNotAllowed : Returned by hooks when the client is not allowed to perform the request
+	ErrNotAllowed = errors.New(ResultNotAllowed)
+)
+
+type (
+	// Handler : Custom type to work with HookHandle wrapper
+	Handler func(env *models.Env, w http.ResponseWriter, r *http.Request) error
+)
+
+// AuthOnRegister : VerneMQ auth_on_register hook, authenticate MQTT client against its profile
+func AuthOnRegister(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	reqBody := utils.AuthOnRegisterBody{}
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	verneMQACL, err := getAuthorizedProfile(env, reqBody.Mountpoint, reqBody.ClientID, reqBody.Username)
+
+	if err != nil {
+		return err
+	}
+
+	// Password is checked against the live profile so that a rotated token is rejected immediately.
+	// Empty passwords are never accepted, even by a profile stored with the hash of one.
+	if reqBody.Password == "" || !auth.CheckPasswordHash(env, reqBody.Password, verneMQACL.Passhash, verneMQACL.HashAlgorithm) {
+		return ErrNotAllowed
+	}
+
+	writeHookResponse(w, utils.WebhookResultBody{Result: ResultOK})
+	return nil
+}
+
+// AuthOnSubscribe : VerneMQ auth_on_subscribe hook, evaluate subscribe ACLs for each requested topic
+func AuthOnSubscribe(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	topics, err := authorizeSubscription(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	writeHookResponse(w, utils.WebhookSubscribeResultBody{Result: ResultOK, Topics: topics})
+	return nil
+}
+
+// AuthOnSubscribeM5 : VerneMQ auth_on_subscribe_m5 hook, evaluate subscribe ACLs for each requested topic
+func AuthOnSubscribeM5(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	topics, err := authorizeSubscription(env, r)
+
+	if err != nil {
+		return err
+	}
+
+	writeHookResponse(w, utils.WebhookSubscribeM5ResultBody{
+		Result:    ResultOK,
+		Modifiers: utils.WebhookSubscribeModifiers{Topics: topics},
+	})
+
+	return nil
+}
+
+// AuthOnPublish : VerneMQ auth_on_publish & auth_on_publish_m5 hooks, evaluate publish ACLs for topic
+func AuthOnPublish(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+
+	reqBody := utils.AuthOnPublishBody{}
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	verneMQACL, err := getAuthorizedProfile(env, reqBody.Mountpoint, reqBody.ClientID, reqBody.Username)
+
+	if err != nil {
+		return err
+	}
+
+	if !verneMQACL.CanPublish(reqBody.Topic) {
+		return ErrNotAllowed
+	}
+
+	writeHookResponse(w, utils.WebhookResultBody{Result: ResultOK})
+	return nil
+}
+
+// HookHandle : Custom Handlers Wrapper for VerneMQ webhooks.
+// Calls not carrying the webhook secret are rejected before reaching handlers, any error returned by a handler is sent back to VerneMQ as a rejection.
+func HookHandle(env *models.Env, handlers ...Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkWebhookSecret(env, r) {
+			log.Printf("Webhook %s from %s rejected : invalid webhook secret", r.Header.Get("vernemq-hook"), r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		for _, h := range handlers {
+			err := h(env, w, r)
+			if err != nil {
+				if err != ErrNotAllowed {
+					log.Printf("Webhook %s rejected : %v", r.Header.Get("vernemq-hook"), err)
+				}
+				writeHookResponse(w, utils.WebhookResultBody{Result: utils.WebhookErrorBody{Error: ResultNotAllowed}})
+				return
+			}
+		}
+	})
+}
+
+// checkWebhookSecret : Check that request carries configured webhook secret as basic auth password, webhooks are disabled if none is configured
+func checkWebhookSecret(env *models.Env, r *http.Request) bool {
+
+	webhookSecret := env.Config().WebhookSecret
+
+	_, password, ok := r.BasicAuth()
+
+	return ok && webhookSecret != "" && subtle.ConstantTimeCompare([]byte(password), []byte(webhookSecret)) == 1
+}
+
+// authorizeSubscription : Decode subscribe hook request and compute granted topics.
+// Rejected topics are returned with QoS 128, an error is returned if every topic was rejected.
+func authorizeSubscription(env *models.Env, r *http.Request) ([]utils.WebhookTopic, error) {
+
+	reqBody := utils.AuthOnSubscribeBody{}
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	verneMQACL, err := getAuthorizedProfile(env, reqBody.Mountpoint, reqBody.ClientID, reqBody.Username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	topics := []utils.WebhookTopic{}
+	granted := 0
+
+	for _, topic := range reqBody.Topics {
+
+		if verneMQACL.CanSubscribe(topic.Topic) {
+			granted++
+		} else {
+			topic.QoS = RejectedQoS
+		}
+
+		topics = append(topics, topic)
+	}
+
+	if granted == 0 {
+		return nil, ErrNotAllowed
+	}
+
+	return topics, nil
+}
+
+// getAuthorizedProfile : Get VerneMQ profile matching client ID and check username & mountpoint against it
+func getAuthorizedProfile(env *models.Env, mountpoint string, clientID string, username string) (*models.VerneMQACL, error) {
+
+	if clientID == "" {
+		return nil, ErrNotAllowed
+	}
+
+	verneMQACL, err := env.MongoDB.GetProfileACL(clientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if verneMQACL.Username != username || verneMQACL.Mountpoint != mountpoint {
+		return nil, ErrNotAllowed
+	}
+
+	return verneMQACL, nil
+}
+
+// writeHookResponse : Write JSON response expected by VerneMQ webhooks
+func writeHookResponse(w http.ResponseWriter, body interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(body)
+
+	if err != nil {
+		log.Println(err)
+	}
+}
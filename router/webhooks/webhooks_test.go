@@ -0,0 +1,306 @@
+package webhooks
+
+import (
+	errors "errors"
+	http "net/http"
+	httptest "net/http/httptest"
+	strings "strings"
+	testing "testing"
+	models "wave-messaging-management-service/models"
+
+	bcrypt "golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testWebhookSecret = "webhook-secret"
+	testToken         = "token-of-user"
+	testClientID      = "user1:device1"
+	testUsername      = "user1"
+
+	rejected = `{"result":{"error":"not_allowed"}}`
+)
+
+// profilesMongoDB : MongoDB interface serving a single VerneMQ profile
+type profilesMongoDB struct {
+	models.MongoDBInterface
+	verneMQACL *models.VerneMQACL
+}
+
+func (mongoDB *profilesMongoDB) GetProfileACL(clientID string) (*models.VerneMQACL, error) {
+
+	if clientID != mongoDB.verneMQACL.ClientID {
+		return nil, errors.New("mongo: no documents in result")
+	}
+
+	return mongoDB.verneMQACL, nil
+}
+
+func newTestEnv(t *testing.T) *models.Env {
+
+	passhash, err := bcrypt.GenerateFromPassword([]byte(testToken), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verneMQACL := models.NewVerneMQACL(testClientID, testUsername, string(passhash))
+	verneMQACL.SubscribeACL = append(verneMQACL.SubscribeACL, &models.ACL{Pattern: models.GroupConversationTopicPath + "group1/+"})
+	verneMQACL.PublishACL = append(verneMQACL.PublishACL, &models.ACL{Pattern: models.GroupConversationTopicPath + "group1/" + testUsername})
+
+	env := &models.Env{MongoDB: &profilesMongoDB{verneMQACL: verneMQACL}}
+	env.SetConfig(&models.Config{WebhookSecret: testWebhookSecret})
+
+	return env
+}
+
+// callHook : Send body to hook as VerneMQ does, return response status & body
+func callHook(env *models.Env, hook string, handler Handler, secret string, body string) (int, string) {
+
+	req := httptest.NewRequest("POST", "/v1/webhooks/"+hook, strings.NewReader(body))
+	req.Header.Set("vernemq-hook", hook)
+	req.Header.Set("Content-Type", "application/json")
+
+	if secret != "" {
+		req.SetBasicAuth("vernemq", secret)
+	}
+
+	rec := httptest.NewRecorder()
+	HookHandle(env, handler).ServeHTTP(rec, req)
+
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+// Payloads below follow the requests sent by VerneMQ webhooks, see https://docs.vernemq.com/plugindevelopment/webhookplugins
+
+func TestAuthOnRegister(t *testing.T) {
+
+	env := newTestEnv(t)
+
+	cases := []struct {
+		name     string
+		hook     string
+		body     string
+		expected string
+	}{
+		{
+			"valid token", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"token-of-user","mountpoint":"","client_id":"user1:device1","clean_session":false}`,
+			`{"result":"ok"}`,
+		},
+		{
+			"valid token m5", "auth_on_register_m5",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"mountpoint":"","client_id":"user1:device1","username":"user1","password":"token-of-user","clean_start":true,"properties":{}}`,
+			`{"result":"ok"}`,
+		},
+		{
+			"wrong token", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"other-token","mountpoint":"","client_id":"user1:device1","clean_session":false}`,
+			rejected,
+		},
+		{
+			"username of another user", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user2","password":"token-of-user","mountpoint":"","client_id":"user1:device1","clean_session":false}`,
+			rejected,
+		},
+		{
+			"other mountpoint", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"token-of-user","mountpoint":"other","client_id":"user1:device1","clean_session":false}`,
+			rejected,
+		},
+		{
+			"unknown client ID", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"token-of-user","mountpoint":"","client_id":"user1:device2","clean_session":false}`,
+			rejected,
+		},
+		{
+			"missing client ID", "auth_on_register",
+			`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"token-of-user","mountpoint":"","clean_session":false}`,
+			rejected,
+		},
+		{
+			"malformed body", "auth_on_register",
+			`{"peer_addr":`,
+			rejected,
+		},
+	}
+
+	for _, c := range cases {
+
+		status, body := callHook(env, c.hook, AuthOnRegister, testWebhookSecret, c.body)
+
+		if status != http.StatusOK || body != c.expected {
+			t.Errorf("%s : expected %s, got %d %s", c.name, c.expected, status, body)
+		}
+	}
+}
+
+func TestAuthOnSubscribe(t *testing.T) {
+
+	env := newTestEnv(t)
+
+	cases := []struct {
+		name     string
+		hook     string
+		handler  Handler
+		body     string
+		expected string
+	}{
+		{
+			"granted topics", "auth_on_subscribe", AuthOnSubscribe,
+			`{"client_id":"user1:device1","mountpoint":"","username":"user1","topics":[{"topic":"conversations/private/+/user1","qos":1},{"topic":"conversations/group/group1/+","qos":2}]}`,
+			`{"result":"ok","topics":[{"topic":"conversations/private/+/user1","qos":1},{"topic":"conversations/group/group1/+","qos":2}]}`,
+		},
+		{
+			"partially granted topics", "auth_on_subscribe", AuthOnSubscribe,
+			`{"client_id":"user1:device1","mountpoint":"","username":"user1","topics":[{"topic":"conversations/private/+/user2","qos":1},{"topic":"conversations/group/group1/+","qos":1}]}`,
+			`{"result":"ok","topics":[{"topic":"conversations/private/+/user2","qos":128},{"topic":"conversations/group/group1/+","qos":1}]}`,
+		},
+		{
+			"wildcard escalation", "auth_on_subscribe", AuthOnSubscribe,
+			`{"client_id":"user1:device1","mountpoint":"","username":"user1","topics":[{"topic":"conversations/group/group1/#","qos":1},{"topic":"conversations/#","qos":1}]}`,
+			rejected,
+		},
+		{
+			"no granted topic", "auth_on_subscribe", AuthOnSubscribe,
+			`{"client_id":"user1:device1","mountpoint":"","username":"user1","topics":[{"topic":"conversations/group/group2/+","qos":1}]}`,
+			rejected,
+		},
+		{
+			"username of another user", "auth_on_subscribe", AuthOnSubscribe,
+			`{"client_id":"user1:device1","mountpoint":"","username":"user2","topics":[{"topic":"conversations/private/+/user2","qos":1}]}`,
+			rejected,
+		},
+		{
+			"granted topics m5", "auth_on_subscribe_m5", AuthOnSubscribeM5,
+			`{"username":"user1","mountpoint":"","client_id":"user1:device1","topics":[{"topic":"conversations/group/group1/+","qos":1}],"properties":{}}`,
+			`{"result":"ok","modifiers":{"topics":[{"topic":"conversations/group/group1/+","qos":1}]}}`,
+		},
+		{
+			"partially granted topics m5", "auth_on_subscribe_m5", AuthOnSubscribeM5,
+			`{"username":"user1","mountpoint":"","client_id":"user1:device1","topics":[{"topic":"conversations/group/group1/+","qos":1},{"topic":"conversations/group/+/+","qos":0}],"properties":{}}`,
+			`{"result":"ok","modifiers":{"topics":[{"topic":"conversations/group/group1/+","qos":1},{"topic":"conversations/group/+/+","qos":128}]}}`,
+		},
+		{
+			"no granted topic m5", "auth_on_subscribe_m5", AuthOnSubscribeM5,
+			`{"username":"user1","mountpoint":"","client_id":"user1:device1","topics":[{"topic":"conversations/group/group1/#","qos":1}],"properties":{}}`,
+			rejected,
+		},
+	}
+
+	for _, c := range cases {
+
+		status, body := callHook(env, c.hook, c.handler, testWebhookSecret, c.body)
+
+		if status != http.StatusOK || body != c.expected {
+			t.Errorf("%s : expected %s, got %d %s", c.name, c.expected, status, body)
+		}
+	}
+}
+
+func TestAuthOnPublish(t *testing.T) {
+
+	env := newTestEnv(t)
+
+	cases := []struct {
+		name     string
+		hook     string
+		body     string
+		expected string
+	}{
+		{
+			"private topic of user", "auth_on_publish",
+			`{"username":"user1","client_id":"user1:device1","mountpoint":"","qos":1,"topic":"conversations/private/user1/user2","payload":"aGVsbG8=","retain":false}`,
+			`{"result":"ok"}`,
+		},
+		{
+			"private topic of another user", "auth_on_publish",
+			`{"username":"user1","client_id":"user1:device1","mountpoint":"","qos":1,"topic":"conversations/private/user2/user1","payload":"aGVsbG8=","retain":false}`,
+			rejected,
+		},
+		{
+			"group topic of user", "auth_on_publish_m5",
+			`{"username":"user1","mountpoint":"","client_id":"user1:device1","qos":1,"topic":"conversations/group/group1/user1","payload":"aGVsbG8=","retain":false,"properties":{}}`,
+			`{"result":"ok"}`,
+		},
+		{
+			"system topic of group", "auth_on_publish_m5",
+			`{"username":"user1","mountpoint":"","client_id":"user1:device1","qos":1,"topic":"conversations/group/group1/system","payload":"aGVsbG8=","retain":false,"properties":{}}`,
+			rejected,
+		},
+		{
+			"unknown client ID", "auth_on_publish",
+			`{"username":"user1","client_id":"user1:device2","mountpoint":"","qos":1,"topic":"conversations/private/user1/user2","payload":"aGVsbG8=","retain":false}`,
+			rejected,
+		},
+	}
+
+	for _, c := range cases {
+
+		status, body := callHook(env, c.hook, AuthOnPublish, testWebhookSecret, c.body)
+
+		if status != http.StatusOK || body != c.expected {
+			t.Errorf("%s : expected %s, got %d %s", c.name, c.expected, status, body)
+		}
+	}
+}
+
+func TestHookHandleWebhookSecret(t *testing.T) {
+
+	body := `{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"token-of-user","mountpoint":"","client_id":"user1:device1","clean_session":false}`
+
+	env := newTestEnv(t)
+
+	called := false
+
+	handler := func(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	}
+
+	for _, secret := range []string{"", "wrong-secret"} {
+
+		status, _ := callHook(env, "auth_on_register", handler, secret, body)
+
+		if status != http.StatusUnauthorized || called {
+			t.Errorf("secret %q : expected rejection before handler, got %d", secret, status)
+		}
+	}
+
+	// Webhooks are disabled without configured secret
+	env.SetConfig(&models.Config{})
+
+	status, _ := callHook(env, "auth_on_register", handler, "", body)
+
+	if status != http.StatusUnauthorized || called {
+		t.Errorf("no configured secret : expected rejection before handler, got %d", status)
+	}
+
+	env.SetConfig(&models.Config{WebhookSecret: testWebhookSecret})
+
+	status, _ = callHook(env, "auth_on_register", handler, testWebhookSecret, body)
+
+	if status != http.StatusOK || !called {
+		t.Errorf("valid secret : expected handler call, got %d", status)
+	}
+}
+
+func TestAuthOnRegisterEmptyPassword(t *testing.T) {
+
+	env := newTestEnv(t)
+
+	// Profile stored with the hash of an empty password
+	passhash, err := bcrypt.GenerateFromPassword([]byte(""), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.MongoDB.(*profilesMongoDB).verneMQACL.Passhash = string(passhash)
+
+	status, body := callHook(env, "auth_on_register", AuthOnRegister, testWebhookSecret,
+		`{"peer_addr":"127.0.0.1","peer_port":8888,"username":"user1","password":"","mountpoint":"","client_id":"user1:device1","clean_session":false}`)
+
+	if status != http.StatusOK || body != rejected {
+		t.Fatalf("expected empty password to be rejected, got %d %s", status, body)
+	}
+}
@@ -6,18 +6,65 @@ import (
 
 // MappingRequestBody : Request Body on Mapping Request
 type MappingRequestBody struct {
-	UserIDs []string `json:"userIDs"`
+	UserIDs        []string `json:"userIDs"`
+	IncludeUnknown bool     `json:"includeUnknown"`
+}
+
+// ReverseMappingRequestBody : Request Body on Reverse Mapping Request
+type ReverseMappingRequestBody struct {
+	InternalWaveUserIDs []string `json:"internalWaveUserIDs"`
+	IncludeUnknown      bool     `json:"includeUnknown"`
 }
 
 // GroupConversationBody : Request Body on Group Creation
 type GroupConversationBody struct {
+	Members       []string          `json:"members"`
+	Name          string            `json:"name" validate:"max=128"`
+	Description   string            `json:"description" validate:"max=1024"`
+	AvatarURL     string            `json:"avatarURL" validate:"omitempty,url,max=2048"`
+	Attributes    map[string]string `json:"attributes" validate:"max=32,dive,keys,min=1,max=64,excludesall=$.,endkeys,max=1024"`
+	RetentionDays int               `json:"retentionDays" validate:"min=0,max=36500"`
+}
+
+// GroupMetadataBody : Request Body on Group Metadata Update, omitted fields are left unchanged
+type GroupMetadataBody struct {
+	Name          *string           `json:"name" validate:"omitempty,min=1,max=128"`
+	Description   *string           `json:"description" validate:"omitempty,max=1024"`
+	AvatarURL     *string           `json:"avatarURL" validate:"omitempty,len=0|url,max=2048"`
+	Attributes    map[string]string `json:"attributes" validate:"omitempty,max=32,dive,keys,min=1,max=64,excludesall=$.,endkeys,max=1024"`
+	RetentionDays *int              `json:"retentionDays" validate:"omitempty,min=0,max=36500"`
+}
+
+// GroupMembersBody : Request Body on Group Members & Admins Addition & Removal
+type GroupMembersBody struct {
 	Members []string `json:"members"`
-	Name    string   `json:"name"`
 }
 
-// AuthCheckerBody : Response Body from Auth Checker
-type AuthCheckerBody struct {
-	OriginalUserID string `json:"userID" bson:"userID"`
+// GroupOwnerBody : Request Body on Group Ownership Transfer
+type GroupOwnerBody struct {
+	Owner string `json:"owner"`
+}
+
+// GroupInviteBody : Optional Request Body on Group Invite Creation
+type GroupInviteBody struct {
+	ExpiresIn int `json:"expiresIn" validate:"omitempty,min=60,max=2592000"`
+	MaxUses   int `json:"maxUses" validate:"omitempty,min=1,max=10000"`
+}
+
+// GroupSystemMessageBody : Optional Request Body on Group Leave & Deletion
+type GroupSystemMessageBody struct {
+	Notify bool   `json:"notify"`
+	Text   string `json:"text"`
+}
+
+// IntrospectionResponseBody : Response Body from OAuth2 token introspection endpoint (RFC 7662)
+type IntrospectionResponseBody struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"`
+	ExpiresAt int64  `json:"exp"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
 }
 
 // PanicOnError : Prints the error & exits the program
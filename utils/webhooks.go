@@ -0,0 +1,68 @@
+package utils
+
+// WebhookTopic : Topic entry as sent and expected by VerneMQ subscribe webhooks
+type WebhookTopic struct {
+	Topic string `json:"topic"`
+	QoS   int    `json:"qos"`
+}
+
+// AuthOnRegisterBody : Request Body on VerneMQ auth_on_register & auth_on_register_m5 hooks
+type AuthOnRegisterBody struct {
+	PeerAddr     string                 `json:"peer_addr"`
+	PeerPort     int                    `json:"peer_port"`
+	Mountpoint   string                 `json:"mountpoint"`
+	ClientID     string                 `json:"client_id"`
+	Username     string                 `json:"username"`
+	Password     string                 `json:"password"`
+	CleanSession bool                   `json:"clean_session"`
+	CleanStart   bool                   `json:"clean_start"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+}
+
+// AuthOnSubscribeBody : Request Body on VerneMQ auth_on_subscribe & auth_on_subscribe_m5 hooks
+type AuthOnSubscribeBody struct {
+	Mountpoint string                 `json:"mountpoint"`
+	ClientID   string                 `json:"client_id"`
+	Username   string                 `json:"username"`
+	Topics     []WebhookTopic         `json:"topics"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// AuthOnPublishBody : Request Body on VerneMQ auth_on_publish & auth_on_publish_m5 hooks
+type AuthOnPublishBody struct {
+	Mountpoint string                 `json:"mountpoint"`
+	ClientID   string                 `json:"client_id"`
+	Username   string                 `json:"username"`
+	QoS        int                    `json:"qos"`
+	Topic      string                 `json:"topic"`
+	Payload    string                 `json:"payload"`
+	Retain     bool                   `json:"retain"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// WebhookResultBody : Response Body on VerneMQ hooks accepting or rejecting a request as a whole
+type WebhookResultBody struct {
+	Result interface{} `json:"result"`
+}
+
+// WebhookSubscribeResultBody : Response Body on VerneMQ auth_on_subscribe hook
+type WebhookSubscribeResultBody struct {
+	Result string         `json:"result"`
+	Topics []WebhookTopic `json:"topics"`
+}
+
+// WebhookSubscribeM5ResultBody : Response Body on VerneMQ auth_on_subscribe_m5 hook
+type WebhookSubscribeM5ResultBody struct {
+	Result    string                    `json:"result"`
+	Modifiers WebhookSubscribeModifiers `json:"modifiers"`
+}
+
+// WebhookSubscribeModifiers : Modifiers returned on VerneMQ auth_on_subscribe_m5 hook
+type WebhookSubscribeModifiers struct {
+	Topics []WebhookTopic `json:"topics"`
+}
+
+// WebhookErrorBody : Error entry returned to VerneMQ when a request is rejected
+type WebhookErrorBody struct {
+	Error string `json:"error"`
+}
@@ -16,7 +16,7 @@ func IsTokenValid(env *models.Env, s string) (bool, error) {
 	}
 
 	// RegexToken : Regex validation for token (Provided by use through environment variable)
-	RegexToken := regexp.MustCompile(env.Config.TokenValidationRegex)
+	RegexToken := regexp.MustCompile(env.Config().TokenValidationRegex)
 
 	return RegexToken.MatchString(s), nil
 }